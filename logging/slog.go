@@ -83,7 +83,7 @@ func dynamicLoggingBasedOnFlag() {
 	viper.BindPFlags(pflag.CommandLine)
 	//parse pflag and set correct logging level
 	l := slog.New(slog.NewTextHandler(os.Stdout, &slog.HandlerOptions{
-		Level: stringToSlogLevel(viper.GetString("log")),
+		Level: StringToSlogLevel(viper.GetString("log")),
 	}))
 	l.Info("info")
 	l.Debug("debug")
@@ -101,7 +101,8 @@ func (u user) LogValue() slog.Value { //now logging will hide sensitive data
 	)
 }
 
-func stringToSlogLevel(levelStr string) slog.Leveler {
+// StringToSlogLevel maps case-insensitive level name to slog level, unknown names fall back to INFO
+func StringToSlogLevel(levelStr string) slog.Leveler {
 	switch strings.ToUpper(levelStr) {
 	case "DEBUG":
 		return slog.LevelDebug
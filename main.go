@@ -2,6 +2,7 @@ package main
 
 import (
 	_ "embed"
+	"github.com/spf13/cobra"
 	"go-examples/rest"
 	"log"
 	"regexp"
@@ -32,5 +33,6 @@ func main() {
 	//network.RunDialListenTcpIp()
 	//network.RunHttpExample()
 	//network.RunWebsocketExample()
-	rest.StartRestAPIExample()
+	//serves rest api, "migrate up|down|version" manages its schema
+	cobra.CheckErr(rest.Command().Execute())
 }
@@ -0,0 +1,79 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"go-examples/rest/model"
+	"go-examples/rest/repository"
+	"net/http"
+)
+
+type APIKeyRepository interface {
+	Create(ctx context.Context, postKey *model.PostAPIKey) (*model.CreatedAPIKey, error)
+	Rotate(ctx context.Context, id string) (*model.CreatedAPIKey, error)
+	Revoke(ctx context.Context, id string) error
+}
+
+type APIKeyAPI interface {
+	CreateKey(context *gin.Context)
+	RotateKey(context *gin.Context)
+	RevokeKey(context *gin.Context)
+}
+
+type apiKeyAPI struct {
+	apiKeyRepository APIKeyRepository
+}
+
+func NewAPIKeyAPI(apiKeyRepository APIKeyRepository) APIKeyAPI {
+	return &apiKeyAPI{apiKeyRepository: apiKeyRepository}
+}
+
+func (apiKeyAPI *apiKeyAPI) CreateKey(context *gin.Context) {
+	postKey := new(model.PostAPIKey)
+	err := context.ShouldBindJSON(postKey)
+	if err != nil {
+		AbortWithBindingError(context, model.ErrorCodeInvalidRequest, "invalid request", err)
+		return
+	}
+	created, err := apiKeyAPI.apiKeyRepository.Create(context, postKey)
+	if err != nil {
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error creating api key", err)
+		return
+	}
+	context.JSON(http.StatusCreated, created)
+}
+
+func (apiKeyAPI *apiKeyAPI) RotateKey(context *gin.Context) {
+	id, ok := bindID(context)
+	if !ok {
+		return
+	}
+	created, err := apiKeyAPI.apiKeyRepository.Rotate(context, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrAPIKeyNotFound) {
+			Abort(context, http.StatusNotFound, model.ErrorCodeAPIKeyNotFound, "api key not found")
+			return
+		}
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error rotating api key", err)
+		return
+	}
+	context.JSON(http.StatusCreated, created)
+}
+
+func (apiKeyAPI *apiKeyAPI) RevokeKey(context *gin.Context) {
+	id, ok := bindID(context)
+	if !ok {
+		return
+	}
+	err := apiKeyAPI.apiKeyRepository.Revoke(context, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrAPIKeyNotFound) {
+			Abort(context, http.StatusNotFound, model.ErrorCodeAPIKeyNotFound, "api key not found")
+			return
+		}
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error revoking api key", err)
+		return
+	}
+	context.Status(http.StatusNoContent)
+}
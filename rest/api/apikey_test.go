@@ -0,0 +1,128 @@
+package api
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"go-examples/rest/model"
+	"go-examples/rest/repository"
+	"go-examples/rest/test"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var testKeyId = "0c9e3a7b-8d2f-4e61-b5a4-7f3d2c1b0a99"
+
+type APIKeySuite struct {
+	suite.Suite
+	repositoryMock *test.APIKeyRepositoryMock
+	apiKeyAPI      APIKeyAPI
+	ctx            *gin.Context
+	recorder       *httptest.ResponseRecorder
+}
+
+func TestAPIKeySuite(t *testing.T) {
+	suite.Run(t, new(APIKeySuite))
+}
+
+func (suite *APIKeySuite) BeforeTest(suiteName, testName string) {
+	gin.SetMode(gin.TestMode)
+	suite.repositoryMock = new(test.APIKeyRepositoryMock)
+	suite.apiKeyAPI = NewAPIKeyAPI(suite.repositoryMock)
+	suite.recorder = httptest.NewRecorder()
+	suite.ctx, _ = gin.CreateTestContext(suite.recorder)
+	suite.ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+}
+
+func (suite *APIKeySuite) TestCreateKeySuccess() {
+	//given
+	postKey := &model.PostAPIKey{Owner: "owner", Scopes: []string{"users:read"}}
+	suite.repositoryMock.On("Create", postKey).
+		Return(&model.CreatedAPIKey{APIKey: model.APIKey{ID: testKeyId, Owner: "owner", Scopes: []string{"users:read"}}, Key: "secret"}, nil)
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/keys", strings.NewReader(`{"owner": "owner", "scopes": ["users:read"]}`))
+
+	//when
+	suite.apiKeyAPI.CreateKey(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusCreated, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), `"key":"secret"`)
+	require.Contains(suite.T(), suite.recorder.Body.String(), fmt.Sprintf(`"id":"%s"`, testKeyId))
+}
+
+func (suite *APIKeySuite) TestCreateKeyInvalidRequest() {
+	//given
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/keys", strings.NewReader(`{"scopes": ["users:read"]}`))
+
+	//when
+	suite.apiKeyAPI.CreateKey(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusBadRequest, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), "invalid request")
+}
+
+func (suite *APIKeySuite) TestRotateKeySuccess() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testKeyId})
+	suite.repositoryMock.On("Rotate", testKeyId).Return(&model.CreatedAPIKey{APIKey: model.APIKey{ID: "new-id"}, Key: "new-secret"}, nil)
+
+	//when
+	suite.apiKeyAPI.RotateKey(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusCreated, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), `"key":"new-secret"`)
+}
+
+func (suite *APIKeySuite) TestRotateKeyNotFound() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testKeyId})
+	suite.repositoryMock.On("Rotate", testKeyId).Return((*model.CreatedAPIKey)(nil), repository.ErrAPIKeyNotFound)
+
+	//when
+	suite.apiKeyAPI.RotateKey(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusNotFound, suite.recorder.Code)
+}
+
+func (suite *APIKeySuite) TestRevokeKeySuccess() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testKeyId})
+	suite.repositoryMock.On("Revoke", testKeyId).Return(nil)
+
+	//when
+	suite.apiKeyAPI.RevokeKey(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusNoContent, suite.ctx.Writer.Status())
+}
+
+func (suite *APIKeySuite) TestRevokeKeyNotFound() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testKeyId})
+	suite.repositoryMock.On("Revoke", testKeyId).Return(repository.ErrAPIKeyNotFound)
+
+	//when
+	suite.apiKeyAPI.RevokeKey(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusNotFound, suite.recorder.Code)
+}
+
+func (suite *APIKeySuite) TestRevokeKeyRepositoryError() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testKeyId})
+	suite.repositoryMock.On("Revoke", testKeyId).Return(fmt.Errorf("db error"))
+
+	//when
+	suite.apiKeyAPI.RevokeKey(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusInternalServerError, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), "error revoking api key")
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"go-examples/rest/model"
+	"go-examples/rest/repository"
+	"io"
+	"net/http"
+	"slices"
+	"strings"
+)
+
+const (
+	ndjsonContentType = "application/x-ndjson"
+	csvContentType    = "text/csv"
+	//stop reading import body after that many invalid rows
+	maxImportErrors = 100
+	//flush export response every that many rows so client receives data continuously
+	exportFlushEvery = 1000
+)
+
+// csvUserHeader columns of users in csv, import reads email column of the same layout
+var csvUserHeader = []string{"id", "email"}
+
+var (
+	errInvalidRows    = errors.New("import contains invalid rows")
+	errUnreadableBody = errors.New("unreadable request body")
+)
+
+// rowError is returned by userReader when single row is invalid, reading can continue
+type rowError struct {
+	line int
+	err  error
+}
+
+func (e *rowError) Error() string {
+	return fmt.Sprintf("line %d: %v", e.line, e.err)
+}
+
+type userReader interface {
+	// Read returns io.EOF at the end, *rowError for invalid row, any other error means body can't be read further
+	Read() (*model.PostUser, error)
+}
+
+// decoders of Content-Encoding accepted for import body
+var decoders = map[string]func(io.Reader) (io.ReadCloser, error){
+	"gzip": func(body io.Reader) (io.ReadCloser, error) {
+		return gzip.NewReader(body)
+	},
+	"zstd": func(body io.Reader) (io.ReadCloser, error) {
+		decoder, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return decoder.IOReadCloser(), nil
+	},
+	"br": func(body io.Reader) (io.ReadCloser, error) {
+		return io.NopCloser(brotli.NewReader(body)), nil
+	},
+}
+
+// ImportUsers streams NDJSON or CSV body straight into the database, body is never loaded into memory at once.
+// Import is all or nothing - when any row is invalid nothing is imported and per row errors are returned.
+func (userAPI *userAPI) ImportUsers(context *gin.Context) {
+	if !decodeBody(context) {
+		return
+	}
+	defer context.Request.Body.Close()
+	var reader userReader
+	switch context.ContentType() {
+	case ndjsonContentType:
+		reader = newNDJSONUserReader(context.Request.Body)
+	case csvContentType:
+		csvReader, err := newCSVUserReader(context.Request.Body)
+		if err != nil {
+			if AbortWithBodyError(context, err) {
+				return
+			}
+			Abort(context, http.StatusBadRequest, model.ErrorCodeInvalidCSVHeader, "invalid csv header")
+			return
+		}
+		reader = csvReader
+	default:
+		Abort(context, http.StatusUnsupportedMediaType, model.ErrorCodeUnsupportedMediaType, "unsupported import content type")
+		return
+	}
+	result := new(model.ImportResult)
+	next := func() (*model.PostUser, error) {
+		for {
+			user, err := reader.Read()
+			var invalidRow *rowError
+			switch {
+			case errors.Is(err, io.EOF) && len(result.Errors) > 0:
+				return nil, errInvalidRows
+			case errors.Is(err, io.EOF):
+				return nil, io.EOF
+			case errors.As(err, &invalidRow):
+				result.Errors = append(result.Errors, model.ImportError{Line: invalidRow.line, Message: invalidRow.err.Error()})
+			case err != nil:
+				return nil, errors.Join(errUnreadableBody, err)
+			}
+			if len(result.Errors) >= maxImportErrors {
+				return nil, errInvalidRows
+			}
+			//once any row is invalid nothing will be imported, remaining rows are only validated
+			if err == nil && len(result.Errors) == 0 {
+				return user, nil
+			}
+		}
+	}
+	imported, err := userAPI.userRepository.Import(context, next)
+	if err != nil {
+		switch {
+		case errors.Is(err, errInvalidRows):
+			context.JSON(http.StatusUnprocessableEntity, result)
+		case errors.Is(err, errUnreadableBody) && AbortWithBodyError(context, err):
+		case errors.Is(err, errUnreadableBody):
+			Abort(context, http.StatusBadRequest, model.ErrorCodeInvalidRequest, "invalid request body")
+		case errors.Is(err, repository.ErrUserAlreadyExists):
+			Abort(context, http.StatusConflict, model.ErrorCodeUserAlreadyExists, "user already exists")
+		default:
+			AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error importing users", err)
+		}
+		return
+	}
+	result.Imported = imported
+	context.JSON(http.StatusOK, result)
+}
+
+// ExportUsers streams all users as NDJSON or CSV depending on Accept header.
+// Error after first rows were sent can't change the status anymore, response is truncated instead.
+func (userAPI *userAPI) ExportUsers(context *gin.Context) {
+	format := context.NegotiateFormat(ndjsonContentType, csvContentType)
+	if format == "" {
+		Abort(context, http.StatusNotAcceptable, model.ErrorCodeNotAcceptable, "unsupported export format")
+		return
+	}
+	context.Header("Content-Type", format)
+	buffered := bufio.NewWriter(context.Writer)
+	var write func(*model.User) error
+	switch format {
+	case ndjsonContentType:
+		encoder := json.NewEncoder(buffered)
+		write = func(user *model.User) error {
+			return encoder.Encode(user)
+		}
+	case csvContentType:
+		csvWriter := csv.NewWriter(buffered)
+		_ = csvWriter.Write(csvUserHeader)
+		write = func(user *model.User) error {
+			if err := csvWriter.Write(csvUserRecord(user)); err != nil {
+				return err
+			}
+			//csv writer buffers on its own, drained on every row to keep single flush point
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+	}
+	exported := 0
+	err := userAPI.userRepository.ExportUsers(context, func(user *model.User) error {
+		if err := write(user); err != nil {
+			return err
+		}
+		if exported++; exported%exportFlushEvery == 0 {
+			if err := buffered.Flush(); err != nil {
+				return err
+			}
+			context.Writer.Flush()
+		}
+		return nil
+	})
+	if err != nil {
+		if !context.Writer.Written() {
+			AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error exporting users", err)
+			return
+		}
+		_ = context.Error(fmt.Errorf("error exporting users: %w", err))
+		context.Abort()
+		return
+	}
+	if err := buffered.Flush(); err != nil {
+		_ = context.Error(fmt.Errorf("error exporting users: %w", err))
+	}
+}
+
+func csvUserRecord(user *model.User) []string {
+	return []string{user.ID, user.Email}
+}
+
+// decodeBody replaces compressed body with decompressed one. It's limited the same as uncompressed body would be,
+// so small body can't expand without bound. Returns false when error response was sent.
+func decodeBody(context *gin.Context) bool {
+	encoding := strings.ToLower(strings.TrimSpace(context.GetHeader("Content-Encoding")))
+	if encoding == "" || encoding == "identity" {
+		return true
+	}
+	decode, ok := decoders[encoding]
+	if !ok {
+		Abort(context, http.StatusUnsupportedMediaType, model.ErrorCodeUnsupportedMediaType, "unsupported content encoding")
+		return false
+	}
+	body, err := decode(context.Request.Body)
+	if err != nil {
+		if !AbortWithBodyError(context, err) {
+			Abort(context, http.StatusBadRequest, model.ErrorCodeInvalidRequest, "invalid request body")
+		}
+		return false
+	}
+	if limit, ok := context.Get(model.BodyLimitKey); ok {
+		body = http.MaxBytesReader(context.Writer, body, limit.(int64))
+	}
+	context.Request.Body = body
+	return true
+}
+
+type ndjsonUserReader struct {
+	scanner *bufio.Scanner
+	line    int
+}
+
+func newNDJSONUserReader(body io.Reader) *ndjsonUserReader {
+	return &ndjsonUserReader{scanner: bufio.NewScanner(body)}
+}
+
+func (reader *ndjsonUserReader) Read() (*model.PostUser, error) {
+	for reader.scanner.Scan() {
+		reader.line++
+		line := bytes.TrimSpace(reader.scanner.Bytes())
+		if len(line) == 0 {
+			continue
+		}
+		decoder := json.NewDecoder(bytes.NewReader(line))
+		decoder.DisallowUnknownFields()
+		user := new(model.PostUser)
+		if err := decoder.Decode(user); err != nil {
+			return nil, &rowError{line: reader.line, err: err}
+		}
+		return validateRow(reader.line, user)
+	}
+	if err := reader.scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, io.EOF
+}
+
+type csvUserReader struct {
+	reader      *csv.Reader
+	emailColumn int
+}
+
+// newCSVUserReader reads header first, it has to contain email column
+func newCSVUserReader(body io.Reader) (*csvUserReader, error) {
+	reader := csv.NewReader(body)
+	reader.ReuseRecord = true
+	header, err := reader.Read()
+	if err != nil {
+		return nil, err
+	}
+	emailColumn := slices.Index(header, "email")
+	if emailColumn < 0 {
+		return nil, errors.New("missing email column")
+	}
+	return &csvUserReader{reader: reader, emailColumn: emailColumn}, nil
+}
+
+func (reader *csvUserReader) Read() (*model.PostUser, error) {
+	record, err := reader.reader.Read()
+	if err != nil {
+		var parseErr *csv.ParseError
+		if errors.As(err, &parseErr) {
+			return nil, &rowError{line: parseErr.Line, err: parseErr.Err}
+		}
+		return nil, err
+	}
+	line, _ := reader.reader.FieldPos(0)
+	return validateRow(line, &model.PostUser{Email: record[reader.emailColumn]})
+}
+
+// validateRow applies the same normalization and rules as PostUser binding does, row error names failing fields
+func validateRow(line int, user *model.PostUser) (*model.PostUser, error) {
+	if err := validate(user); err != nil {
+		if fields := fieldErrors(err); len(fields) > 0 {
+			messages := make([]string, 0, len(fields))
+			for _, field := range fields {
+				messages = append(messages, field.Field+" "+field.Message)
+			}
+			err = errors.New(strings.Join(messages, ", "))
+		}
+		return nil, &rowError{line: line, err: err}
+	}
+	return user, nil
+}
@@ -0,0 +1,254 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/model"
+	"go-examples/rest/repository"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func (suite *UserSuite) TestImportUsersSuccess() {
+	testData := []struct {
+		contentType string
+		body        string
+	}{
+		{ndjsonContentType, "{\"email\": \"a@example.com\"}\n\n{\"email\": \"b@example.com\"}\n"},
+		{csvContentType, "name,email\nA,a@example.com\nB,b@example.com\n"},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.BeforeTest("", "")
+		suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users/import", strings.NewReader(testCase.body))
+		suite.ctx.Request.Header.Set("Content-Type", testCase.contentType)
+		suite.repositoryMock.On("Import", []*model.PostUser{{Email: "a@example.com"}, {Email: "b@example.com"}}).Return(int64(2), nil)
+
+		//when
+		suite.userAPI.ImportUsers(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), http.StatusOK, suite.recorder.Code, testCase.contentType)
+		require.JSONEq(suite.T(), `{"imported": 2}`, suite.recorder.Body.String())
+	}
+}
+
+func (suite *UserSuite) TestImportUsersCompressed() {
+	body := "{\"email\": \"a@example.com\"}\n{\"email\": \"b@example.com\"}\n"
+	for _, encoding := range []string{"gzip", "zstd", "br"} {
+		//given
+		suite.BeforeTest("", "")
+		suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users/import", bytes.NewReader(compress(suite.T(), encoding, body)))
+		suite.ctx.Request.Header.Set("Content-Type", ndjsonContentType)
+		suite.ctx.Request.Header.Set("Content-Encoding", encoding)
+		suite.repositoryMock.On("Import", []*model.PostUser{{Email: "a@example.com"}, {Email: "b@example.com"}}).Return(int64(2), nil)
+
+		//when
+		suite.userAPI.ImportUsers(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), http.StatusOK, suite.recorder.Code, encoding)
+		require.JSONEq(suite.T(), `{"imported": 2}`, suite.recorder.Body.String())
+	}
+}
+
+func (suite *UserSuite) TestImportUsersDecompressedBodyLimited() {
+	//given body limit applies to decompressed body too
+	body := strings.Repeat("{\"email\": \"a@example.com\"}\n", 1000)
+	compressed := compress(suite.T(), "gzip", body)
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users/import", bytes.NewReader(compressed))
+	suite.ctx.Request.Header.Set("Content-Type", ndjsonContentType)
+	suite.ctx.Request.Header.Set("Content-Encoding", "gzip")
+	suite.ctx.Set(model.BodyLimitKey, int64(len(compressed)*2))
+	suite.repositoryMock.On("Import", mock.Anything).Return(int64(0), nil)
+
+	//when
+	suite.userAPI.ImportUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusRequestEntityTooLarge, suite.recorder.Code)
+}
+
+func (suite *UserSuite) TestImportUsersUnsupportedEncoding() {
+	testData := []struct {
+		encoding     string
+		expectedCode int
+	}{
+		{"compress", http.StatusUnsupportedMediaType},
+		//not gzip at all
+		{"gzip", http.StatusBadRequest},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.BeforeTest("", "")
+		suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users/import", strings.NewReader("email\na@example.com\n"))
+		suite.ctx.Request.Header.Set("Content-Type", csvContentType)
+		suite.ctx.Request.Header.Set("Content-Encoding", testCase.encoding)
+
+		//when
+		suite.userAPI.ImportUsers(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), testCase.expectedCode, suite.recorder.Code, testCase.encoding)
+		suite.repositoryMock.AssertNotCalled(suite.T(), "Import", mock.Anything)
+	}
+}
+
+func (suite *UserSuite) TestImportUsersInvalidRows() {
+	testData := []struct {
+		contentType   string
+		body          string
+		expectedLines []int
+	}{
+		{ndjsonContentType, "{\"email\": \"a@example.com\"}\n{\"email\": \"\"}\nnot json\n{\"email\": \"b@example.com\", \"id\": \"1\"}\n", []int{2, 3, 4}},
+		{csvContentType, "email\na@example.com\n\"\"\nb@example.com,extra\nnot-an-email\n", []int{3, 4, 5}},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.BeforeTest("", "")
+		suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users/import", strings.NewReader(testCase.body))
+		suite.ctx.Request.Header.Set("Content-Type", testCase.contentType)
+
+		//when
+		suite.userAPI.ImportUsers(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), http.StatusUnprocessableEntity, suite.recorder.Code, testCase.contentType)
+		for _, line := range testCase.expectedLines {
+			require.Contains(suite.T(), suite.recorder.Body.String(), fmt.Sprintf(`"line":%d`, line))
+		}
+		require.Contains(suite.T(), suite.recorder.Body.String(), `"imported":0`)
+		require.Contains(suite.T(), suite.recorder.Body.String(), "email is required")
+		suite.repositoryMock.AssertNotCalled(suite.T(), "Import", mock.Anything)
+	}
+}
+
+func (suite *UserSuite) TestImportUsersTooManyInvalidRows() {
+	//given
+	body := strings.Repeat("{\"email\": \"\"}\n", maxImportErrors*2)
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users/import", strings.NewReader(body))
+	suite.ctx.Request.Header.Set("Content-Type", ndjsonContentType)
+
+	//when
+	suite.userAPI.ImportUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusUnprocessableEntity, suite.recorder.Code)
+	require.Equal(suite.T(), maxImportErrors, strings.Count(suite.recorder.Body.String(), `"line"`))
+}
+
+func (suite *UserSuite) TestImportUsersInvalidCSVHeader() {
+	//given
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users/import", strings.NewReader("name\nA\n"))
+	suite.ctx.Request.Header.Set("Content-Type", csvContentType)
+
+	//when
+	suite.userAPI.ImportUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusBadRequest, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), "invalid csv header")
+}
+
+func (suite *UserSuite) TestImportUsersUnsupportedContentType() {
+	//given
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users/import", strings.NewReader(`[{"email": "a@example.com"}]`))
+	suite.ctx.Request.Header.Set("Content-Type", "application/json")
+
+	//when
+	suite.userAPI.ImportUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusUnsupportedMediaType, suite.recorder.Code)
+}
+
+func (suite *UserSuite) TestImportUsersAlreadyExists() {
+	//given
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users/import", strings.NewReader("{\"email\": \"a@example.com\"}\n"))
+	suite.ctx.Request.Header.Set("Content-Type", ndjsonContentType)
+	suite.repositoryMock.On("Import", mock.Anything).Return(int64(0), repository.ErrUserAlreadyExists)
+
+	//when
+	suite.userAPI.ImportUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusConflict, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), model.ErrorCodeUserAlreadyExists)
+}
+
+func (suite *UserSuite) TestExportUsers() {
+	testData := []struct {
+		accept       string
+		expectedType string
+		expectedBody string
+	}{
+		{"", ndjsonContentType, "{\"id\":\"1\",\"email\":\"a@example.com\",\"created_at\":\"0001-01-01T00:00:00Z\",\"updated_at\":\"0001-01-01T00:00:00Z\"}\n" +
+			"{\"id\":\"2\",\"email\":\"b@example.com\",\"created_at\":\"0001-01-01T00:00:00Z\",\"updated_at\":\"0001-01-01T00:00:00Z\"}\n"},
+		{"text/csv", csvContentType, "id,email\n1,a@example.com\n2,b@example.com\n"},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.BeforeTest("", "")
+		suite.ctx.Request, _ = http.NewRequest(http.MethodGet, "/users/export", nil)
+		suite.ctx.Request.Header.Set("Accept", testCase.accept)
+		suite.repositoryMock.On("ExportUsers").Return([]*model.User{{ID: "1", Email: "a@example.com"}, {ID: "2", Email: "b@example.com"}}, nil)
+
+		//when
+		suite.userAPI.ExportUsers(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+		require.Equal(suite.T(), testCase.expectedType, suite.recorder.Header().Get("Content-Type"))
+		require.Equal(suite.T(), testCase.expectedBody, suite.recorder.Body.String())
+	}
+}
+
+func (suite *UserSuite) TestExportUsersNotAcceptable() {
+	//given
+	suite.ctx.Request, _ = http.NewRequest(http.MethodGet, "/users/export", nil)
+	suite.ctx.Request.Header.Set("Accept", "application/xml")
+
+	//when
+	suite.userAPI.ExportUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusNotAcceptable, suite.recorder.Code)
+}
+
+func (suite *UserSuite) TestExportUsersRepositoryError() {
+	//given
+	suite.ctx.Request, _ = http.NewRequest(http.MethodGet, "/users/export", nil)
+	suite.repositoryMock.On("ExportUsers").Return([]*model.User{}, fmt.Errorf("db error"))
+
+	//when
+	suite.userAPI.ExportUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusInternalServerError, suite.recorder.Code)
+	require.Equal(suite.T(), model.ProblemContentType, suite.recorder.Header().Get("Content-Type"))
+	require.Contains(suite.T(), suite.recorder.Body.String(), "error exporting users")
+}
+
+func compress(t *testing.T, encoding string, body string) []byte {
+	buffer := new(bytes.Buffer)
+	var writer io.WriteCloser
+	switch encoding {
+	case "gzip":
+		writer = gzip.NewWriter(buffer)
+	case "zstd":
+		writer, _ = zstd.NewWriter(buffer)
+	case "br":
+		writer = brotli.NewWriter(buffer)
+	}
+	_, err := io.WriteString(writer, body)
+	require.NoError(t, err)
+	require.NoError(t, writer.Close())
+	return buffer.Bytes()
+}
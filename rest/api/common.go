@@ -1,18 +1,72 @@
 package api
 
 import (
+	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"go-examples/rest/model"
+	"net"
+	"net/http"
+	"time"
 )
 
-func AbortWithContextError(context *gin.Context, status int, message string, err error) {
-	context.JSON(status, model.NewError(message))
-	context.Error(fmt.Errorf("%s: %w", message, err))
-	context.Abort()
+// AbortWithContextError err is attached to context to be logged, it's never exposed to client
+func AbortWithContextError(context *gin.Context, status int, code string, detail string, err error) {
+	_ = context.Error(fmt.Errorf("%s: %w", detail, err))
+	abort(context, newProblem(context, status, code, detail))
+}
+
+// Abort responds with application/problem+json, the only place error responses are built
+func Abort(context *gin.Context, status int, code string, detail string) {
+	abort(context, newProblem(context, status, code, detail))
+}
+
+// AbortWithBindingError responds 400 listing invalid fields when binding or validation failed because of them,
+// body that couldn't be read completely is reported as by AbortWithBodyError
+func AbortWithBindingError(context *gin.Context, code string, detail string, err error) {
+	if AbortWithBodyError(context, err) {
+		return
+	}
+	problem := newProblem(context, http.StatusBadRequest, code, detail)
+	problem.Errors = fieldErrors(err)
+	abort(context, problem)
+}
+
+// AbortWithBodyError responds 413 when request body exceeded its limit and 408 when client didn't send it in time,
+// reports whether err was one of them
+func AbortWithBodyError(context *gin.Context, err error) bool {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		Abort(context, http.StatusRequestEntityTooLarge, model.ErrorCodeRequestTooLarge, fmt.Sprintf("request body exceeds %d bytes", tooLarge.Limit))
+		return true
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		AbortWithContextError(context, http.StatusRequestTimeout, model.ErrorCodeRequestTimeout, "request body not received in time", err)
+		return true
+	}
+	return false
+}
+
+func newProblem(context *gin.Context, status int, code string, detail string) *model.Problem {
+	problem := &model.Problem{
+		Type:      model.ProblemTypePrefix + code,
+		Title:     http.StatusText(status),
+		Status:    status,
+		Detail:    detail,
+		Code:      code,
+		RequestID: model.RequestIDFrom(context),
+		Timestamp: time.Now().UTC().Format(time.RFC3339),
+	}
+	if context.Request != nil {
+		problem.Instance = context.Request.URL.Path
+	}
+	return problem
 }
 
-func Abort(context *gin.Context, status int, message string) {
-	context.JSON(status, model.NewError(message))
+func abort(context *gin.Context, problem *model.Problem) {
+	//overrides content type of streamed response that failed before anything was written
+	context.Header("Content-Type", model.ProblemContentType)
+	context.JSON(problem.Status, problem)
 	context.Abort()
 }
@@ -8,7 +8,10 @@ import (
 	"go-examples/rest/model"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestAbortWithContextError(t *testing.T) {
@@ -16,12 +19,13 @@ func TestAbortWithContextError(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	gin.SetMode(gin.TestMode)
 	testCtx, _ := gin.CreateTestContext(recorder)
+	testCtx.Request = httptest.NewRequest("GET", "/api/v1/users", nil)
 	status := http.StatusTeapot
-	message := "user message"
+	detail := "user message"
 	err := fmt.Errorf("error details")
 
 	//when
-	AbortWithContextError(testCtx, status, message, err)
+	AbortWithContextError(testCtx, status, model.ErrorCodeInternal, detail, err)
 
 	//then
 	require.Equal(t, status, testCtx.Writer.Status())
@@ -29,11 +33,10 @@ func TestAbortWithContextError(t *testing.T) {
 	require.NotNil(t, testCtx.Errors.Last())
 	require.Equal(t, "user message: error details", testCtx.Errors.Last().Err.Error())
 
-	errorBody := new(model.Error)
-	err = json.Unmarshal(recorder.Body.Bytes(), errorBody)
-	require.NoError(t, err)
-	require.Equal(t, message, errorBody.Message)
-	require.NotEmpty(t, errorBody.Timestamp)
+	problem := decodeProblem(t, recorder)
+	require.Equal(t, detail, problem.Detail)
+	require.Equal(t, model.ErrorCodeInternal, problem.Code)
+	require.NotContains(t, recorder.Body.String(), "error details")
 }
 
 func TestAbort(t *testing.T) {
@@ -41,20 +44,127 @@ func TestAbort(t *testing.T) {
 	recorder := httptest.NewRecorder()
 	gin.SetMode(gin.TestMode)
 	testCtx, _ := gin.CreateTestContext(recorder)
-	status := http.StatusTeapot
-	message := "user message"
+	testCtx.Request = httptest.NewRequest("GET", "/api/v1/users/abc", nil)
+	testCtx.Set(model.RequestIDKey, "request-id")
+	//streamed response content type is replaced
+	testCtx.Header("Content-Type", "application/x-ndjson")
 
 	//when
-	Abort(testCtx, status, message)
+	Abort(testCtx, http.StatusNotFound, model.ErrorCodeUserNotFound, "user not found")
 
 	//then
-	require.Equal(t, status, testCtx.Writer.Status())
+	require.Equal(t, http.StatusNotFound, testCtx.Writer.Status())
 	require.Equal(t, true, testCtx.IsAborted())
 	require.Nil(t, testCtx.Errors.Last())
+	require.Equal(t, model.ProblemContentType, recorder.Header().Get("Content-Type"))
 
-	errorBody := new(model.Error)
-	err := json.Unmarshal(recorder.Body.Bytes(), errorBody)
+	problem := decodeProblem(t, recorder)
+	require.Equal(t, "urn:rest-app:problem:user_not_found", problem.Type)
+	require.Equal(t, "Not Found", problem.Title)
+	require.Equal(t, http.StatusNotFound, problem.Status)
+	require.Equal(t, "user not found", problem.Detail)
+	require.Equal(t, "/api/v1/users/abc", problem.Instance)
+	require.Equal(t, model.ErrorCodeUserNotFound, problem.Code)
+	require.Equal(t, "request-id", problem.RequestID)
+	_, err := time.Parse(time.RFC3339, problem.Timestamp)
 	require.NoError(t, err)
-	require.Equal(t, message, errorBody.Message)
-	require.NotEmpty(t, errorBody.Timestamp)
+	require.Empty(t, problem.Errors)
+}
+
+func TestAbortWithBindingError(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name     string
+		body     string
+		target   any
+		expected []model.FieldError
+	}{
+		{"missing field", `{}`, new(model.PostUser), []model.FieldError{
+			{Field: "email", Rule: "required", Message: "is required"},
+		}},
+		{"too long field", `{"owner":"` + strings.Repeat("a", 256) + `"}`, new(model.PostAPIKey), []model.FieldError{
+			{Field: "owner", Rule: "max", Message: "must be at most 255"},
+		}},
+		{"wrong type", `{"email":1}`, new(model.PostUser), []model.FieldError{
+			{Field: "email", Rule: "type", Message: "must be string"},
+		}},
+		{"malformed json", `{"email":`, new(model.PostUser), nil},
+	}
+	for _, test := range tests {
+		//given
+		recorder := httptest.NewRecorder()
+		testCtx, _ := gin.CreateTestContext(recorder)
+		testCtx.Request = httptest.NewRequest("POST", "/api/v1/users", strings.NewReader(test.body))
+		err := testCtx.ShouldBindJSON(test.target)
+		require.Error(t, err, test.name)
+
+		//when
+		AbortWithBindingError(testCtx, model.ErrorCodeInvalidRequest, "invalid request", err)
+
+		//then
+		require.Equal(t, http.StatusBadRequest, recorder.Code, test.name)
+		problem := decodeProblem(t, recorder)
+		require.Equal(t, model.ErrorCodeInvalidRequest, problem.Code, test.name)
+		require.Equal(t, test.expected, problem.Errors, test.name)
+	}
+}
+
+func TestAbortWithBindingErrorQuery(t *testing.T) {
+	//given
+	recorder := httptest.NewRecorder()
+	gin.SetMode(gin.TestMode)
+	testCtx, _ := gin.CreateTestContext(recorder)
+	testCtx.Request = httptest.NewRequest("GET", "/api/v1/users?limit=101&sort=name", nil)
+	err := testCtx.ShouldBindQuery(new(model.UserQuery))
+
+	//when
+	AbortWithBindingError(testCtx, model.ErrorCodeInvalidQuery, "invalid query", err)
+
+	//then query parameters are named as in the url
+	problem := decodeProblem(t, recorder)
+	require.Equal(t, []model.FieldError{
+		{Field: "limit", Rule: "max", Message: "must be at most 100"},
+		{Field: "sort", Rule: "oneof", Message: "must be one of id -id email -email"},
+	}, problem.Errors)
+}
+
+func TestAbortWithBodyError(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		status int
+		code   string
+	}{
+		{"too large", &http.MaxBytesError{Limit: 4}, http.StatusRequestEntityTooLarge, model.ErrorCodeRequestTooLarge},
+		{"timeout", fmt.Errorf("read: %w", os.ErrDeadlineExceeded), http.StatusRequestTimeout, model.ErrorCodeRequestTimeout},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//given
+			recorder := httptest.NewRecorder()
+			gin.SetMode(gin.TestMode)
+			testCtx, _ := gin.CreateTestContext(recorder)
+			testCtx.Request = httptest.NewRequest("POST", "/api/v1/users", nil)
+
+			//when binding fails because of body
+			AbortWithBindingError(testCtx, model.ErrorCodeInvalidRequest, "invalid user", tt.err)
+
+			//then
+			require.Equal(t, tt.status, recorder.Code)
+			require.Equal(t, tt.code, decodeProblem(t, recorder).Code)
+		})
+	}
+
+	//when error isn't caused by body
+	testCtx, _ := gin.CreateTestContext(httptest.NewRecorder())
+
+	//then
+	require.False(t, AbortWithBodyError(testCtx, fmt.Errorf("invalid json")))
+	require.False(t, testCtx.IsAborted())
+}
+
+func decodeProblem(t *testing.T, recorder *httptest.ResponseRecorder) *model.Problem {
+	problem := new(model.Problem)
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), problem))
+	return problem
 }
@@ -0,0 +1,28 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-examples/rest/config"
+	"net/http"
+)
+
+type ConfigSource interface {
+	Get() *config.AppConfig
+}
+
+type ConfigAPI interface {
+	GetConfig(context *gin.Context)
+}
+
+type configAPI struct {
+	source ConfigSource
+}
+
+func NewConfigAPI(source ConfigSource) ConfigAPI {
+	return &configAPI{source: source}
+}
+
+// GetConfig responds with config currently in effect, including reloaded changes, secrets are redacted
+func (configAPI *configAPI) GetConfig(context *gin.Context) {
+	context.JSON(http.StatusOK, configAPI.source.Get().Dump())
+}
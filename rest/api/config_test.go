@@ -0,0 +1,33 @@
+package api
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/config"
+	"go-examples/rest/test"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetConfigRedactsSecrets(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	source := &test.ConfigSourceMock{}
+	source.On("Get").Return(&config.AppConfig{
+		DB: config.DBConfig{Host: "localhost", Password: "postgres", Timeout: 250 * time.Millisecond},
+	})
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+
+	//when
+	NewConfigAPI(source).GetConfig(ctx)
+
+	//then
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Contains(t, recorder.Body.String(), `"password":"[REDACTED]"`)
+	require.Contains(t, recorder.Body.String(), `"host":"localhost"`)
+	require.Contains(t, recorder.Body.String(), `"timeout":"250ms"`)
+	require.NotContains(t, recorder.Body.String(), `"postgres"`)
+}
@@ -0,0 +1,43 @@
+package api
+
+import (
+	"fmt"
+	"go-examples/rest/repository"
+	"strconv"
+	"strings"
+)
+
+func etag(version int) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatchVersion translates If-Match header into version expected by repository.
+// Missing header or "*" means any version, repository still reports missing user.
+// Returns false when header can't match any version - weak tags never match (strong comparison)
+// and lists are not supported as they would require reading current version first.
+func ifMatchVersion(header string) (int, bool) {
+	header = strings.TrimSpace(header)
+	if header == "" || header == "*" {
+		return repository.AnyVersion, true
+	}
+	if strings.HasPrefix(header, "W/") || strings.Contains(header, ",") {
+		return 0, false
+	}
+	version, err := strconv.Atoi(strings.Trim(header, `"`))
+	if err != nil || version <= repository.AnyVersion {
+		return 0, false
+	}
+	return version, true
+}
+
+// noneMatch reports whether If-None-Match header contains current entity tag (weak comparison)
+func noneMatch(header string, version int) bool {
+	current := etag(version)
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == current {
+			return true
+		}
+	}
+	return false
+}
@@ -1,27 +1,67 @@
 package api
 
 import (
+	"context"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"go-examples/rest/database"
+	"go-examples/rest/model"
 )
 
 type HealthAPI interface {
 	Health(ctx *gin.Context)
+	Livez(ctx *gin.Context)
+	Readyz(ctx *gin.Context)
+}
+
+// ReadinessChecker runs checks of dependencies the app needs to serve requests
+type ReadinessChecker interface {
+	Ready(ctx context.Context) model.Probe
 }
 
 type healthAPI struct {
-	database database.Database
+	database  database.Database
+	readiness ReadinessChecker
 }
 
-func NewHealthAPI(database database.Database) HealthAPI {
-	return &healthAPI{database: database}
+func NewHealthAPI(database database.Database, readiness ReadinessChecker) HealthAPI {
+	return &healthAPI{database: database, readiness: readiness}
 }
 
+// Health reports every database node as of its last background check, app is up as long as the primary is reachable
+// since reads fall back to it
 func (healthAPI *healthAPI) Health(ctx *gin.Context) {
-	err := healthAPI.database.Ping(ctx)
-	if err != nil {
-		AbortWithContextError(ctx, 500, "db not reachable", err)
+	health := model.Health{Status: model.HealthUp, Nodes: database.Status(ctx, healthAPI.database)}
+	for _, node := range health.Nodes {
+		if node.Healthy {
+			continue
+		}
+		if node.Role == database.RolePrimary {
+			AbortWithContextError(ctx, 500, model.ErrorCodeDatabaseUnavailable, "db not reachable", node.Err)
+			return
+		}
+		_ = ctx.Error(node.Err)
+		health.Status = model.HealthDegraded
+	}
+	ctx.JSON(200, health)
+}
+
+// Livez responds as long as the process serves requests, dependencies aren't checked since restart wouldn't fix them
+func (healthAPI *healthAPI) Livez(ctx *gin.Context) {
+	ctx.JSON(200, model.Probe{Status: model.HealthUp, Checks: []model.CheckResult{}})
+}
+
+// Readyz responds 503 while any dependency is down or server is shutting down, so no new requests are routed to it
+func (healthAPI *healthAPI) Readyz(ctx *gin.Context) {
+	probe := healthAPI.readiness.Ready(ctx)
+	if probe.Status != model.HealthUp {
+		for _, check := range probe.Checks {
+			if check.Err != nil {
+				_ = ctx.Error(fmt.Errorf("%s: %w", check.Name, check.Err))
+			}
+		}
+		ctx.JSON(503, probe)
 		return
 	}
-	ctx.Status(200)
+	ctx.JSON(200, probe)
 }
@@ -1,20 +1,25 @@
 package api
 
 import (
+	"context"
 	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"go-examples/rest/database"
+	"go-examples/rest/model"
 	"go-examples/rest/test"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
 type HealthSuite struct {
 	suite.Suite
 	dbMock    *test.DatabaseMock
+	readiness *test.ReadinessCheckerMock
 	healthAPI HealthAPI
 	ctx       *gin.Context
 	recorder  *httptest.ResponseRecorder
@@ -27,7 +32,8 @@ func TestHealthSuite(t *testing.T) {
 func (suite *HealthSuite) BeforeTest(suiteName, testName string) {
 	suite.dbMock = &test.DatabaseMock{}
 	suite.recorder = httptest.NewRecorder()
-	suite.healthAPI = NewHealthAPI(suite.dbMock)
+	suite.readiness = &test.ReadinessCheckerMock{}
+	suite.healthAPI = NewHealthAPI(suite.dbMock, suite.readiness)
 	suite.ctx, _ = gin.CreateTestContext(suite.recorder)
 }
 
@@ -38,8 +44,33 @@ func (suite *HealthSuite) TestHealthSuccess() {
 	//when health is called
 	suite.healthAPI.Health(suite.ctx)
 
-	//then status is 200
+	//then status is 200 and primary is reported
 	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	require.JSONEq(suite.T(), `{"status":"up","nodes":[{"name":"primary","role":"primary","healthy":true,"in_use":0}]}`,
+		suite.recorder.Body.String())
+}
+
+func (suite *HealthSuite) TestHealthDegradedReplicaNotReachable() {
+	//given primary is reachable but replica is not
+	suite.dbMock.On("Ping", mock.Anything).Return(nil)
+	replicaMock := &test.DatabaseMock{}
+	replicaMock.On("Ping", mock.Anything).Return(fmt.Errorf("replica not reachable"))
+	inUse := func() int32 { return 2 }
+	cluster := database.NewCluster(database.NewNode("primary", suite.dbMock, inUse),
+		[]*database.Node{database.NewNode("replica-1", replicaMock, inUse)}, database.RoundRobin)
+	//nodes are checked in background, health reports the last check
+	cluster.Check(context.Background())
+	suite.healthAPI = NewHealthAPI(cluster, suite.readiness)
+
+	//when health is called
+	suite.healthAPI.Health(suite.ctx)
+
+	//then status is 200, app is degraded and replica error is recorded
+	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	require.JSONEq(suite.T(), `{"status":"degraded","nodes":[
+		{"name":"primary","role":"primary","healthy":true,"in_use":2},
+		{"name":"replica-1","role":"replica","healthy":false,"in_use":2}]}`, suite.recorder.Body.String())
+	require.Len(suite.T(), suite.ctx.Errors, 1)
 }
 
 func (suite *HealthSuite) TestHealthFailureDbNotReachable() {
@@ -52,3 +83,51 @@ func (suite *HealthSuite) TestHealthFailureDbNotReachable() {
 	//then status is 500
 	require.Equal(suite.T(), http.StatusInternalServerError, suite.recorder.Code)
 }
+
+func (suite *HealthSuite) TestLivez() {
+	//when livez is called
+	suite.healthAPI.Livez(suite.ctx)
+
+	//then status is 200 without checking dependencies
+	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	require.JSONEq(suite.T(), `{"status":"up","checks":[]}`, suite.recorder.Body.String())
+	suite.dbMock.AssertNotCalled(suite.T(), "Ping", mock.Anything)
+}
+
+func (suite *HealthSuite) TestReadyzSuccess() {
+	//given all checks passed
+	checkedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.readiness.On("Ready", mock.Anything).Return(model.Probe{Status: model.HealthUp, Checks: []model.CheckResult{
+		{Name: "database", Status: model.HealthUp, LatencyMs: 1.5, CheckedAt: checkedAt},
+	}})
+
+	//when readyz is called
+	suite.healthAPI.Readyz(suite.ctx)
+
+	//then status is 200 and checks are reported
+	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	require.JSONEq(suite.T(), `{"status":"up","checks":[
+		{"name":"database","status":"up","latency_ms":1.5,"checked_at":"2024-05-01T12:00:00Z"}]}`,
+		suite.recorder.Body.String())
+}
+
+func (suite *HealthSuite) TestReadyzFailureCheckDown() {
+	//given pool check failed
+	checkedAt := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	suite.readiness.On("Ready", mock.Anything).Return(model.Probe{Status: model.HealthDown, Checks: []model.CheckResult{
+		{Name: "database", Status: model.HealthUp, LatencyMs: 1.5, CheckedAt: checkedAt},
+		{Name: "database_pool", Status: model.HealthDown, CheckedAt: checkedAt, Err: database.ErrPoolExhausted},
+	}})
+
+	//when readyz is called
+	suite.healthAPI.Readyz(suite.ctx)
+
+	//then status is 503, failed check is reported without error detail and error is recorded
+	require.Equal(suite.T(), http.StatusServiceUnavailable, suite.recorder.Code)
+	require.JSONEq(suite.T(), `{"status":"down","checks":[
+		{"name":"database","status":"up","latency_ms":1.5,"checked_at":"2024-05-01T12:00:00Z"},
+		{"name":"database_pool","status":"down","latency_ms":0,"checked_at":"2024-05-01T12:00:00Z"}]}`,
+		suite.recorder.Body.String())
+	require.Len(suite.T(), suite.ctx.Errors, 1)
+	require.ErrorIs(suite.T(), suite.ctx.Errors[0], database.ErrPoolExhausted)
+}
@@ -0,0 +1,58 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"go-examples/rest/model"
+)
+
+const (
+	mergePatchContentType = "application/merge-patch+json" //RFC 7386
+	jsonPatchContentType  = "application/json-patch+json"  //RFC 6902
+)
+
+var (
+	errUnsupportedPatch = errors.New("unsupported patch content type")
+	errInvalidPatch     = errors.New("invalid patch")
+)
+
+// applyPatch applies patch document to the current user representation.
+// Patched document is normalized and validated the same way PostUser binding is, fields that didn't change are left out of the result.
+func applyPatch(current *model.User, contentType string, patch []byte) (*model.UserPatch, error) {
+	document, err := json.Marshal(model.PostUser{Email: current.Email})
+	if err != nil {
+		return nil, err
+	}
+	var patched []byte
+	switch contentType {
+	case mergePatchContentType:
+		patched, err = jsonpatch.MergePatch(document, patch)
+	case jsonPatchContentType:
+		var operations jsonpatch.Patch
+		if operations, err = jsonpatch.DecodePatch(patch); err == nil {
+			patched, err = operations.Apply(document)
+		}
+	default:
+		return nil, errUnsupportedPatch
+	}
+	if err != nil {
+		return nil, errors.Join(errInvalidPatch, err)
+	}
+	//unknown fields would be silently dropped otherwise, e.g. attempt to patch id
+	decoder := json.NewDecoder(bytes.NewReader(patched))
+	decoder.DisallowUnknownFields()
+	user := new(model.PostUser)
+	if err := decoder.Decode(user); err != nil {
+		return nil, errors.Join(errInvalidPatch, err)
+	}
+	if err := validate(user); err != nil {
+		return nil, errors.Join(errInvalidPatch, err)
+	}
+	changes := new(model.UserPatch)
+	if user.Email != current.Email {
+		changes.Email = &user.Email
+	}
+	return changes, nil
+}
@@ -2,20 +2,34 @@ package api
 
 import (
 	"context"
+	"encoding/csv"
 	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/gin-gonic/gin/render"
+	"go-examples/rest/config"
 	"go-examples/rest/model"
 	"go-examples/rest/repository"
+	"io"
 	"net/http"
+	"time"
 )
 
 type UserRepository interface {
-	GetAllUsers(ctx context.Context) ([]*model.User, error)
+	GetAllUsers(ctx context.Context, query *model.UserQuery, consume func(*model.User) error) (string, error)
 	GetUserById(ctx context.Context, id string) (*model.User, error)
+	GetLatestUserById(ctx context.Context, id string) (*model.User, error)
 	Save(ctx context.Context, user *model.PostUser) (*model.User, error)
-	Update(ctx context.Context, id string, user *model.PostUser) (*model.User, error)
+	Update(ctx context.Context, id string, user *model.PostUser, version int) (*model.User, error)
+	Patch(ctx context.Context, id string, patch *model.UserPatch, version int) (*model.User, error)
 	Exists(ctx context.Context, id string) (bool, error)
-	Delete(ctx context.Context, id string) error
+	Delete(ctx context.Context, id string, version int) error
+	Restore(ctx context.Context, id string) (*model.User, error)
+	Purge(ctx context.Context, retention time.Duration) (int64, error)
+	GetUserAudit(ctx context.Context, id string, query *model.AuditQuery) (*model.AuditPage, error)
+	Import(ctx context.Context, next func() (*model.PostUser, error)) (int64, error)
+	ExportUsers(ctx context.Context, consume func(*model.User) error) error
 }
 
 type UserAPI interface {
@@ -23,39 +37,93 @@ type UserAPI interface {
 	GetUserById(context *gin.Context)
 	CreateUser(context *gin.Context)
 	DeleteUser(context *gin.Context)
+	RestoreUser(context *gin.Context)
+	PurgeUsers(context *gin.Context)
+	GetUserAudit(context *gin.Context)
 	UpdateUser(context *gin.Context)
+	PatchUser(context *gin.Context)
+	ImportUsers(context *gin.Context)
+	ExportUsers(context *gin.Context)
 }
 
 type userAPI struct {
 	userRepository UserRepository
+	config         *config.APIConfig
 }
 
-func NewUserAPI(userRepository UserRepository) UserAPI {
-	return &userAPI{userRepository: userRepository}
+func NewUserAPI(userRepository UserRepository, config *config.APIConfig) UserAPI {
+	return &userAPI{userRepository: userRepository, config: config}
 }
 
+// GetUsers responds with page of users as JSON, MessagePack or CSV depending on Accept header.
+// CSV can't carry the cursor of the next page, it's sent in Next-Cursor header instead.
 func (userAPI *userAPI) GetUsers(context *gin.Context) {
-	users, err := userAPI.userRepository.GetAllUsers(context)
+	format := context.NegotiateFormat(binding.MIMEJSON, binding.MIMEMSGPACK2, binding.MIMEMSGPACK, csvContentType)
+	if format == "" {
+		Abort(context, http.StatusNotAcceptable, model.ErrorCodeNotAcceptable, "unsupported users format")
+		return
+	}
+	query := new(model.UserQuery)
+	err := context.ShouldBindQuery(query)
+	if err != nil {
+		AbortWithBindingError(context, model.ErrorCodeInvalidQuery, "invalid query", err)
+		return
+	}
+	if query.Limit == 0 {
+		query.Limit = model.DefaultPageSize
+	}
+	page := &model.UserPage{Users: make([]*model.User, 0, query.Limit)}
+	page.NextCursor, err = userAPI.userRepository.GetAllUsers(context, query, func(user *model.User) error {
+		page.Users = append(page.Users, user)
+		return nil
+	})
 	if err != nil {
-		AbortWithContextError(context, http.StatusInternalServerError, "error getting users", err)
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			Abort(context, http.StatusBadRequest, model.ErrorCodeInvalidCursor, "invalid cursor")
+			return
+		}
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error getting users", err)
 		return
 	}
-	context.JSON(http.StatusOK, users)
+	switch format {
+	case binding.MIMEMSGPACK, binding.MIMEMSGPACK2:
+		context.Render(http.StatusOK, render.MsgPack{Data: page})
+	case csvContentType:
+		if page.NextCursor != "" {
+			context.Header("Next-Cursor", page.NextCursor)
+		}
+		records := make([][]string, 0, len(page.Users)+1)
+		records = append(records, csvUserHeader)
+		for _, user := range page.Users {
+			records = append(records, csvUserRecord(user))
+		}
+		context.Header("Content-Type", csvContentType)
+		context.Status(http.StatusOK)
+		if err := csv.NewWriter(context.Writer).WriteAll(records); err != nil {
+			_ = context.Error(fmt.Errorf("error writing users: %w", err))
+		}
+	default:
+		context.JSON(http.StatusOK, page)
+	}
 }
 
 func (userAPI *userAPI) GetUserById(context *gin.Context) {
-	id := context.Param("id")
-	if id == "" {
-		Abort(context, http.StatusBadRequest, "id is required")
+	id, ok := bindID(context)
+	if !ok {
 		return
 	}
 	user, err := userAPI.userRepository.GetUserById(context, id)
 	if err != nil {
 		if errors.Is(err, repository.ErrUserNotFound) {
-			Abort(context, http.StatusNotFound, "user not found")
+			Abort(context, http.StatusNotFound, model.ErrorCodeUserNotFound, "user not found")
 			return
 		}
-		AbortWithContextError(context, http.StatusInternalServerError, "error getting user", err)
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error getting user", err)
+		return
+	}
+	context.Header("ETag", etag(user.Version))
+	if noneMatch(context.GetHeader("If-None-Match"), user.Version) {
+		context.Status(http.StatusNotModified)
 		return
 	}
 	context.JSON(http.StatusOK, user)
@@ -63,54 +131,216 @@ func (userAPI *userAPI) GetUserById(context *gin.Context) {
 
 func (userAPI *userAPI) CreateUser(context *gin.Context) {
 	user := new(model.PostUser)
-	err := context.ShouldBindJSON(user)
+	err := bindJSON(context, user)
 	if err != nil {
-		Abort(context, http.StatusBadRequest, "invalid request")
+		AbortWithBindingError(context, model.ErrorCodeInvalidRequest, "invalid request", err)
 		return
 	}
 	created, err := userAPI.userRepository.Save(context, user)
 	if err != nil {
-		AbortWithContextError(context, http.StatusInternalServerError, "error saving user", err)
+		if errors.Is(err, repository.ErrUserAlreadyExists) {
+			Abort(context, http.StatusConflict, model.ErrorCodeUserAlreadyExists, "user already exists")
+			return
+		}
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error saving user", err)
 		return
 	}
+	context.Header("ETag", etag(created.Version))
 	context.JSON(http.StatusCreated, created)
 }
 
 func (userAPI *userAPI) DeleteUser(context *gin.Context) {
-	id := context.Param("id")
-	if id == "" {
-		Abort(context, http.StatusBadRequest, "id is required")
+	id, ok := bindID(context)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(context.GetHeader("If-Match"))
+	if !ok {
+		Abort(context, http.StatusPreconditionFailed, model.ErrorCodeVersionMismatch, "user was modified")
 		return
 	}
-	err := userAPI.userRepository.Delete(context, id)
+	err := userAPI.userRepository.Delete(context, id, version)
 	if err != nil {
-		AbortWithContextError(context, http.StatusInternalServerError, "error deleting user", err)
+		if errors.Is(err, repository.ErrVersionMismatch) {
+			Abort(context, http.StatusPreconditionFailed, model.ErrorCodeVersionMismatch, "user was modified")
+			return
+		}
+		if errors.Is(err, repository.ErrUserNotFound) {
+			if userAPI.config.IdempotentDelete {
+				context.Status(http.StatusNoContent)
+				return
+			}
+			Abort(context, http.StatusNotFound, model.ErrorCodeUserNotFound, "user not found")
+			return
+		}
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error deleting user", err)
 		return
 	}
-	context.JSON(http.StatusNoContent, nil)
+	context.Status(http.StatusNoContent)
 }
 
-func (userAPI *userAPI) UpdateUser(context *gin.Context) {
-	id := context.Param("id")
-	exists, err := userAPI.userRepository.Exists(context, id)
+// RestoreUser brings back deleted user, 404 when there is no deleted user with the id
+func (userAPI *userAPI) RestoreUser(context *gin.Context) {
+	id, ok := bindID(context)
+	if !ok {
+		return
+	}
+	restored, err := userAPI.userRepository.Restore(context, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			Abort(context, http.StatusNotFound, model.ErrorCodeUserNotFound, "deleted user not found")
+			return
+		}
+		if errors.Is(err, repository.ErrUserAlreadyExists) {
+			Abort(context, http.StatusConflict, model.ErrorCodeUserAlreadyExists, "email was taken by another user")
+			return
+		}
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error restoring user", err)
+		return
+	}
+	context.Header("ETag", etag(restored.Version))
+	context.JSON(http.StatusOK, restored)
+}
+
+// PurgeUsers removes users deleted longer than APIConfig.PurgeRetention ago, they can't be restored afterwards
+func (userAPI *userAPI) PurgeUsers(context *gin.Context) {
+	purged, err := userAPI.userRepository.Purge(context, userAPI.config.PurgeRetention)
 	if err != nil {
-		AbortWithContextError(context, http.StatusInternalServerError, "error updating user", err)
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error purging users", err)
 		return
 	}
-	if !exists {
-		Abort(context, http.StatusNotFound, "user not found")
+	context.JSON(http.StatusOK, &model.PurgeResult{Purged: purged})
+}
+
+// GetUserAudit pages audit trail of the user newest first, trail of deleted and purged users is still available
+func (userAPI *userAPI) GetUserAudit(context *gin.Context) {
+	id, ok := bindID(context)
+	if !ok {
+		return
+	}
+	query := new(model.AuditQuery)
+	if err := context.ShouldBindQuery(query); err != nil {
+		AbortWithBindingError(context, model.ErrorCodeInvalidQuery, "invalid query", err)
+		return
+	}
+	page, err := userAPI.userRepository.GetUserAudit(context, id, query)
+	if err != nil {
+		if errors.Is(err, repository.ErrInvalidCursor) {
+			Abort(context, http.StatusBadRequest, model.ErrorCodeInvalidCursor, "invalid cursor")
+			return
+		}
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error getting audit trail", err)
+		return
+	}
+	context.JSON(http.StatusOK, page)
+}
+
+func (userAPI *userAPI) UpdateUser(context *gin.Context) {
+	id, ok := bindID(context)
+	if !ok {
 		return
 	}
 	user := new(model.PostUser)
-	err = context.ShouldBindJSON(user)
+	err := bindJSON(context, user)
 	if err != nil {
-		Abort(context, http.StatusBadRequest, "invalid request")
+		AbortWithBindingError(context, model.ErrorCodeInvalidRequest, "invalid request", err)
+		return
+	}
+	version, ok := ifMatchVersion(context.GetHeader("If-Match"))
+	if !ok {
+		Abort(context, http.StatusPreconditionFailed, model.ErrorCodeVersionMismatch, "user was modified")
 		return
 	}
-	updated, err := userAPI.userRepository.Update(context, id, user)
+	updated, err := userAPI.userRepository.Update(context, id, user, version)
 	if err != nil {
-		AbortWithContextError(context, http.StatusInternalServerError, "error updating user", err)
+		if errors.Is(err, repository.ErrVersionMismatch) {
+			Abort(context, http.StatusPreconditionFailed, model.ErrorCodeVersionMismatch, "user was modified")
+			return
+		}
+		if errors.Is(err, repository.ErrUserNotFound) {
+			Abort(context, http.StatusNotFound, model.ErrorCodeUserNotFound, "user not found")
+			return
+		}
+		if errors.Is(err, repository.ErrUserAlreadyExists) {
+			Abort(context, http.StatusConflict, model.ErrorCodeUserAlreadyExists, "user already exists")
+			return
+		}
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error updating user", err)
 		return
 	}
+	context.Header("ETag", etag(updated.Version))
 	context.JSON(http.StatusOK, updated)
 }
+
+// PatchUser applies JSON Merge Patch or JSON Patch to the current user, only changed columns are updated.
+// Without If-Match patch is still applied atomically - version read here is required on update.
+func (userAPI *userAPI) PatchUser(context *gin.Context) {
+	id, ok := bindID(context)
+	if !ok {
+		return
+	}
+	version, ok := ifMatchVersion(context.GetHeader("If-Match"))
+	if !ok {
+		Abort(context, http.StatusPreconditionFailed, model.ErrorCodeVersionMismatch, "user was modified")
+		return
+	}
+	patch, err := io.ReadAll(context.Request.Body)
+	if err != nil {
+		if AbortWithBodyError(context, err) {
+			return
+		}
+		Abort(context, http.StatusBadRequest, model.ErrorCodeInvalidRequest, "invalid request")
+		return
+	}
+	//replica could lag behind and its stale version would fail the update
+	current, err := userAPI.userRepository.GetLatestUserById(context, id)
+	if err != nil {
+		if errors.Is(err, repository.ErrUserNotFound) {
+			Abort(context, http.StatusNotFound, model.ErrorCodeUserNotFound, "user not found")
+			return
+		}
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error patching user", err)
+		return
+	}
+	if version != repository.AnyVersion && version != current.Version {
+		Abort(context, http.StatusPreconditionFailed, model.ErrorCodeVersionMismatch, "user was modified")
+		return
+	}
+	changes, err := applyPatch(current, context.ContentType(), patch)
+	if err != nil {
+		if errors.Is(err, errUnsupportedPatch) {
+			Abort(context, http.StatusUnsupportedMediaType, model.ErrorCodeUnsupportedMediaType, "unsupported patch content type")
+			return
+		}
+		AbortWithBindingError(context, model.ErrorCodeInvalidPatch, "invalid patch", err)
+		return
+	}
+	if changes.Empty() {
+		context.Header("ETag", etag(current.Version))
+		context.JSON(http.StatusOK, current)
+		return
+	}
+	patched, err := userAPI.userRepository.Patch(context, id, changes, current.Version)
+	if err != nil {
+		if errors.Is(err, repository.ErrVersionMismatch) {
+			if version == repository.AnyVersion {
+				Abort(context, http.StatusConflict, model.ErrorCodeVersionMismatch, "user was modified concurrently")
+				return
+			}
+			Abort(context, http.StatusPreconditionFailed, model.ErrorCodeVersionMismatch, "user was modified")
+			return
+		}
+		if errors.Is(err, repository.ErrUserNotFound) {
+			Abort(context, http.StatusNotFound, model.ErrorCodeUserNotFound, "user not found")
+			return
+		}
+		if errors.Is(err, repository.ErrUserAlreadyExists) {
+			Abort(context, http.StatusConflict, model.ErrorCodeUserAlreadyExists, "user already exists")
+			return
+		}
+		AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error patching user", err)
+		return
+	}
+	context.Header("ETag", etag(patched.Version))
+	context.JSON(http.StatusOK, patched)
+}
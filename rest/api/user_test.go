@@ -1,10 +1,15 @@
 package api
 
 import (
+	"bytes"
+	"encoding/json"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"go-examples/rest/config"
 	"go-examples/rest/model"
 	"go-examples/rest/repository"
 	"go-examples/rest/test"
@@ -12,11 +17,15 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 )
 
-var testUserId = "some-id"
+var testUserId = "5b1a0d5e-3c4f-4a8e-9a55-2f0f3f1c6d11"
 var testUserEmail = "email@example.com"
 
+// timestamps of users returned by mocks that don't set them
+const zeroTimestamps = `"created_at": "0001-01-01T00:00:00Z", "updated_at": "0001-01-01T00:00:00Z"`
+
 type UserSuite struct {
 	suite.Suite
 	repositoryMock *test.UserRepositoryMock
@@ -32,27 +41,145 @@ func TestUserSuite(t *testing.T) {
 func (suite *UserSuite) BeforeTest(suiteName, testName string) {
 	gin.SetMode(gin.TestMode)
 	suite.repositoryMock = new(test.UserRepositoryMock)
-	suite.userAPI = NewUserAPI(suite.repositoryMock)
+	suite.userAPI = NewUserAPI(suite.repositoryMock, &config.APIConfig{})
 	suite.recorder = httptest.NewRecorder()
 	suite.ctx, _ = gin.CreateTestContext(suite.recorder)
+	suite.ctx.Request = httptest.NewRequest(http.MethodGet, "/", nil)
 }
 
 func (suite *UserSuite) TestGetUsersSuccess() {
 	//given
-	suite.repositoryMock.On("GetAllUsers").Return([]*model.User{{testUserId, testUserEmail}}, nil)
+	suite.repositoryMock.On("GetAllUsers", &model.UserQuery{Limit: model.DefaultPageSize}).
+		Return([]*model.User{{ID: testUserId, Email: testUserEmail}}, "", nil)
+	suite.ctx.Request, _ = http.NewRequest(http.MethodGet, "/users", nil)
+
+	//when
+	suite.userAPI.GetUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	expectedJson := fmt.Sprintf(`{"users": [{"id": "%s", "email": "%s", `+zeroTimestamps+`}]}`, testUserId, testUserEmail)
+	require.JSONEq(suite.T(), expectedJson, suite.recorder.Body.String())
+}
+
+func (suite *UserSuite) TestGetUsersPageWithQuery() {
+	//given
+	expectedQuery := &model.UserQuery{Limit: 1, Cursor: "abc", EmailPrefix: "em", Domain: "example.com", Sort: "-email"}
+	suite.repositoryMock.On("GetAllUsers", expectedQuery).
+		Return([]*model.User{{ID: testUserId, Email: testUserEmail}}, "next", nil)
+	suite.ctx.Request, _ = http.NewRequest(http.MethodGet, "/users?limit=1&cursor=abc&email_prefix=em&domain=example.com&sort=-email", nil)
 
 	//when
 	suite.userAPI.GetUsers(suite.ctx)
 
 	//then
 	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
-	expectedJson := fmt.Sprintf(`[{"id": "%s", "email": "%s"}]`, testUserId, testUserEmail)
+	expectedJson := fmt.Sprintf(`{"users": [{"id": "%s", "email": "%s", `+zeroTimestamps+`}], "next_cursor": "next"}`, testUserId, testUserEmail)
 	require.JSONEq(suite.T(), expectedJson, suite.recorder.Body.String())
 }
 
+func (suite *UserSuite) TestGetUsersEmptyPage() {
+	//given
+	suite.repositoryMock.On("GetAllUsers", &model.UserQuery{Limit: model.DefaultPageSize}).Return([]*model.User{}, "", nil)
+	suite.ctx.Request, _ = http.NewRequest(http.MethodGet, "/users", nil)
+
+	//when
+	suite.userAPI.GetUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	require.JSONEq(suite.T(), `{"users": []}`, suite.recorder.Body.String())
+}
+
+func (suite *UserSuite) TestGetUsersNegotiatesFormat() {
+	testData := []struct {
+		accept       string
+		expectedType string
+	}{
+		{"application/msgpack", "application/msgpack; charset=utf-8"},
+		{"application/x-msgpack", "application/msgpack; charset=utf-8"},
+		{"text/csv;q=0.9, application/xml", csvContentType},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.BeforeTest("", "")
+		suite.repositoryMock.On("GetAllUsers", &model.UserQuery{Limit: model.DefaultPageSize}).
+			Return([]*model.User{{ID: testUserId, Email: testUserEmail}}, "next", nil)
+		suite.ctx.Request, _ = http.NewRequest(http.MethodGet, "/users", nil)
+		suite.ctx.Request.Header.Set("Accept", testCase.accept)
+
+		//when
+		suite.userAPI.GetUsers(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), http.StatusOK, suite.recorder.Code, testCase.accept)
+		require.Equal(suite.T(), testCase.expectedType, suite.recorder.Header().Get("Content-Type"))
+		if testCase.expectedType == csvContentType {
+			require.Equal(suite.T(), fmt.Sprintf("id,email\n%s,%s\n", testUserId, testUserEmail), suite.recorder.Body.String())
+			require.Equal(suite.T(), "next", suite.recorder.Header().Get("Next-Cursor"))
+			continue
+		}
+		page := new(model.UserPage)
+		require.NoError(suite.T(), binding.MsgPack.BindBody(suite.recorder.Body.Bytes(), page))
+		require.Equal(suite.T(), "next", page.NextCursor)
+		require.Equal(suite.T(), testUserEmail, page.Users[0].Email)
+	}
+}
+
+func (suite *UserSuite) TestGetUsersNotAcceptable() {
+	//given
+	suite.ctx.Request, _ = http.NewRequest(http.MethodGet, "/users", nil)
+	suite.ctx.Request.Header.Set("Accept", "application/xml")
+
+	//when
+	suite.userAPI.GetUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusNotAcceptable, suite.recorder.Code)
+	suite.repositoryMock.AssertNotCalled(suite.T(), "GetAllUsers", mock.Anything)
+}
+
+func (suite *UserSuite) TestGetUsersInvalidQuery() {
+	testData := []struct {
+		query string
+	}{
+		{"limit=-1"},
+		{"limit=101"},
+		{"limit=abc"},
+		{"sort=name"},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.recorder = httptest.NewRecorder()
+		suite.ctx, _ = gin.CreateTestContext(suite.recorder)
+		suite.ctx.Request, _ = http.NewRequest(http.MethodGet, "/users?"+testCase.query, nil)
+
+		//when
+		suite.userAPI.GetUsers(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), http.StatusBadRequest, suite.recorder.Code, testCase.query)
+		require.Contains(suite.T(), suite.recorder.Body.String(), "invalid query")
+	}
+}
+
+func (suite *UserSuite) TestGetUsersInvalidCursor() {
+	//given
+	suite.repositoryMock.On("GetAllUsers", mock.Anything).Return([]*model.User{}, "", repository.ErrInvalidCursor)
+	suite.ctx.Request, _ = http.NewRequest(http.MethodGet, "/users?cursor=abc", nil)
+
+	//when
+	suite.userAPI.GetUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusBadRequest, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), "invalid cursor")
+}
+
 func (suite *UserSuite) TestGetUsersError() {
 	//given
-	suite.repositoryMock.On("GetAllUsers").Return([]*model.User{}, fmt.Errorf("db error"))
+	suite.repositoryMock.On("GetAllUsers", mock.Anything).Return([]*model.User{}, "", fmt.Errorf("db error"))
+	suite.ctx.Request, _ = http.NewRequest(http.MethodGet, "/users", nil)
 
 	//when
 	suite.userAPI.GetUsers(suite.ctx)
@@ -64,18 +191,63 @@ func (suite *UserSuite) TestGetUsersError() {
 
 func (suite *UserSuite) TestGetUserByIdSuccess() {
 	//given
-	suite.repositoryMock.On("GetUserById", testUserId).Return(&model.User{ID: testUserId, Email: testUserEmail}, nil)
+	suite.repositoryMock.On("GetUserById", testUserId).Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 2}, nil)
 	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("/users/%s", testUserId), nil)
 
 	//when
 	suite.userAPI.GetUserById(suite.ctx)
 
 	//then
 	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
-	expectedJson := fmt.Sprintf(`{"id": "%s", "email": "%s"}`, testUserId, testUserEmail)
+	require.Equal(suite.T(), `"2"`, suite.recorder.Header().Get("ETag"))
+	expectedJson := fmt.Sprintf(`{"id": "%s", "email": "%s", `+zeroTimestamps+`}`, testUserId, testUserEmail)
 	require.JSONEq(suite.T(), expectedJson, suite.recorder.Body.String())
 }
 
+func (suite *UserSuite) TestGetUserByIdNotModified() {
+	testData := []struct {
+		ifNoneMatch string
+	}{
+		{`"2"`},
+		{`W/"2"`},
+		{`"1", "2"`},
+		{`*`},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.recorder = httptest.NewRecorder()
+		suite.ctx, _ = gin.CreateTestContext(suite.recorder)
+		suite.repositoryMock.On("GetUserById", testUserId).Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 2}, nil)
+		suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+		suite.ctx.Request, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("/users/%s", testUserId), nil)
+		suite.ctx.Request.Header.Set("If-None-Match", testCase.ifNoneMatch)
+
+		//when
+		suite.userAPI.GetUserById(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), http.StatusNotModified, suite.ctx.Writer.Status(), testCase.ifNoneMatch)
+		require.Equal(suite.T(), `"2"`, suite.recorder.Header().Get("ETag"))
+		require.Empty(suite.T(), suite.recorder.Body.String())
+	}
+}
+
+func (suite *UserSuite) TestGetUserByIdModified() {
+	//given
+	suite.repositoryMock.On("GetUserById", testUserId).Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 2}, nil)
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("/users/%s", testUserId), nil)
+	suite.ctx.Request.Header.Set("If-None-Match", `"1"`)
+
+	//when
+	suite.userAPI.GetUserById(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	require.Equal(suite.T(), `"2"`, suite.recorder.Header().Get("ETag"))
+}
+
 func (suite *UserSuite) TestGetUserByIdNoId() {
 	//given
 	suite.ctx.Params = []gin.Param{}
@@ -85,7 +257,22 @@ func (suite *UserSuite) TestGetUserByIdNoId() {
 
 	//then
 	require.Equal(suite.T(), http.StatusBadRequest, suite.recorder.Code)
-	require.Contains(suite.T(), suite.recorder.Body.String(), "id is required")
+	require.Contains(suite.T(), suite.recorder.Body.String(), model.ErrorCodeInvalidID)
+}
+
+func (suite *UserSuite) TestGetUserByIdMalformedId() {
+	//given
+	suite.ctx.Params = []gin.Param{{Key: "id", Value: "abc"}}
+
+	//when
+	suite.userAPI.GetUserById(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusBadRequest, suite.recorder.Code)
+	problem := new(model.Problem)
+	require.NoError(suite.T(), json.Unmarshal(suite.recorder.Body.Bytes(), problem))
+	require.Equal(suite.T(), []model.FieldError{{Field: "id", Rule: "uuid", Message: "must be valid UUID"}}, problem.Errors)
+	suite.repositoryMock.AssertNotCalled(suite.T(), "GetUserById", mock.Anything)
 }
 
 func (suite *UserSuite) TestGetUserByIdUserNotFound() {
@@ -116,7 +303,7 @@ func (suite *UserSuite) TestGetUserByIdRepositoryError() {
 
 func (suite *UserSuite) TestCreateUserSuccess() {
 	//given
-	suite.repositoryMock.On("Save", &model.PostUser{Email: testUserEmail}).Return(&model.User{ID: testUserId, Email: testUserEmail}, nil)
+	suite.repositoryMock.On("Save", &model.PostUser{Email: testUserEmail}).Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 1}, nil)
 	suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users", strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, testUserEmail)))
 
 	//when
@@ -124,7 +311,8 @@ func (suite *UserSuite) TestCreateUserSuccess() {
 
 	//then
 	require.Equal(suite.T(), http.StatusCreated, suite.recorder.Code)
-	expectedJson := fmt.Sprintf(`{"id": "%s", "email": "%s"}`, testUserId, testUserEmail)
+	require.Equal(suite.T(), `"1"`, suite.recorder.Header().Get("ETag"))
+	expectedJson := fmt.Sprintf(`{"id": "%s", "email": "%s", `+zeroTimestamps+`}`, testUserId, testUserEmail)
 	require.JSONEq(suite.T(), expectedJson, suite.recorder.Body.String())
 }
 
@@ -149,6 +337,66 @@ func (suite *UserSuite) TestCreateUserInvalidRequest() {
 	}
 }
 
+func (suite *UserSuite) TestCreateUserFieldErrors() {
+	//given
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"email": ""}`))
+
+	//when
+	suite.userAPI.CreateUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusBadRequest, suite.recorder.Code)
+	require.Equal(suite.T(), model.ProblemContentType, suite.recorder.Header().Get("Content-Type"))
+	problem := new(model.Problem)
+	require.NoError(suite.T(), json.Unmarshal(suite.recorder.Body.Bytes(), problem))
+	require.Equal(suite.T(), model.ErrorCodeInvalidRequest, problem.Code)
+	require.Equal(suite.T(), []model.FieldError{{Field: "email", Rule: "required", Message: "is required"}}, problem.Errors)
+}
+
+func (suite *UserSuite) TestCreateUserInvalidEmail() {
+	testData := []struct {
+		email string
+		rule  string
+	}{
+		{"x", "email_address"},
+		{"a@", "email_address"},
+		{"Jane <jane@example.com>", "email_address"},
+		{"jane@example.com, john@example.com", "email_address"},
+		{strings.Repeat("a", 244) + "@example.com", "max"},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.recorder.Body.Reset()
+		body, _ := json.Marshal(model.PostUser{Email: testCase.email})
+		suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users", bytes.NewReader(body))
+
+		//when
+		suite.userAPI.CreateUser(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), http.StatusBadRequest, suite.recorder.Code, testCase.email)
+		problem := new(model.Problem)
+		require.NoError(suite.T(), json.Unmarshal(suite.recorder.Body.Bytes(), problem))
+		require.Len(suite.T(), problem.Errors, 1, testCase.email)
+		require.Equal(suite.T(), "email", problem.Errors[0].Field)
+		require.Equal(suite.T(), testCase.rule, problem.Errors[0].Rule, testCase.email)
+	}
+	suite.repositoryMock.AssertNotCalled(suite.T(), "Save", mock.Anything)
+}
+
+func (suite *UserSuite) TestCreateUserNormalizesEmail() {
+	//given
+	suite.repositoryMock.On("Save", &model.PostUser{Email: "Jane.Doe@example.com"}).Return(&model.User{ID: testUserId, Email: "Jane.Doe@example.com", Version: 1}, nil)
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users", strings.NewReader(`{"email": "  Jane.Doe@Example.COM "}`))
+
+	//when
+	suite.userAPI.CreateUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusCreated, suite.recorder.Code)
+	suite.repositoryMock.AssertExpectations(suite.T())
+}
+
 func (suite *UserSuite) TestCreateUserRepositoryError() {
 	//given
 	suite.repositoryMock.On("Save", &model.PostUser{Email: testUserEmail}).Return(new(model.User), fmt.Errorf("db error"))
@@ -162,16 +410,84 @@ func (suite *UserSuite) TestCreateUserRepositoryError() {
 	require.Contains(suite.T(), suite.recorder.Body.String(), "error saving user")
 }
 
+func (suite *UserSuite) TestCreateUserAlreadyExists() {
+	//given
+	suite.repositoryMock.On("Save", &model.PostUser{Email: testUserEmail}).Return(new(model.User), repository.ErrUserAlreadyExists)
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPost, "/users", strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, testUserEmail)))
+
+	//when
+	suite.userAPI.CreateUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusConflict, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), fmt.Sprintf(`"code":"%s"`, model.ErrorCodeUserAlreadyExists))
+}
+
 func (suite *UserSuite) TestDeleteUserSuccess() {
 	//given
-	suite.repositoryMock.On("Delete", testUserId).Return(nil)
+	suite.repositoryMock.On("Delete", testUserId, repository.AnyVersion).Return(nil)
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+
+	//when
+	suite.userAPI.DeleteUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusNoContent, suite.ctx.Writer.Status())
+}
+
+func (suite *UserSuite) TestDeleteUserNotFound() {
+	//given
+	suite.repositoryMock.On("Delete", testUserId, repository.AnyVersion).Return(repository.ErrUserNotFound)
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+
+	//when
+	suite.userAPI.DeleteUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusNotFound, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), "user not found")
+}
+
+func (suite *UserSuite) TestDeleteUserNotFoundIdempotent() {
+	//given
+	suite.userAPI = NewUserAPI(suite.repositoryMock, &config.APIConfig{IdempotentDelete: true})
+	suite.repositoryMock.On("Delete", testUserId, repository.AnyVersion).Return(repository.ErrUserNotFound)
 	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
 
 	//when
 	suite.userAPI.DeleteUser(suite.ctx)
 
 	//then
-	require.Equal(suite.T(), http.StatusNoContent, suite.recorder.Code)
+	require.Equal(suite.T(), http.StatusNoContent, suite.ctx.Writer.Status())
+}
+
+func (suite *UserSuite) TestDeleteUserIfMatch() {
+	//given
+	suite.repositoryMock.On("Delete", testUserId, 3).Return(nil)
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("/users/%s", testUserId), nil)
+	suite.ctx.Request.Header.Set("If-Match", `"3"`)
+
+	//when
+	suite.userAPI.DeleteUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusNoContent, suite.ctx.Writer.Status())
+}
+
+func (suite *UserSuite) TestDeleteUserVersionMismatch() {
+	//given
+	suite.repositoryMock.On("Delete", testUserId, 3).Return(repository.ErrVersionMismatch)
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodDelete, fmt.Sprintf("/users/%s", testUserId), nil)
+	suite.ctx.Request.Header.Set("If-Match", `"3"`)
+
+	//when
+	suite.userAPI.DeleteUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusPreconditionFailed, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), model.ErrorCodeVersionMismatch)
 }
 
 func (suite *UserSuite) TestDeleteUserNoId() {
@@ -183,12 +499,12 @@ func (suite *UserSuite) TestDeleteUserNoId() {
 
 	//then
 	require.Equal(suite.T(), http.StatusBadRequest, suite.recorder.Code)
-	require.Contains(suite.T(), suite.recorder.Body.String(), "id is required")
+	require.Contains(suite.T(), suite.recorder.Body.String(), model.ErrorCodeInvalidID)
 }
 
 func (suite *UserSuite) TestDeleteUserRepositoryError() {
 	//given
-	suite.repositoryMock.On("Delete", testUserId).Return(fmt.Errorf("db error"))
+	suite.repositoryMock.On("Delete", testUserId, repository.AnyVersion).Return(fmt.Errorf("db error"))
 	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
 
 	//when
@@ -199,39 +515,217 @@ func (suite *UserSuite) TestDeleteUserRepositoryError() {
 	require.Contains(suite.T(), suite.recorder.Body.String(), "error deleting user")
 }
 
+func (suite *UserSuite) TestRestoreUserSuccess() {
+	//given
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	updated := created.Add(time.Hour)
+	suite.repositoryMock.On("Restore", testUserId).
+		Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 3, CreatedAt: created, UpdatedAt: updated}, nil)
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+
+	//when
+	suite.userAPI.RestoreUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	require.Equal(suite.T(), `"3"`, suite.recorder.Header().Get("ETag"))
+	expectedJson := fmt.Sprintf(`{"id": "%s", "email": "%s", "created_at": "2024-01-02T03:04:05Z", "updated_at": "2024-01-02T04:04:05Z"}`, testUserId, testUserEmail)
+	require.JSONEq(suite.T(), expectedJson, suite.recorder.Body.String())
+}
+
+func (suite *UserSuite) TestRestoreUserErrors() {
+	testData := []struct {
+		err            error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{repository.ErrUserNotFound, http.StatusNotFound, model.ErrorCodeUserNotFound},
+		{repository.ErrUserAlreadyExists, http.StatusConflict, model.ErrorCodeUserAlreadyExists},
+		{fmt.Errorf("db error"), http.StatusInternalServerError, model.ErrorCodeInternal},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.BeforeTest("", "")
+		suite.repositoryMock.On("Restore", testUserId).Return((*model.User)(nil), testCase.err)
+		suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+
+		//when
+		suite.userAPI.RestoreUser(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), testCase.expectedStatus, suite.recorder.Code, testCase.err.Error())
+		require.Contains(suite.T(), suite.recorder.Body.String(), testCase.expectedCode)
+	}
+}
+
+func (suite *UserSuite) TestRestoreUserInvalidId() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: "1"})
+
+	//when
+	suite.userAPI.RestoreUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusBadRequest, suite.recorder.Code)
+	suite.repositoryMock.AssertNotCalled(suite.T(), "Restore", mock.Anything)
+}
+
+func (suite *UserSuite) TestPurgeUsers() {
+	//given
+	suite.userAPI = NewUserAPI(suite.repositoryMock, &config.APIConfig{PurgeRetention: 24 * time.Hour})
+	suite.repositoryMock.On("Purge", 24*time.Hour).Return(int64(3), nil)
+
+	//when
+	suite.userAPI.PurgeUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	require.JSONEq(suite.T(), `{"purged": 3}`, suite.recorder.Body.String())
+}
+
+func (suite *UserSuite) TestPurgeUsersRepositoryError() {
+	//given
+	suite.repositoryMock.On("Purge", time.Duration(0)).Return(int64(0), fmt.Errorf("db error"))
+
+	//when
+	suite.userAPI.PurgeUsers(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusInternalServerError, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), "error purging users")
+}
+
+func (suite *UserSuite) TestGetUserAuditSuccess() {
+	//given
+	created := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	page := &model.AuditPage{
+		Entries: []*model.AuditEntry{{
+			ID:        7,
+			UserID:    testUserId,
+			Actor:     "key-1",
+			Action:    model.AuditActionCreate,
+			After:     []byte(`{"email": "email@example.com"}`),
+			RequestID: "request-1",
+			CreatedAt: created,
+		}},
+		NextCursor: "next",
+	}
+	suite.repositoryMock.On("GetUserAudit", testUserId, &model.AuditQuery{Limit: 1, Cursor: "abc"}).Return(page, nil)
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("/users/%s/audit?limit=1&cursor=abc", testUserId), nil)
+
+	//when
+	suite.userAPI.GetUserAudit(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	expectedJson := fmt.Sprintf(`{"entries": [{"id": 7, "user_id": "%s", "actor": "key-1", "action": "create", "after": {"email": "email@example.com"},
+		"request_id": "request-1", "created_at": "2024-01-02T03:04:05Z"}], "next_cursor": "next"}`, testUserId)
+	require.JSONEq(suite.T(), expectedJson, suite.recorder.Body.String())
+}
+
+func (suite *UserSuite) TestGetUserAuditErrors() {
+	testData := []struct {
+		query          string
+		err            error
+		expectedStatus int
+		expectedCode   string
+	}{
+		{"limit=1000", nil, http.StatusBadRequest, model.ErrorCodeInvalidQuery},
+		{"cursor=abc", repository.ErrInvalidCursor, http.StatusBadRequest, model.ErrorCodeInvalidCursor},
+		{"", fmt.Errorf("db error"), http.StatusInternalServerError, model.ErrorCodeInternal},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.BeforeTest("", "")
+		suite.repositoryMock.On("GetUserAudit", testUserId, mock.Anything).Return((*model.AuditPage)(nil), testCase.err)
+		suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+		suite.ctx.Request, _ = http.NewRequest(http.MethodGet, fmt.Sprintf("/users/%s/audit?%s", testUserId, testCase.query), nil)
+
+		//when
+		suite.userAPI.GetUserAudit(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), testCase.expectedStatus, suite.recorder.Code, testCase.query)
+		require.Contains(suite.T(), suite.recorder.Body.String(), testCase.expectedCode)
+	}
+}
+
 func (suite *UserSuite) TestUpdateUserSuccess() {
 	//given
 	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
 	suite.ctx.Request, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, testUserEmail)))
-	suite.repositoryMock.On("Exists", testUserId).Return(true, nil)
-	suite.repositoryMock.On("Update", testUserId, &model.PostUser{Email: testUserEmail}).Return(&model.User{ID: testUserId, Email: testUserEmail}, nil)
+	suite.repositoryMock.On("Update", testUserId, &model.PostUser{Email: testUserEmail}, repository.AnyVersion).Return(&model.User{ID: testUserId, Email: testUserEmail}, nil)
 
 	//when
 	suite.userAPI.UpdateUser(suite.ctx)
 
 	//then
 	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
-	expectedJson := fmt.Sprintf(`{"id": "%s", "email": "%s"}`, testUserId, testUserEmail)
+	expectedJson := fmt.Sprintf(`{"id": "%s", "email": "%s", `+zeroTimestamps+`}`, testUserId, testUserEmail)
 	require.JSONEq(suite.T(), expectedJson, suite.recorder.Body.String())
 }
 
-func (suite *UserSuite) TestUpdateUserExistsError() {
+func (suite *UserSuite) TestUpdateUserIfMatch() {
 	//given
 	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
-	suite.repositoryMock.On("Exists", testUserId).Return(false, fmt.Errorf("db error"))
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, testUserEmail)))
+	suite.ctx.Request.Header.Set("If-Match", `"3"`)
+	suite.repositoryMock.On("Update", testUserId, &model.PostUser{Email: testUserEmail}, 3).Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 4}, nil)
 
 	//when
 	suite.userAPI.UpdateUser(suite.ctx)
 
 	//then
-	require.Equal(suite.T(), http.StatusInternalServerError, suite.recorder.Code)
-	require.Contains(suite.T(), suite.recorder.Body.String(), "error updating user")
+	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	require.Equal(suite.T(), `"4"`, suite.recorder.Header().Get("ETag"))
+}
+
+func (suite *UserSuite) TestUpdateUserVersionMismatch() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, testUserEmail)))
+	suite.ctx.Request.Header.Set("If-Match", `"3"`)
+	suite.repositoryMock.On("Update", testUserId, &model.PostUser{Email: testUserEmail}, 3).Return(new(model.User), repository.ErrVersionMismatch)
+
+	//when
+	suite.userAPI.UpdateUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusPreconditionFailed, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), model.ErrorCodeVersionMismatch)
+}
+
+func (suite *UserSuite) TestUpdateUserIfMatchNeverMatching() {
+	testData := []struct {
+		ifMatch string
+	}{
+		{`W/"3"`},
+		{`"abc"`},
+		{`"1", "2"`},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.recorder = httptest.NewRecorder()
+		suite.ctx, _ = gin.CreateTestContext(suite.recorder)
+		suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+		suite.ctx.Request, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, testUserEmail)))
+		suite.ctx.Request.Header.Set("If-Match", testCase.ifMatch)
+
+		//when
+		suite.userAPI.UpdateUser(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), http.StatusPreconditionFailed, suite.recorder.Code, testCase.ifMatch)
+		suite.repositoryMock.AssertNotCalled(suite.T(), "Update")
+	}
 }
 
 func (suite *UserSuite) TestUpdateUserDoesNotExists() {
 	//given
 	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
-	suite.repositoryMock.On("Exists", testUserId).Return(false, nil)
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, testUserEmail)))
+	suite.repositoryMock.On("Update", testUserId, &model.PostUser{Email: testUserEmail}, repository.AnyVersion).Return(new(model.User), repository.ErrUserNotFound)
 
 	//when
 	suite.userAPI.UpdateUser(suite.ctx)
@@ -253,7 +747,6 @@ func (suite *UserSuite) TestUpdateUserInvalidRequest() {
 		//given
 		suite.ctx.Request, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(testCase.request))
 		suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
-		suite.repositoryMock.On("Exists", testUserId).Return(true, nil)
 
 		//when
 		suite.userAPI.UpdateUser(suite.ctx)
@@ -264,12 +757,25 @@ func (suite *UserSuite) TestUpdateUserInvalidRequest() {
 	}
 }
 
+func (suite *UserSuite) TestUpdateUserAlreadyExists() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, testUserEmail)))
+	suite.repositoryMock.On("Update", testUserId, &model.PostUser{Email: testUserEmail}, repository.AnyVersion).Return(new(model.User), repository.ErrUserAlreadyExists)
+
+	//when
+	suite.userAPI.UpdateUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusConflict, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), fmt.Sprintf(`"code":"%s"`, model.ErrorCodeUserAlreadyExists))
+}
+
 func (suite *UserSuite) TestUpdateUserUpdateRepositoryError() {
 	//given
 	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
 	suite.ctx.Request, _ = http.NewRequest(http.MethodPut, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(fmt.Sprintf(`{"email": "%s"}`, testUserEmail)))
-	suite.repositoryMock.On("Exists", testUserId).Return(true, nil)
-	suite.repositoryMock.On("Update", testUserId, &model.PostUser{Email: testUserEmail}).Return(new(model.User), fmt.Errorf("db error"))
+	suite.repositoryMock.On("Update", testUserId, &model.PostUser{Email: testUserEmail}, repository.AnyVersion).Return(new(model.User), fmt.Errorf("db error"))
 
 	//when
 	suite.userAPI.UpdateUser(suite.ctx)
@@ -278,3 +784,138 @@ func (suite *UserSuite) TestUpdateUserUpdateRepositoryError() {
 	require.Equal(suite.T(), http.StatusInternalServerError, suite.recorder.Code)
 	require.Contains(suite.T(), suite.recorder.Body.String(), "error updating user")
 }
+
+func (suite *UserSuite) TestPatchUserSuccess() {
+	testData := []struct {
+		contentType string
+		patch       string
+	}{
+		{mergePatchContentType, `{"email": "new@example.com"}`},
+		{jsonPatchContentType, `[{"op": "replace", "path": "/email", "value": "new@example.com"}]`},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.BeforeTest("", "")
+		newEmail := "new@example.com"
+		suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+		suite.ctx.Request, _ = http.NewRequest(http.MethodPatch, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(testCase.patch))
+		suite.ctx.Request.Header.Set("Content-Type", testCase.contentType)
+		suite.repositoryMock.On("GetLatestUserById", testUserId).Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 1}, nil)
+		suite.repositoryMock.On("Patch", testUserId, &model.UserPatch{Email: &newEmail}, 1).Return(&model.User{ID: testUserId, Email: newEmail, Version: 2}, nil)
+
+		//when
+		suite.userAPI.PatchUser(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), http.StatusOK, suite.recorder.Code, testCase.contentType)
+		require.Equal(suite.T(), `"2"`, suite.recorder.Header().Get("ETag"))
+		require.JSONEq(suite.T(), fmt.Sprintf(`{"id": "%s", "email": "%s", `+zeroTimestamps+`}`, testUserId, newEmail), suite.recorder.Body.String())
+	}
+}
+
+func (suite *UserSuite) TestPatchUserNoChanges() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPatch, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(`{}`))
+	suite.ctx.Request.Header.Set("Content-Type", mergePatchContentType)
+	suite.repositoryMock.On("GetLatestUserById", testUserId).Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 1}, nil)
+
+	//when
+	suite.userAPI.PatchUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusOK, suite.recorder.Code)
+	require.Equal(suite.T(), `"1"`, suite.recorder.Header().Get("ETag"))
+	suite.repositoryMock.AssertNotCalled(suite.T(), "Patch")
+}
+
+func (suite *UserSuite) TestPatchUserInvalidPatch() {
+	testData := []struct {
+		contentType string
+		patch       string
+	}{
+		{mergePatchContentType, `not json`},
+		{mergePatchContentType, `{"email": null}`},
+		{mergePatchContentType, `{"id": "other"}`},
+		{jsonPatchContentType, `[{"op": "remove", "path": "/email"}]`},
+		{jsonPatchContentType, `[{"op": "test", "path": "/email", "value": "other@example.com"}]`},
+		{jsonPatchContentType, `{"op": "replace"}`},
+	}
+	for _, testCase := range testData {
+		//given
+		suite.BeforeTest("", "")
+		suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+		suite.ctx.Request, _ = http.NewRequest(http.MethodPatch, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(testCase.patch))
+		suite.ctx.Request.Header.Set("Content-Type", testCase.contentType)
+		suite.repositoryMock.On("GetLatestUserById", testUserId).Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 1}, nil)
+
+		//when
+		suite.userAPI.PatchUser(suite.ctx)
+
+		//then
+		require.Equal(suite.T(), http.StatusBadRequest, suite.recorder.Code, testCase.patch)
+		require.Contains(suite.T(), suite.recorder.Body.String(), "invalid patch")
+		suite.repositoryMock.AssertNotCalled(suite.T(), "Patch")
+	}
+}
+
+func (suite *UserSuite) TestPatchUserUnsupportedContentType() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPatch, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(`{"email": "new@example.com"}`))
+	suite.ctx.Request.Header.Set("Content-Type", "application/json")
+	suite.repositoryMock.On("GetLatestUserById", testUserId).Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 1}, nil)
+
+	//when
+	suite.userAPI.PatchUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusUnsupportedMediaType, suite.recorder.Code)
+}
+
+func (suite *UserSuite) TestPatchUserNotFound() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPatch, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(`{"email": "new@example.com"}`))
+	suite.ctx.Request.Header.Set("Content-Type", mergePatchContentType)
+	suite.repositoryMock.On("GetLatestUserById", testUserId).Return(new(model.User), repository.ErrUserNotFound)
+
+	//when
+	suite.userAPI.PatchUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusNotFound, suite.recorder.Code)
+}
+
+func (suite *UserSuite) TestPatchUserIfMatchMismatch() {
+	//given
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPatch, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(`{"email": "new@example.com"}`))
+	suite.ctx.Request.Header.Set("Content-Type", mergePatchContentType)
+	suite.ctx.Request.Header.Set("If-Match", `"3"`)
+	suite.repositoryMock.On("GetLatestUserById", testUserId).Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 1}, nil)
+
+	//when
+	suite.userAPI.PatchUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusPreconditionFailed, suite.recorder.Code)
+	suite.repositoryMock.AssertNotCalled(suite.T(), "Patch")
+}
+
+func (suite *UserSuite) TestPatchUserConcurrentModification() {
+	//given
+	newEmail := "new@example.com"
+	suite.ctx.Params = append(suite.ctx.Params, gin.Param{Key: "id", Value: testUserId})
+	suite.ctx.Request, _ = http.NewRequest(http.MethodPatch, fmt.Sprintf("/users/%s", testUserId), strings.NewReader(`{"email": "new@example.com"}`))
+	suite.ctx.Request.Header.Set("Content-Type", mergePatchContentType)
+	suite.repositoryMock.On("GetLatestUserById", testUserId).Return(&model.User{ID: testUserId, Email: testUserEmail, Version: 1}, nil)
+	suite.repositoryMock.On("Patch", testUserId, &model.UserPatch{Email: &newEmail}, 1).Return(new(model.User), repository.ErrVersionMismatch)
+
+	//when
+	suite.userAPI.PatchUser(suite.ctx)
+
+	//then
+	require.Equal(suite.T(), http.StatusConflict, suite.recorder.Code)
+	require.Contains(suite.T(), suite.recorder.Body.String(), model.ErrorCodeVersionMismatch)
+}
@@ -0,0 +1,131 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"go-examples/rest/model"
+	"net/mail"
+	"reflect"
+	"strings"
+)
+
+// validators registry of custom rules usable in binding tags, rules for new fields are added here
+var validators = map[string]validator.Func{
+	"email_address": validEmailAddress,
+}
+
+func init() {
+	validate, ok := binding.Validator.Engine().(*validator.Validate)
+	if !ok {
+		return
+	}
+	//field errors name fields the way clients send them, not by go struct field names
+	validate.RegisterTagNameFunc(fieldName)
+	for tag, rule := range validators {
+		if err := validate.RegisterValidation(tag, rule); err != nil {
+			panic(fmt.Sprintf("error registering %s validator: %v", tag, err))
+		}
+	}
+}
+
+// normalizer is implemented by request models that canonicalize their fields
+type normalizer interface {
+	Normalize()
+}
+
+// bindJSON unlike ShouldBindJSON normalizes decoded body before it's validated
+func bindJSON(context *gin.Context, obj any) error {
+	if context.Request.Body == nil {
+		return errors.New("missing request body")
+	}
+	if err := json.NewDecoder(context.Request.Body).Decode(obj); err != nil {
+		return err
+	}
+	return validate(obj)
+}
+
+func validate(obj any) error {
+	if normalizer, ok := obj.(normalizer); ok {
+		normalizer.Normalize()
+	}
+	return binding.Validator.ValidateStruct(obj)
+}
+
+// bindID aborts with 400 unless :id path param is UUID, postgres would fail with 500 on malformed id
+func bindID(context *gin.Context) (string, bool) {
+	param := new(model.IDParam)
+	if err := context.ShouldBindUri(param); err != nil {
+		AbortWithBindingError(context, model.ErrorCodeInvalidID, "invalid id", err)
+		return "", false
+	}
+	return param.ID, true
+}
+
+// validEmailAddress accepts bare RFC 5322 address, display name form "Name <a@b>" is rejected
+func validEmailAddress(field validator.FieldLevel) bool {
+	email := field.Field().String()
+	address, err := mail.ParseAddress(email)
+	return err == nil && address.Name == "" && address.Address == email
+}
+
+// fieldName json name of the field, form or uri name for params, empty falls back to struct field name
+func fieldName(field reflect.StructField) string {
+	for _, tag := range []string{"json", "form", "uri"} {
+		name, _, _ := strings.Cut(field.Tag.Get(tag), ",")
+		if name == "-" {
+			return ""
+		}
+		if name != "" {
+			return name
+		}
+	}
+	return ""
+}
+
+// fieldErrors extracts invalid fields from binding error, nil for errors not caused by particular field (e.g. malformed json)
+func fieldErrors(err error) []model.FieldError {
+	var validationErrors validator.ValidationErrors
+	if errors.As(err, &validationErrors) {
+		fields := make([]model.FieldError, 0, len(validationErrors))
+		for _, fieldError := range validationErrors {
+			fields = append(fields, model.FieldError{
+				Field:   fieldError.Field(),
+				Rule:    fieldError.Tag(),
+				Message: validationMessage(fieldError),
+			})
+		}
+		return fields
+	}
+	var typeError *json.UnmarshalTypeError
+	if errors.As(err, &typeError) {
+		return []model.FieldError{{
+			Field:   typeError.Field,
+			Rule:    "type",
+			Message: fmt.Sprintf("must be %s", typeError.Type.Kind()),
+		}}
+	}
+	return nil
+}
+
+func validationMessage(fieldError validator.FieldError) string {
+	switch fieldError.Tag() {
+	case "required":
+		return "is required"
+	case "min":
+		return fmt.Sprintf("must be at least %s", fieldError.Param())
+	case "max":
+		return fmt.Sprintf("must be at most %s", fieldError.Param())
+	case "oneof":
+		return fmt.Sprintf("must be one of %s", fieldError.Param())
+	case "email_address":
+		return "must be valid email address"
+	case "uuid":
+		return "must be valid UUID"
+	default:
+		return fmt.Sprintf("failed %s validation", fieldError.Tag())
+	}
+}
@@ -1,67 +1,193 @@
 package rest
 
 import (
+	"cmp"
 	"context"
 	"errors"
 	"fmt"
 	"github.com/gin-gonic/gin"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go-examples/logging"
 	"go-examples/rest/api"
 	"go-examples/rest/config"
 	"go-examples/rest/database"
+	"go-examples/rest/health"
 	"go-examples/rest/middleware"
+	"go-examples/rest/model"
 	"go-examples/rest/repository"
+	"go-examples/rest/server"
+	"go-examples/rest/tracing"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/trace"
 	"log"
+	"log/slog"
+	"maps"
 	"net/http"
 	//_ "net/http/pprof" register pprof handlers
 	"os"
 	"os/signal"
+	"reflect"
+	"slices"
 	"syscall"
 	"time"
 )
 
 func StartRestAPIExample() {
-	env := os.Getenv("ENV")
-	if env == "" {
-		log.Fatalf("env is required")
+	source, err := loadConfig()
+	if err != nil {
+		log.Fatalf("error loading config: %v", err)
 	}
-	appConfig := config.Read(env)
+	appConfig := source.Get()
 
-	postgres, closable, err := database.NewPostgresDatabase(appConfig)
+	pool, closable, err := database.NewPostgresDatabase(appConfig)
 	defer closable()
 
 	if err != nil {
 		log.Fatalf("error connecting to database: %v", err)
 	}
 
+	if appConfig.DB.MigrateOnStartup {
+		applied, err := migrateUp(context.Background(), pool)
+		if err != nil {
+			log.Fatalf("error migrating database: %v", err)
+		}
+		log.Printf("applied migrations: %v", applied)
+	}
+
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(logging.StringToSlogLevel(appConfig.Log.Level).Level())
+	logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
+		Level: logLevel,
+	}))
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), &appConfig.Tracing)
+	if err != nil {
+		log.Fatalf("error setting up tracing: %v", err)
+	}
+	defer func() {
+		if err := shutdownTracing(context.Background()); err != nil {
+			log.Printf("error shutting down tracing: %v", err)
+		}
+	}()
+	postgres, pools, closeCluster := newCluster(appConfig, pool)
+	defer closeCluster()
+
 	middleware.RegisterMetrics()
-	authentication := middleware.NewAuthentication()
-	userAPI := api.NewUserAPI(repository.NewUserRepository(postgres, &appConfig.DB))
-	healthAPI := api.NewHealthAPI(postgres)
+	database.RegisterMetrics(pools)
+	repository.RegisterMetrics()
+	apiKeyRepository := repository.NewAPIKeyRepository(postgres, &appConfig.DB)
+	authentication, err := middleware.NewAuthentication(apiKeyRepository, &appConfig.Auth)
+	if err != nil {
+		log.Fatalf("error setting up authentication: %v", err)
+	}
+	userAPI := api.NewUserAPI(repository.NewUserRepository(postgres, &appConfig.DB), &appConfig.API)
+	readiness := health.NewRegistry(&appConfig.Health)
+	readiness.Register("database", postgres.Ping)
+	readiness.Register("database_pool", database.PoolCheck(pool))
+	healthAPI := api.NewHealthAPI(postgres, readiness)
+	apiKeyAPI := api.NewAPIKeyAPI(apiKeyRepository)
+
+	configAPI := api.NewConfigAPI(source)
 
-	router := setupRouter(authentication, healthAPI, userAPI)
+	rateLimiter := middleware.NewRateLimiter(middleware.NewMemoryRateLimitStore(), &appConfig.RateLimit)
+
+	source.OnChange(func(previous, current *config.AppConfig) {
+		logLevel.Set(logging.StringToSlogLevel(current.Log.Level).Level())
+		rateLimiter.Reload(&current.RateLimit)
+		authentication.Reload(&current.Auth)
+		if !reloadable(previous, current) {
+			slog.Warn("config changed beyond log level, rate limits, static keys and client certificates, restart to apply it")
+		}
+	})
+	source.Watch()
 
-	srv := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", appConfig.Server.Host, appConfig.Server.Port),
-		Handler: router.Handler(),
+	router := setupRouter(logger, otel.GetTracerProvider(), &appConfig.Server, authentication, rateLimiter, healthAPI, userAPI, apiKeyAPI, configAPI)
+
+	srv := server.New(&appConfig.Server, router.Handler())
+	certificates, err := server.NewCertificates(&appConfig.Server.TLS)
+	if err != nil {
+		log.Fatalf("error setting up tls: %v", err)
+	}
+	if certificates != nil {
+		srv.TLSConfig = certificates.TLSConfig()
+		rotationCtx, stopRotation := context.WithCancel(context.Background())
+		defer stopRotation()
+		go certificates.Run(rotationCtx)
 	}
 
 	go func() {
+		listen := srv.ListenAndServe
+		if srv.TLSConfig != nil {
+			//certificates come from TLSConfig
+			listen = func() error { return srv.ListenAndServeTLS("", "") }
+		}
 		//http.ErrServerClosed is returned when server.Shutdown is called
-		if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		if err := listen(); err != nil && !errors.Is(err, http.ErrServerClosed) {
 			log.Fatalf("error starting server: %v", err)
 		}
 	}()
-	gracefulShutdown(srv)
+	gracefulShutdown(srv, readiness, appConfig.Health.ShutdownDelay, server.ShutdownTimeout(&appConfig.Server))
+}
+
+const defaultHealthCheckInterval = 5 * time.Second
+
+// newCluster routes reads to replicas next to the primary pool, returns all pools by node name.
+// Nodes are checked before it returns so healthy replicas serve reads right away, then in the background until closed.
+func newCluster(appConfig *config.AppConfig, primary *pgxpool.Pool) (*database.Cluster, map[string]*pgxpool.Pool, func()) {
+	replicaPools, closeReplicas, err := database.NewReplicaPools(appConfig)
+	if err != nil {
+		log.Fatalf("error connecting to replicas: %v", err)
+	}
+	pools := map[string]*pgxpool.Pool{database.RolePrimary: primary}
+	replicas := make([]*database.Node, 0, len(replicaPools))
+	for _, name := range slices.Sorted(maps.Keys(replicaPools)) {
+		pools[name] = replicaPools[name]
+		replicas = append(replicas, database.PoolNode(name, replicaPools[name], otel.GetTracerProvider()))
+	}
+	cluster := database.NewCluster(database.PoolNode(database.RolePrimary, primary, otel.GetTracerProvider()), replicas, appConfig.DB.ReplicaBalancing)
+	interval := cmp.Or(appConfig.DB.HealthCheckInterval, defaultHealthCheckInterval)
+	checkCtx, cancel := context.WithTimeout(context.Background(), interval)
+	cluster.Check(checkCtx)
+	cancel()
+	ctx, stopChecks := context.WithCancel(context.Background())
+	go cluster.Run(ctx, interval)
+	return cluster, pools, func() {
+		stopChecks()
+		closeReplicas()
+	}
+}
+
+func loadConfig() (*config.Source, error) {
+	env := os.Getenv("ENV")
+	if env == "" {
+		return nil, errors.New("env is required")
+	}
+	return config.Load(env)
 }
 
-func gracefulShutdown(server *http.Server) {
+// reloadable reports whether configs differ only in settings applied at runtime
+func reloadable(previous, current *config.AppConfig) bool {
+	before, after := *previous, *current
+	before.Log.Level, after.Log.Level = "", ""
+	before.RateLimit, after.RateLimit = config.RateLimitConfig{}, config.RateLimitConfig{}
+	before.Auth.StaticKeys, after.Auth.StaticKeys = nil, nil
+	before.Auth.ClientCerts, after.Auth.ClientCerts = nil, nil
+	return reflect.DeepEqual(before, after)
+}
+
+// gracefulShutdown fails readiness for delay first, so load balancer stops routing new requests before connections are drained.
+// In-flight requests are waited for up to timeout.
+func gracefulShutdown(server *http.Server, readiness *health.Registry, delay time.Duration, timeout time.Duration) {
 	shutdown := make(chan os.Signal, 1)
 	signal.Notify(shutdown, syscall.SIGINT, syscall.SIGTERM)
 
 	<-shutdown //blocks until shutdown signal is received
-	log.Println("shutting down server")
+	readiness.Drain()
+	log.Printf("shutting down server in %v", delay)
+	time.Sleep(delay)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
 	defer cancel()
 	if err := server.Shutdown(ctx); err != nil {
 		log.Fatalf("error shutting down server: %v", err)
@@ -73,9 +199,17 @@ func gracefulShutdown(server *http.Server) {
 	}
 }
 
-func setupRouter(auth middleware.Authentication, health api.HealthAPI, user api.UserAPI) *gin.Engine {
-	g := gin.Default()
-	g.Use(middleware.Metrics())
+func setupRouter(logger *slog.Logger, tracerProvider trace.TracerProvider, serverConfig *config.ServerConfig, auth middleware.Authentication, limiter middleware.RateLimiter, health api.HealthAPI, user api.UserAPI, apiKey api.APIKeyAPI, configAPI api.ConfigAPI) *gin.Engine {
+	g := gin.New()
+	//gin trusts X-Forwarded-For of any peer by default, client could pick the ip it's rate limited and logged by
+	if err := g.SetTrustedProxies(serverConfig.TrustedProxies); err != nil {
+		panic(fmt.Sprintf("invalid trusted proxies: %v", err))
+	}
+	//handlers pass gin context to repositories, fallback exposes span from request context through it
+	g.ContextWithFallback = true
+	//recovery runs inside request logger so panics are logged with 500 status
+	g.Use(middleware.Tracing(tracerProvider), middleware.RequestLogger(logger), gin.Recovery(), middleware.Metrics(), middleware.ReadYourWrites(),
+		middleware.BodyLimit(serverConfig), middleware.Compression(&serverConfig.Compression))
 
 	/*Example how to wire in http profiler into gin
 	g.GET("/debug/pprof/profile", gin.WrapH(http.DefaultServeMux))
@@ -87,14 +221,62 @@ func setupRouter(auth middleware.Authentication, health api.HealthAPI, user api.
 
 	g.GET("/metrics", middleware.MetricsHandler())
 	g.GET("/health", health.Health)
+	g.GET("/livez", health.Livez)
+	g.GET("/readyz", health.Readyz)
 
-	userGroup := g.Group("/api/v1").Use(auth.RequireAPIToken())
-	{
-		userGroup.GET("/users", user.GetUsers)
-		userGroup.GET("/users/:id", user.GetUserById)
-		userGroup.POST("/users", user.CreateUser)
-		userGroup.DELETE("/users/:id", user.DeleteUser)
-		userGroup.PUT("/users/:id", user.UpdateUser)
-	}
+	//authentication limit runs before principal is known so it's per ip, it throttles guessing of keys and tokens.
+	//group limits run after authentication so clients are told apart by principal rather than ip
+	authLimit := limiter.Limit("authentication")
+	handle(g.Group("/api/v1", authLimit, auth.RequireGroup("users"), limiter.Limit("users")), userRoutes(user))
+	handle(g.Group("/api/v1/keys", authLimit, auth.RequireGroup("keys"), limiter.Limit("keys")), apiKeyRoutes(apiKey))
+	handle(g.Group("/api/v1/config", authLimit, auth.RequireGroup("config"), limiter.Limit("config")), configRoutes(configAPI))
 	return g
 }
+
+// route is registered together with the scope required to call it, api routes are added only through handle
+// so none of them can end up without authorization
+type route struct {
+	method  string
+	path    string
+	scope   string
+	handler gin.HandlerFunc
+}
+
+func userRoutes(user api.UserAPI) []route {
+	return []route{
+		{http.MethodGet, "/users", model.ScopeUsersRead, user.GetUsers},
+		{http.MethodGet, "/users/:id", model.ScopeUsersRead, user.GetUserById},
+		{http.MethodPost, "/users", model.ScopeUsersWrite, user.CreateUser},
+		{http.MethodDelete, "/users/:id", model.ScopeUsersDelete, user.DeleteUser},
+		{http.MethodPost, "/users/:id/restore", model.ScopeUsersDelete, user.RestoreUser},
+		{http.MethodPost, "/users/purge", model.ScopeUsersAdmin, user.PurgeUsers},
+		{http.MethodGet, "/users/:id/audit", model.ScopeAuditRead, user.GetUserAudit},
+		{http.MethodPut, "/users/:id", model.ScopeUsersWrite, user.UpdateUser},
+		{http.MethodPatch, "/users/:id", model.ScopeUsersWrite, user.PatchUser},
+		{http.MethodPost, "/users/import", model.ScopeUsersWrite, user.ImportUsers},
+		{http.MethodGet, "/users/export", model.ScopeUsersRead, user.ExportUsers},
+	}
+}
+
+func apiKeyRoutes(apiKey api.APIKeyAPI) []route {
+	return []route{
+		{http.MethodPost, "", model.ScopeKeysAdmin, apiKey.CreateKey},
+		{http.MethodPost, "/:id/rotate", model.ScopeKeysAdmin, apiKey.RotateKey},
+		{http.MethodDelete, "/:id", model.ScopeKeysAdmin, apiKey.RevokeKey},
+	}
+}
+
+func configRoutes(configAPI api.ConfigAPI) []route {
+	return []route{
+		{http.MethodGet, "", model.ScopeConfigRead, configAPI.GetConfig},
+	}
+}
+
+func handle(group *gin.RouterGroup, routes []route) {
+	for _, r := range routes {
+		if r.scope == "" {
+			panic(fmt.Sprintf("route %s %s%s has no scope", r.method, group.BasePath(), r.path))
+		}
+		group.Handle(r.method, r.path, middleware.RequireScope(r.scope), r.handler)
+	}
+}
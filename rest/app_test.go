@@ -5,31 +5,45 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/require"
+	"go-examples/rest/config"
+	"go-examples/rest/middleware"
+	"go-examples/rest/model"
+	"go.opentelemetry.io/otel/trace/noop"
+	"io"
+	"log/slog"
+	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 )
 
+var discardLogger = slog.New(slog.NewJSONHandler(io.Discard, nil))
+
 func TestHealthExposed(t *testing.T) {
 	//given
 	gin.SetMode(gin.TestMode)
-	healthMock, authMock, userMock := setupMocks()
-	router := setupRouter(authMock, healthMock, userMock)
+	healthMock, authMock, limiterMock, userMock, apiKeyMock, configMock := setupMocks()
+	router := setupRouter(discardLogger, noop.NewTracerProvider(), &config.ServerConfig{}, authMock, limiterMock, healthMock, userMock, apiKeyMock, configMock)
+	probes := map[string]string{"/health": "Health", "/livez": "Livez", "/readyz": "Readyz"}
 
-	//when
-	rq := httptest.NewRequest("GET", "/health", nil)
-	recorder := httptest.NewRecorder()
-	router.ServeHTTP(recorder, rq)
+	for path, method := range probes {
+		//when
+		rq := httptest.NewRequest("GET", path, nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, rq)
 
-	//then
-	healthMock.AssertCalled(t, "Health", mock.Anything)
-	authMock.assertNotCalled(t)
+		//then probes are called without authentication and rate limiting
+		healthMock.AssertCalled(t, method, mock.Anything)
+		authMock.assertNotCalled(t)
+		require.False(t, limiterMock.called, "rate limiter called")
+	}
 }
 
 func TestUserAPIExposed(t *testing.T) {
 	//given
 	gin.SetMode(gin.TestMode)
-	healthMock, authMock, userMock := setupMocks()
-	router := setupRouter(authMock, healthMock, userMock)
+	healthMock, authMock, limiterMock, userMock, apiKeyMock, configMock := setupMocks()
+	router := setupRouter(discardLogger, noop.NewTracerProvider(), &config.ServerConfig{}, authMock, limiterMock, healthMock, userMock, apiKeyMock, configMock)
 
 	tests := []struct {
 		method                string
@@ -45,9 +59,27 @@ func TestUserAPIExposed(t *testing.T) {
 		{"DELETE", "/api/v1/users/abc", func() {
 			userMock.AssertCalled(t, "DeleteUser", mock.Anything)
 		}},
+		{"POST", "/api/v1/users/abc/restore", func() {
+			userMock.AssertCalled(t, "RestoreUser", mock.Anything)
+		}},
+		{"POST", "/api/v1/users/purge", func() {
+			userMock.AssertCalled(t, "PurgeUsers", mock.Anything)
+		}},
+		{"GET", "/api/v1/users/abc/audit", func() {
+			userMock.AssertCalled(t, "GetUserAudit", mock.Anything)
+		}},
 		{"PUT", "/api/v1/users/abc", func() {
 			userMock.AssertCalled(t, "UpdateUser", mock.Anything)
 		}},
+		{"PATCH", "/api/v1/users/abc", func() {
+			userMock.AssertCalled(t, "PatchUser", mock.Anything)
+		}},
+		{"POST", "/api/v1/users/import", func() {
+			userMock.AssertCalled(t, "ImportUsers", mock.Anything)
+		}},
+		{"GET", "/api/v1/users/export", func() {
+			userMock.AssertCalled(t, "ExportUsers", mock.Anything)
+		}},
 		{"GET", "/api/v1/users/abc", func() {
 			userMock.AssertCalled(t, "GetUserById", mock.Anything)
 		}},
@@ -61,24 +93,226 @@ func TestUserAPIExposed(t *testing.T) {
 
 			test.expectedHandlerCalled()
 			authMock.assertCalled(t)
+			require.True(t, limiterMock.called, "rate limiter not called")
+			limiterMock.called = false
 		})
 	}
+	limiterMock.AssertCalled(t, "Limit", "authentication")
+	limiterMock.AssertCalled(t, "Limit", "users")
 }
 
-func setupMocks() (*HealthMock, *AuthenticationMock, *UserMock) {
+func TestAPIKeyAPIExposed(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	healthMock, authMock, limiterMock, userMock, apiKeyMock, configMock := setupMocks()
+	router := setupRouter(discardLogger, noop.NewTracerProvider(), &config.ServerConfig{}, authMock, limiterMock, healthMock, userMock, apiKeyMock, configMock)
+
+	tests := []struct {
+		method                string
+		path                  string
+		expectedHandlerCalled func()
+	}{
+		{"POST", "/api/v1/keys", func() {
+			apiKeyMock.AssertCalled(t, "CreateKey", mock.Anything)
+		}},
+		{"POST", "/api/v1/keys/abc/rotate", func() {
+			apiKeyMock.AssertCalled(t, "RotateKey", mock.Anything)
+		}},
+		{"DELETE", "/api/v1/keys/abc", func() {
+			apiKeyMock.AssertCalled(t, "RevokeKey", mock.Anything)
+		}},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("'%s%s'", test.method, test.path), func(t *testing.T) {
+			rq := httptest.NewRequest(test.method, test.path, nil)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, rq)
+
+			test.expectedHandlerCalled()
+			authMock.assertCalled(t)
+			require.True(t, limiterMock.called, "rate limiter not called")
+			limiterMock.called = false
+		})
+	}
+	limiterMock.AssertCalled(t, "Limit", "keys")
+}
+
+func TestConfigAPIExposed(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	healthMock, authMock, limiterMock, userMock, apiKeyMock, configMock := setupMocks()
+	router := setupRouter(discardLogger, noop.NewTracerProvider(), &config.ServerConfig{}, authMock, limiterMock, healthMock, userMock, apiKeyMock, configMock)
+
+	//when
+	rq := httptest.NewRequest("GET", "/api/v1/config", nil)
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, rq)
+
+	//then
+	configMock.AssertCalled(t, "GetConfig", mock.Anything)
+	authMock.assertCalled(t)
+	require.True(t, limiterMock.called, "rate limiter not called")
+	limiterMock.AssertCalled(t, "Limit", "config")
+}
+
+func TestScopeEnforced(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	healthMock, authMock, limiterMock, userMock, apiKeyMock, configMock := setupMocks()
+	authMock.scopes = []string{model.ScopeUsersRead}
+	router := setupRouter(discardLogger, noop.NewTracerProvider(), &config.ServerConfig{}, authMock, limiterMock, healthMock, userMock, apiKeyMock, configMock)
+
+	tests := []struct {
+		method         string
+		path           string
+		expectedStatus int
+	}{
+		{"GET", "/api/v1/users/abc", http.StatusOK},
+		{"GET", "/api/v1/users/export", http.StatusOK},
+		{"POST", "/api/v1/users", http.StatusForbidden},
+		{"PUT", "/api/v1/users/abc", http.StatusForbidden},
+		{"DELETE", "/api/v1/users/abc", http.StatusForbidden},
+		{"POST", "/api/v1/users/purge", http.StatusForbidden},
+		{"GET", "/api/v1/users/abc/audit", http.StatusForbidden},
+		{"POST", "/api/v1/keys", http.StatusForbidden},
+		{"GET", "/api/v1/config", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		t.Run(fmt.Sprintf("'%s%s'", test.method, test.path), func(t *testing.T) {
+			//when
+			rq := httptest.NewRequest(test.method, test.path, nil)
+			recorder := httptest.NewRecorder()
+			router.ServeHTTP(recorder, rq)
+
+			//then
+			require.Equal(t, test.expectedStatus, recorder.Code)
+		})
+	}
+	userMock.AssertNotCalled(t, "CreateUser", mock.Anything)
+	userMock.AssertNotCalled(t, "UpdateUser", mock.Anything)
+	userMock.AssertNotCalled(t, "DeleteUser", mock.Anything)
+	userMock.AssertNotCalled(t, "PurgeUsers", mock.Anything)
+	apiKeyMock.AssertNotCalled(t, "CreateKey", mock.Anything)
+	configMock.AssertNotCalled(t, "GetConfig", mock.Anything)
+}
+
+func TestAPIRoutesRequireScope(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	healthMock, authMock, limiterMock, userMock, apiKeyMock, configMock := setupMocks()
+	authMock.scopes = []string{}
+	router := setupRouter(discardLogger, noop.NewTracerProvider(), &config.ServerConfig{}, authMock, limiterMock, healthMock, userMock, apiKeyMock, configMock)
+
+	for _, route := range router.Routes() {
+		if !strings.HasPrefix(route.Path, "/api/") {
+			continue
+		}
+		//when
+		rq := httptest.NewRequest(route.Method, strings.ReplaceAll(route.Path, ":id", "abc"), nil)
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, rq)
+
+		//then
+		require.Equal(t, http.StatusForbidden, recorder.Code, "%s %s", route.Method, route.Path)
+	}
+}
+
+func TestForwardedForTrustedOnlyFromProxies(t *testing.T) {
+	tests := []struct {
+		name           string
+		trustedProxies []string
+		expectedCodes  []int
+	}{
+		//spoofed header doesn't get client new bucket of the limit applied before authentication
+		{"untrusted peer", nil, []int{http.StatusOK, http.StatusTooManyRequests}},
+		{"trusted proxy", []string{"192.0.2.0/24"}, []int{http.StatusOK, http.StatusOK}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			//given
+			gin.SetMode(gin.TestMode)
+			healthMock, authMock, _, userMock, apiKeyMock, configMock := setupMocks()
+			limiter := middleware.NewRateLimiter(middleware.NewMemoryRateLimitStore(), &config.RateLimitConfig{
+				Groups: map[string]config.RateLimit{"authentication": {RequestsPerSecond: 0.001, Burst: 1}},
+			})
+			router := setupRouter(discardLogger, noop.NewTracerProvider(), &config.ServerConfig{TrustedProxies: tt.trustedProxies},
+				authMock, limiter, healthMock, userMock, apiKeyMock, configMock)
+			codes := make([]int, 0, 2)
+
+			//when each request claims other client ip, peer address is the same
+			for _, forwardedFor := range []string{"203.0.113.1", "203.0.113.2"} {
+				rq := httptest.NewRequest("GET", "/api/v1/users", nil)
+				rq.Header.Set("X-Forwarded-For", forwardedFor)
+				recorder := httptest.NewRecorder()
+				router.ServeHTTP(recorder, rq)
+				codes = append(codes, recorder.Code)
+			}
+
+			//then
+			require.Equal(t, tt.expectedCodes, codes)
+		})
+	}
+}
+
+func TestReloadable(t *testing.T) {
+	//given
+	previous := &config.AppConfig{Log: config.LogConfig{Level: "INFO"}, DB: config.DBConfig{Host: "localhost"}}
+	runtime := &config.AppConfig{Log: config.LogConfig{Level: "DEBUG"}, DB: config.DBConfig{Host: "localhost"},
+		RateLimit: config.RateLimitConfig{Groups: map[string]config.RateLimit{"users": {RequestsPerSecond: 1, Burst: 1}}},
+		Auth:      config.AuthConfig{StaticKeys: []config.StaticKeyConfig{{Key: "rotated", Owner: "local"}}}}
+	restart := &config.AppConfig{Log: config.LogConfig{Level: "INFO"}, DB: config.DBConfig{Host: "db.internal"}}
+
+	//then
+	require.True(t, reloadable(previous, runtime))
+	require.False(t, reloadable(previous, restart))
+}
+
+func TestHandleRejectsRouteWithoutScope(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	group := gin.New().Group("/api")
+
+	//when
+	register := func() {
+		handle(group, []route{{http.MethodGet, "/open", "", func(*gin.Context) {}}})
+	}
+
+	//then
+	require.Panics(t, register)
+}
+
+func setupMocks() (*HealthMock, *AuthenticationMock, *RateLimiterMock, *UserMock, *APIKeyMock, *ConfigMock) {
 	healthMock := new(HealthMock)
 	authMock := new(AuthenticationMock)
+	limiterMock := new(RateLimiterMock)
 	userMock := new(UserMock)
+	apiKeyMock := new(APIKeyMock)
+	configMock := new(ConfigMock)
 
 	healthMock.On("Health", mock.Anything).Return()
-	authMock.On("RequireAPIToken").Return()
+	healthMock.On("Livez", mock.Anything).Return()
+	healthMock.On("Readyz", mock.Anything).Return()
+	authMock.On("RequireGroup", mock.Anything).Return()
+	limiterMock.On("Limit", mock.Anything).Return()
 	userMock.On("GetUsers", mock.Anything).Return()
 	userMock.On("GetUserById", mock.Anything).Return()
 	userMock.On("CreateUser", mock.Anything).Return()
 	userMock.On("DeleteUser", mock.Anything).Return()
+	userMock.On("RestoreUser", mock.Anything).Return()
+	userMock.On("PurgeUsers", mock.Anything).Return()
+	userMock.On("GetUserAudit", mock.Anything).Return()
 	userMock.On("UpdateUser", mock.Anything).Return()
+	userMock.On("PatchUser", mock.Anything).Return()
+	userMock.On("ImportUsers", mock.Anything).Return()
+	userMock.On("ExportUsers", mock.Anything).Return()
+	apiKeyMock.On("CreateKey", mock.Anything).Return()
+	apiKeyMock.On("RotateKey", mock.Anything).Return()
+	apiKeyMock.On("RevokeKey", mock.Anything).Return()
+	configMock.On("GetConfig", mock.Anything).Return()
 
-	return healthMock, authMock, userMock
+	return healthMock, authMock, limiterMock, userMock, apiKeyMock, configMock
 
 }
 
@@ -90,9 +324,19 @@ func (h *HealthMock) Health(ctx *gin.Context) {
 	_ = h.Called(ctx)
 }
 
+func (h *HealthMock) Livez(ctx *gin.Context) {
+	_ = h.Called(ctx)
+}
+
+func (h *HealthMock) Readyz(ctx *gin.Context) {
+	_ = h.Called(ctx)
+}
+
 type AuthenticationMock struct {
 	mock.Mock
 	called bool
+	//scopes granted to the principal, all scopes when nil
+	scopes []string
 }
 
 func (a *AuthenticationMock) assertCalled(t *testing.T) {
@@ -111,6 +355,50 @@ func (a *AuthenticationMock) RequireAPIToken() gin.HandlerFunc {
 	}
 }
 
+func (a *AuthenticationMock) RequireBearerToken() gin.HandlerFunc {
+	_ = a.Called()
+	return func(context *gin.Context) {
+		a.called = true
+	}
+}
+
+func (a *AuthenticationMock) RequireClientCert() gin.HandlerFunc {
+	_ = a.Called()
+	return func(context *gin.Context) {
+		a.called = true
+	}
+}
+
+func (a *AuthenticationMock) Reload(*config.AuthConfig) {
+}
+
+func (a *AuthenticationMock) RequireGroup(group string) gin.HandlerFunc {
+	_ = a.Called(group)
+	return func(context *gin.Context) {
+		a.called = true
+		scopes := a.scopes
+		if scopes == nil {
+			scopes = []string{model.ScopeUsersRead, model.ScopeUsersWrite, model.ScopeUsersDelete, model.ScopeUsersAdmin, model.ScopeAuditRead, model.ScopeKeysAdmin, model.ScopeConfigRead}
+		}
+		context.Set(model.PrincipalKey, &model.Principal{ID: "test", Scopes: scopes})
+	}
+}
+
+type RateLimiterMock struct {
+	mock.Mock
+	called bool
+}
+
+func (r *RateLimiterMock) Limit(group string) gin.HandlerFunc {
+	_ = r.Called(group)
+	return func(context *gin.Context) {
+		r.called = true
+	}
+}
+
+func (r *RateLimiterMock) Reload(*config.RateLimitConfig) {
+}
+
 type UserMock struct {
 	mock.Mock
 }
@@ -131,6 +419,54 @@ func (u *UserMock) DeleteUser(context *gin.Context) {
 	_ = u.Called(context)
 }
 
+func (u *UserMock) RestoreUser(context *gin.Context) {
+	_ = u.Called(context)
+}
+
+func (u *UserMock) PurgeUsers(context *gin.Context) {
+	_ = u.Called(context)
+}
+
+func (u *UserMock) GetUserAudit(context *gin.Context) {
+	_ = u.Called(context)
+}
+
 func (u *UserMock) UpdateUser(context *gin.Context) {
 	_ = u.Called(context)
 }
+
+func (u *UserMock) PatchUser(context *gin.Context) {
+	_ = u.Called(context)
+}
+
+func (u *UserMock) ImportUsers(context *gin.Context) {
+	_ = u.Called(context)
+}
+
+func (u *UserMock) ExportUsers(context *gin.Context) {
+	_ = u.Called(context)
+}
+
+type APIKeyMock struct {
+	mock.Mock
+}
+
+func (a *APIKeyMock) CreateKey(context *gin.Context) {
+	_ = a.Called(context)
+}
+
+func (a *APIKeyMock) RotateKey(context *gin.Context) {
+	_ = a.Called(context)
+}
+
+func (a *APIKeyMock) RevokeKey(context *gin.Context) {
+	_ = a.Called(context)
+}
+
+type ConfigMock struct {
+	mock.Mock
+}
+
+func (c *ConfigMock) GetConfig(context *gin.Context) {
+	_ = c.Called(context)
+}
@@ -0,0 +1,96 @@
+package rest
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/spf13/cobra"
+	"go-examples/rest/database"
+	"go-examples/rest/migration"
+	"log"
+)
+
+// Command serves the api when run without subcommand, migrate subcommand manages database schema only.
+// Both read config selected by ENV variable.
+func Command() *cobra.Command {
+	root := &cobra.Command{
+		Use:   "rest",
+		Short: "Users REST API example",
+		Run: func(cmd *cobra.Command, args []string) {
+			StartRestAPIExample()
+		},
+	}
+	migrate := &cobra.Command{
+		Use:   "migrate",
+		Short: "Manage database schema migrations",
+	}
+	up := &cobra.Command{
+		Use:   "up",
+		Short: "Apply all pending migrations",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withPool(func(pool *pgxpool.Pool) error {
+				applied, err := migrateUp(cmd.Context(), pool)
+				log.Printf("applied migrations: %v", applied)
+				return err
+			})
+		},
+	}
+	down := &cobra.Command{
+		Use:   "down",
+		Short: "Revert most recently applied migrations",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			steps, err := cmd.Flags().GetInt("steps")
+			if err != nil {
+				return err
+			}
+			return withPool(func(pool *pgxpool.Pool) error {
+				migrator, err := migration.New(pool)
+				if err != nil {
+					return err
+				}
+				reverted, err := migrator.Down(cmd.Context(), steps)
+				log.Printf("reverted migrations: %v", reverted)
+				return err
+			})
+		},
+	}
+	down.Flags().IntP("steps", "n", 1, "number of migrations to revert")
+	version := &cobra.Command{
+		Use:   "version",
+		Short: "Print the latest applied migration",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withPool(func(pool *pgxpool.Pool) error {
+				migrator, err := migration.New(pool)
+				if err != nil {
+					return err
+				}
+				current, err := migrator.Version(cmd.Context())
+				log.Printf("schema version: %d", current)
+				return err
+			})
+		},
+	}
+	migrate.AddCommand(up, down, version)
+	root.AddCommand(migrate)
+	return root
+}
+
+func withPool(run func(*pgxpool.Pool) error) error {
+	source, err := loadConfig()
+	if err != nil {
+		return err
+	}
+	pool, closable, err := database.NewPostgresDatabase(source.Get())
+	if err != nil {
+		return err
+	}
+	defer closable()
+	return run(pool)
+}
+
+func migrateUp(ctx context.Context, pool *pgxpool.Pool) ([]int64, error) {
+	migrator, err := migration.New(pool)
+	if err != nil {
+		return nil, err
+	}
+	return migrator.Up(ctx)
+}
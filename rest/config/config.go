@@ -1,44 +1,172 @@
 package config
 
 import (
-	"fmt"
-	"github.com/spf13/viper"
-	"log"
 	"time"
 )
 
+// AppConfig fields tagged secret are redacted by Redacted.
+// Log level, rate limits, static keys and client certificate scopes are applied at runtime when config changes, other fields need restart.
 type AppConfig struct {
-	Server ServerConfig `mapstructure:"server"`
-	DB     DBConfig     `mapstructure:"db"`
+	Server    ServerConfig    `mapstructure:"server"`
+	DB        DBConfig        `mapstructure:"db"`
+	API       APIConfig       `mapstructure:"api"`
+	Auth      AuthConfig      `mapstructure:"auth"`
+	RateLimit RateLimitConfig `mapstructure:"rate_limit"`
+	Log       LogConfig       `mapstructure:"log"`
+	Tracing   TracingConfig   `mapstructure:"tracing"`
+	Health    HealthConfig    `mapstructure:"health"`
+}
+
+type HealthConfig struct {
+	//how long check results are reused so probes can't overload dependencies, 1s when not set
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+	//limit of single check, check that didn't finish in time is down; 1s when not set
+	Timeout time.Duration `mapstructure:"timeout"`
+	//readiness fails that long before server stops accepting connections, so load balancer stops routing to it first
+	ShutdownDelay time.Duration `mapstructure:"shutdown_delay"`
+}
+
+type TracingConfig struct {
+	//none (default), otlp, stdout or file
+	Exporter    string `mapstructure:"exporter"`
+	ServiceName string `mapstructure:"service_name"`
+	//otlp http collector host:port, OTEL_EXPORTER_OTLP_* environment variables apply when empty
+	Endpoint string `mapstructure:"endpoint"`
+	Insecure bool   `mapstructure:"insecure"`
+	//path spans are appended to by file exporter
+	File string `mapstructure:"file"`
+	//fraction of new traces sampled, sampling decision of incoming trace is respected; all traces when not set
+	SampleRatio float64 `mapstructure:"sample_ratio"`
+}
+
+type LogConfig struct {
+	//DEBUG, INFO, WARN or ERROR, INFO when empty
+	Level string `mapstructure:"level"`
 }
 
 type ServerConfig struct {
-	Host string `mapstructure:"host"`
-	Port int    `mapstructure:"port"`
+	Host string    `mapstructure:"host"`
+	Port int       `mapstructure:"port"`
+	TLS  TLSConfig `mapstructure:"tls"`
+	//responses are compressed according to Accept-Encoding
+	Compression CompressionConfig `mapstructure:"compression"`
+	//connection is dropped when headers aren't read in time, 5s when not set
+	ReadHeaderTimeout time.Duration `mapstructure:"read_header_timeout"`
+	//limit of reading whole request, client that doesn't send body in time gets 408; no limit when not set
+	ReadTimeout time.Duration `mapstructure:"read_timeout"`
+	//limit of handling request and writing response, it has to cover bulk export; no limit when not set
+	WriteTimeout time.Duration `mapstructure:"write_timeout"`
+	//how long keep-alive connection waits for next request, read timeout when not set
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+	//larger headers get 431, 1MB when not set
+	MaxHeaderBytes int `mapstructure:"max_header_bytes"`
+	//larger request bodies get 413, 1MiB when not set
+	MaxBodyBytes int64 `mapstructure:"max_body_bytes"`
+	//body limits of particular routes keyed by method and path as registered, e.g. "POST /api/v1/users/import"
+	RouteMaxBodyBytes map[string]int64 `mapstructure:"route_max_body_bytes"`
+	//how long in-flight requests are waited for on shutdown, 5s when not set
+	ShutdownTimeout time.Duration `mapstructure:"shutdown_timeout"`
+	//ips or cidrs of proxies allowed to set client ip with X-Forwarded-For, none when empty so client ip is the peer address
+	TrustedProxies []string `mapstructure:"trusted_proxies"`
+}
+
+// CompressionConfig responses are sent uncompressed when no encodings are set
+type CompressionConfig struct {
+	//br, zstd or gzip in order of preference, used when client accepts them with the same quality
+	Encodings []string `mapstructure:"encodings"`
+	//smaller responses are sent uncompressed as compressing them saves little, 1KiB when not set
+	MinSize int `mapstructure:"min_size"`
+}
+
+// TLSConfig server serves plain http when cert file is empty
+type TLSConfig struct {
+	CertFile string `mapstructure:"cert_file"`
+	KeyFile  string `mapstructure:"key_file"`
+	//1.2 (default) or 1.3
+	MinVersion string `mapstructure:"min_version"`
+	//names of secure suites listed by crypto/tls, its defaults when empty; TLS 1.3 suites aren't configurable
+	CipherSuites []string `mapstructure:"cipher_suites"`
+	//none (default), optional - certificate is verified only when client sends one, or require
+	ClientAuth string `mapstructure:"client_auth"`
+	//bundle of CAs client certificates are verified against
+	ClientCAFile string `mapstructure:"client_ca_file"`
+	//how often files are checked for rotated certificate or CA bundle, 1m when not set
+	ReloadInterval time.Duration `mapstructure:"reload_interval"`
+}
+
+type APIConfig struct {
+	//when true DELETE of missing user responds 204 instead of 404
+	IdempotentDelete bool `mapstructure:"idempotent_delete"`
+	//deleted users can be restored until purged, purge removes only those deleted longer than that ago; required
+	PurgeRetention time.Duration `mapstructure:"purge_retention"`
+}
+
+type AuthConfig struct {
+	//how long api key lookups are cached, revocation takes effect after at most that long
+	CacheTTL time.Duration `mapstructure:"cache_ttl"`
+	//keys not stored in database, required to bootstrap key management
+	StaticKeys []StaticKeyConfig `mapstructure:"static_keys"`
+	JWT        JWTConfig         `mapstructure:"jwt"`
+	//authentication schemes (api_key, bearer, client_cert) accepted by each route group, api_key only when group is missing
+	Groups map[string][]string `mapstructure:"groups"`
+	//scopes granted to verified client certificates by subject, certificate of other subject is granted none
+	ClientCerts []ClientCertConfig `mapstructure:"client_certs"`
+}
+
+type ClientCertConfig struct {
+	//distinguished name as formatted by crypto/x509, e.g. CN=reporting,O=Example
+	Subject string   `mapstructure:"subject"`
+	Scopes  []string `mapstructure:"scopes"`
+}
+
+type JWTConfig struct {
+	HS256Secret string `mapstructure:"hs256_secret" secret:"true"`
+	//RS256 and ES256 public keys, optionally symmetric keys for HS256
+	JWKSFile  string        `mapstructure:"jwks_file"`
+	Issuer    string        `mapstructure:"issuer"`
+	Audience  string        `mapstructure:"audience"`
+	ClockSkew time.Duration `mapstructure:"clock_skew"`
+}
+
+type RateLimitConfig struct {
+	//limits per route group, group without limit is not throttled
+	Groups map[string]RateLimit `mapstructure:"groups"`
+}
+
+// RateLimit token bucket refilled with RequestsPerSecond tokens up to Burst
+type RateLimit struct {
+	RequestsPerSecond float64 `mapstructure:"requests_per_second"`
+	Burst             int     `mapstructure:"burst"`
+}
+
+type StaticKeyConfig struct {
+	Key    string   `mapstructure:"key" secret:"true"`
+	Owner  string   `mapstructure:"owner"`
+	Scopes []string `mapstructure:"scopes"`
 }
 
 type DBConfig struct {
 	User     string        `mapstructure:"user"`
-	Password string        `mapstructure:"password"`
+	Password string        `mapstructure:"password" secret:"true"`
 	Host     string        `mapstructure:"host"`
 	Port     int           `mapstructure:"port"`
 	Database string        `mapstructure:"database"`
 	Timeout  time.Duration `mapstructure:"timeout"`
-	PoolMax  int           `mapstructure:"pool_max_conns"`
-	PoolMin  int           `mapstructure:"pool_min_conns"`
-}
-
-func Read(env string) *AppConfig {
-	viper.SetConfigType("yaml")
-	viper.SetConfigFile(fmt.Sprintf("rest/config-%s.yaml", env))
-	err := viper.ReadInConfig()
-	if err != nil {
-		log.Fatalf("error reading config: %v", err)
-	}
-	config := new(AppConfig)
-	err = viper.Unmarshal(config)
-	if err != nil {
-		log.Fatalf("error unmarshalling config: %v", err)
-	}
-	return config
+	//bulk operations (import/export) stream whole table so regular timeout would be too short
+	BulkTimeout time.Duration `mapstructure:"bulk_timeout"`
+	PoolMax     int           `mapstructure:"pool_max_conns"`
+	//pending migrations are applied before server starts, otherwise they're run with migrate command
+	MigrateOnStartup bool `mapstructure:"migrate_on_startup"`
+	PoolMin          int  `mapstructure:"pool_min_conns"`
+	//host and port above are the primary, replicas share its credentials and pool sizes
+	Replicas []ReplicaConfig `mapstructure:"replicas"`
+	//round_robin (default) or least_connections
+	ReplicaBalancing string `mapstructure:"replica_balancing"`
+	//how often nodes are pinged (5s when not set), replica failing the check gets no reads until it passes again
+	HealthCheckInterval time.Duration `mapstructure:"health_check_interval"`
+}
+
+type ReplicaConfig struct {
+	Host string `mapstructure:"host"`
+	Port int    `mapstructure:"port"`
 }
@@ -0,0 +1,227 @@
+package config
+
+import (
+	"github.com/stretchr/testify/require"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const validConfig = `
+server:
+  port: 8080
+db:
+  host: localhost
+  port: 5432
+  user: postgres
+  password: postgres
+  database: postgres
+  pool_max_conns: 1
+  timeout: 250ms
+  bulk_timeout: 5m
+api:
+  purge_retention: 720h
+auth:
+  static_keys:
+    - key: token
+      owner: local
+      scopes: [ users:read ]
+rate_limit:
+  groups:
+    users:
+      requests_per_second: 50
+      burst: 100
+log:
+  level: INFO
+`
+
+func TestLocalConfigsValid(t *testing.T) {
+	for _, path := range []string{"../config-local.yaml", "../config-local-docker.yaml"} {
+		//when
+		_, err := NewSource(path)
+
+		//then
+		require.NoError(t, err, path)
+	}
+}
+
+func TestEnvOverridesConfig(t *testing.T) {
+	//given
+	path := writeConfig(t, validConfig)
+	t.Setenv("APP_DB_HOST", "db.internal")
+	t.Setenv("APP_DB_TIMEOUT", "1s")
+	t.Setenv("APP_SERVER_HOST", "0.0.0.0")
+	t.Setenv("APP_RATE_LIMIT_GROUPS_USERS_BURST", "10")
+
+	//when
+	source, err := NewSource(path)
+
+	//then field present in file, field missing from it and map entry are overridden
+	require.NoError(t, err)
+	require.Equal(t, "db.internal", source.Get().DB.Host)
+	require.Equal(t, time.Second, source.Get().DB.Timeout)
+	require.Equal(t, "0.0.0.0", source.Get().Server.Host)
+	require.Equal(t, 10, source.Get().RateLimit.Groups["users"].Burst)
+}
+
+func TestSecretReadFromFile(t *testing.T) {
+	//given
+	path := writeConfig(t, validConfig)
+	secret := filepath.Join(t.TempDir(), "password")
+	require.NoError(t, os.WriteFile(secret, []byte("s3cret\n"), 0600))
+	t.Setenv("APP_DB_PASSWORD", "ignored")
+	t.Setenv("APP_DB_PASSWORD_FILE", secret)
+	t.Setenv("APP_AUTH_JWT_HS256_SECRET_FILE", secret)
+
+	//when
+	source, err := NewSource(path)
+
+	//then
+	require.NoError(t, err)
+	require.Equal(t, "s3cret", source.Get().DB.Password)
+	require.Equal(t, "s3cret", source.Get().Auth.JWT.HS256Secret)
+}
+
+func TestSecretFileMissing(t *testing.T) {
+	//given
+	path := writeConfig(t, validConfig)
+	t.Setenv("APP_DB_PASSWORD_FILE", filepath.Join(t.TempDir(), "missing"))
+
+	//when
+	_, err := NewSource(path)
+
+	//then
+	require.ErrorContains(t, err, "APP_DB_PASSWORD_FILE")
+}
+
+func TestValidationAggregatesErrors(t *testing.T) {
+	//given
+	path := writeConfig(t, `
+server:
+  port: 0
+db:
+  host: localhost
+  port: 5432
+  user: postgres
+  database: postgres
+  pool_max_conns: 1
+  pool_min_conns: 2
+  timeout: 250ms
+  bulk_timeout: 5m
+  replica_balancing: random
+log:
+  level: VERBOSE
+`)
+
+	//when
+	_, err := NewSource(path)
+
+	//then
+	require.ErrorIs(t, err, ErrInvalid)
+	require.EqualError(t, err, `invalid config:
+server.port: must be between 1 and 65535, got 0
+db.pool_min_conns: must be between 0 and pool_max_conns, got 2
+db.replica_balancing: must be one of round_robin, least_connections, got "random"
+api.purge_retention: must be positive, got 0s
+log.level: must be one of DEBUG, INFO, WARN, ERROR, got "VERBOSE"`)
+}
+
+func TestValidationOfTLS(t *testing.T) {
+	//given
+	config := &AppConfig{Server: ServerConfig{Port: 8443, TLS: TLSConfig{
+		CertFile: "cert.pem", MinVersion: "1.1", CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}, ClientAuth: "require",
+	}}}
+
+	//when
+	err := config.Validate()
+
+	//then
+	require.ErrorContains(t, err, `server.tls.key_file: is required
+server.tls.min_version: must be one of 1.2, 1.3, got "1.1"
+server.tls.cipher_suites[0]: unknown or insecure suite "TLS_RSA_WITH_RC4_128_SHA"
+server.tls.client_ca_file: is required`)
+}
+
+func TestValidationOfServerLimits(t *testing.T) {
+	//given
+	config := &AppConfig{Server: ServerConfig{Port: 8080, Compression: CompressionConfig{Encodings: []string{"gzip", "deflate"}},
+		ReadTimeout: -time.Second, MaxBodyBytes: -1,
+		RouteMaxBodyBytes: map[string]int64{"/api/v1/users/import": 1, "post /api/v1/users": 0}, TrustedProxies: []string{"10.0.0.0/8", "proxy"}}}
+
+	//when
+	err := config.Validate()
+
+	//then
+	require.ErrorContains(t, err, `server.compression.encodings[1]: must be one of br, zstd, gzip, got "deflate"
+server.read_timeout: must not be negative, got -1s
+server.max_body_bytes: must not be negative, got -1
+server.route_max_body_bytes: route must be method and path, got "/api/v1/users/import"
+server.route_max_body_bytes.post /api/v1/users: must be positive, got 0
+server.trusted_proxies[1]: must be ip or cidr, got "proxy"`)
+}
+
+func TestReloadNotifiesListeners(t *testing.T) {
+	//given
+	path := writeConfig(t, validConfig)
+	source, err := NewSource(path)
+	require.NoError(t, err)
+	var previous, current *AppConfig
+	source.OnChange(func(p, c *AppConfig) {
+		previous, current = p, c
+	})
+	require.NoError(t, os.WriteFile(path, []byte(strings.Replace(validConfig, "level: INFO", "level: DEBUG", 1)), 0600))
+
+	//when
+	source.reload()
+
+	//then
+	require.Equal(t, "INFO", previous.Log.Level)
+	require.Equal(t, "DEBUG", current.Log.Level)
+	require.Same(t, current, source.Get())
+}
+
+func TestReloadKeepsConfigWhenInvalid(t *testing.T) {
+	//given
+	path := writeConfig(t, validConfig)
+	source, err := NewSource(path)
+	require.NoError(t, err)
+	loaded := source.Get()
+	source.OnChange(func(*AppConfig, *AppConfig) {
+		t.Fatal("listener called with invalid config")
+	})
+	require.NoError(t, os.WriteFile(path, []byte(strings.Replace(validConfig, "level: INFO", "level: VERBOSE", 1)), 0600))
+
+	//when
+	source.reload()
+
+	//then
+	require.Same(t, loaded, source.Get())
+}
+
+func TestDumpRedactsSecrets(t *testing.T) {
+	//given
+	source, err := NewSource(writeConfig(t, validConfig))
+	require.NoError(t, err)
+
+	//when
+	dump := source.Get().Dump()
+
+	//then secrets that are set are redacted, durations formatted
+	db := dump["db"].(map[string]any)
+	require.Equal(t, Redacted, db["password"])
+	require.Equal(t, "localhost", db["host"])
+	require.Equal(t, "250ms", db["timeout"])
+	auth := dump["auth"].(map[string]any)
+	require.Equal(t, Redacted, auth["static_keys"].([]any)[0].(map[string]any)["key"])
+	require.Equal(t, "local", auth["static_keys"].([]any)[0].(map[string]any)["owner"])
+	require.Equal(t, "", auth["jwt"].(map[string]any)["hs256_secret"])
+	require.Equal(t, 100, dump["rate_limit"].(map[string]any)["groups"].(map[string]any)["users"].(map[string]any)["burst"])
+}
+
+func writeConfig(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	return path
+}
@@ -0,0 +1,47 @@
+package config
+
+import (
+	"reflect"
+	"time"
+)
+
+// Redacted replaces secrets that are set
+const Redacted = "[REDACTED]"
+
+// Dump returns config keyed the same as its file with secrets redacted, durations are formatted like in the file
+func (config *AppConfig) Dump() map[string]any {
+	return dump(reflect.ValueOf(*config)).(map[string]any)
+}
+
+func dump(value reflect.Value) any {
+	switch value.Kind() {
+	case reflect.Struct:
+		result := make(map[string]any, value.NumField())
+		for i := range value.NumField() {
+			field, fieldValue := value.Type().Field(i), value.Field(i)
+			if field.Tag.Get("secret") == "true" && !fieldValue.IsZero() {
+				result[field.Tag.Get("mapstructure")] = Redacted
+				continue
+			}
+			result[field.Tag.Get("mapstructure")] = dump(fieldValue)
+		}
+		return result
+	case reflect.Slice:
+		result := make([]any, value.Len())
+		for i := range value.Len() {
+			result[i] = dump(value.Index(i))
+		}
+		return result
+	case reflect.Map:
+		result := make(map[string]any, value.Len())
+		for key, entry := range value.Seq2() {
+			result[key.String()] = dump(entry)
+		}
+		return result
+	default:
+		if duration, ok := value.Interface().(time.Duration); ok {
+			return duration.String()
+		}
+		return value.Interface()
+	}
+}
@@ -0,0 +1,153 @@
+package config
+
+import (
+	"fmt"
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+	"iter"
+	"log/slog"
+	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// EnvPrefix of variables overriding config, e.g. APP_DB_PASSWORD overrides db.password
+const EnvPrefix = "APP"
+
+// fileSuffix of variables naming file the value is read from, e.g. APP_DB_PASSWORD_FILE
+const fileSuffix = "_FILE"
+
+// Source holds config read from file with environment overrides applied, current config is replaced when file changes
+type Source struct {
+	viper     *viper.Viper
+	current   atomic.Pointer[AppConfig]
+	mu        sync.Mutex
+	listeners []func(previous, current *AppConfig)
+}
+
+// Load reads rest/config-<env>.yaml, see NewSource
+func Load(env string) (*Source, error) {
+	return NewSource(fmt.Sprintf("rest/config-%s.yaml", env))
+}
+
+// NewSource reads config from yaml file at path. Every field can be overridden by environment variable named after its
+// key with EnvPrefix, or by content of file named by the same variable with _FILE suffix which takes precedence.
+// Entries of lists and maps can be overridden only when they're present in file. Config that isn't valid is rejected.
+func NewSource(path string) (*Source, error) {
+	v := viper.New()
+	v.SetConfigType("yaml")
+	v.SetConfigFile(path)
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+	for _, key := range keys(reflect.TypeFor[AppConfig](), "") {
+		if err := v.BindEnv(key); err != nil {
+			return nil, err
+		}
+	}
+	source := &Source{viper: v}
+	config, err := source.read()
+	if err != nil {
+		return nil, err
+	}
+	source.current.Store(config)
+	return source, nil
+}
+
+// Get returns current config, it must not be modified
+func (source *Source) Get() *AppConfig {
+	return source.current.Load()
+}
+
+// OnChange registers fn called with previous and current config after file changed, listeners run one at a time
+func (source *Source) OnChange(fn func(previous, current *AppConfig)) {
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	source.listeners = append(source.listeners, fn)
+}
+
+// Watch reloads config whenever its file changes, config that can't be read or isn't valid is logged and ignored
+func (source *Source) Watch() {
+	source.viper.OnConfigChange(func(fsnotify.Event) {
+		source.reload()
+	})
+	source.viper.WatchConfig()
+}
+
+func (source *Source) reload() {
+	config, err := source.read()
+	if err != nil {
+		slog.Error("config not reloaded", "error", err)
+		return
+	}
+	source.mu.Lock()
+	defer source.mu.Unlock()
+	previous := source.current.Swap(config)
+	slog.Info("config reloaded")
+	for _, listener := range source.listeners {
+		listener(previous, config)
+	}
+}
+
+func (source *Source) read() (*AppConfig, error) {
+	if err := source.viper.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("error reading config: %w", err)
+	}
+	if err := source.readFiles(); err != nil {
+		return nil, err
+	}
+	config := new(AppConfig)
+	if err := source.viper.Unmarshal(config); err != nil {
+		return nil, fmt.Errorf("error unmarshalling config: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return config, nil
+}
+
+// readFiles sets keys whose _FILE variable is present to content of the file, trailing newline is dropped
+func (source *Source) readFiles() error {
+	for _, key := range source.viper.AllKeys() {
+		name := EnvPrefix + "_" + strings.ToUpper(strings.ReplaceAll(key, ".", "_")) + fileSuffix
+		path, ok := os.LookupEnv(name)
+		if !ok {
+			continue
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading %s: %w", name, err)
+		}
+		source.viper.Set(key, strings.TrimRight(string(content), "\r\n"))
+	}
+	return nil
+}
+
+// keys lists keys of struct fields that can be set by single variable, lists and maps of structs can't
+func keys(t reflect.Type, prefix string) []string {
+	var result []string
+	for field := range fields(t) {
+		key := prefix + field.Tag.Get("mapstructure")
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			result = append(result, keys(field.Type, key+".")...)
+		case field.Type.Kind() == reflect.Map, field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct:
+		default:
+			result = append(result, key)
+		}
+	}
+	return result
+}
+
+// fields of struct type t in declaration order
+func fields(t reflect.Type) iter.Seq[reflect.StructField] {
+	return func(yield func(reflect.StructField) bool) {
+		for i := range t.NumField() {
+			if !yield(t.Field(i)) {
+				return
+			}
+		}
+	}
+}
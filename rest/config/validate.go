@@ -0,0 +1,149 @@
+package config
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"maps"
+	"net"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ErrInvalid wraps all problems found by Validate
+var ErrInvalid = errors.New("invalid config")
+
+// Validate reports every invalid field at once rather than the first one, so config can be fixed in one go
+func (config *AppConfig) Validate() error {
+	var v validator
+	v.port("server.port", config.Server.Port)
+	v.tls("server.tls", &config.Server.TLS)
+	for i, encoding := range config.Server.Compression.Encodings {
+		v.oneOf(fmt.Sprintf("server.compression.encodings[%d]", i), encoding, "br", "zstd", "gzip")
+	}
+	v.check(config.Server.Compression.MinSize >= 0, "server.compression.min_size", "must not be negative, got %d", config.Server.Compression.MinSize)
+	v.notNegative("server.read_header_timeout", config.Server.ReadHeaderTimeout)
+	v.notNegative("server.read_timeout", config.Server.ReadTimeout)
+	v.notNegative("server.write_timeout", config.Server.WriteTimeout)
+	v.notNegative("server.idle_timeout", config.Server.IdleTimeout)
+	v.check(config.Server.MaxHeaderBytes >= 0, "server.max_header_bytes", "must not be negative, got %d", config.Server.MaxHeaderBytes)
+	v.check(config.Server.MaxBodyBytes >= 0, "server.max_body_bytes", "must not be negative, got %d", config.Server.MaxBodyBytes)
+	for _, route := range slices.Sorted(maps.Keys(config.Server.RouteMaxBodyBytes)) {
+		_, path, _ := strings.Cut(route, " ")
+		v.check(strings.HasPrefix(path, "/"), "server.route_max_body_bytes", "route must be method and path, got %q", route)
+		v.check(config.Server.RouteMaxBodyBytes[route] > 0, "server.route_max_body_bytes."+route,
+			"must be positive, got %d", config.Server.RouteMaxBodyBytes[route])
+	}
+	v.notNegative("server.shutdown_timeout", config.Server.ShutdownTimeout)
+	for i, proxy := range config.Server.TrustedProxies {
+		_, _, cidrErr := net.ParseCIDR(proxy)
+		v.check(cidrErr == nil || net.ParseIP(proxy) != nil, fmt.Sprintf("server.trusted_proxies[%d]", i), "must be ip or cidr, got %q", proxy)
+	}
+
+	v.required("db.host", config.DB.Host)
+	v.port("db.port", config.DB.Port)
+	v.required("db.user", config.DB.User)
+	v.required("db.database", config.DB.Database)
+	v.positive("db.timeout", config.DB.Timeout)
+	v.positive("db.bulk_timeout", config.DB.BulkTimeout)
+	v.check(config.DB.PoolMax >= 1, "db.pool_max_conns", "must be at least 1, got %d", config.DB.PoolMax)
+	v.check(config.DB.PoolMin >= 0 && config.DB.PoolMin <= config.DB.PoolMax, "db.pool_min_conns",
+		"must be between 0 and pool_max_conns, got %d", config.DB.PoolMin)
+	for i, replica := range config.DB.Replicas {
+		v.required(fmt.Sprintf("db.replicas[%d].host", i), replica.Host)
+		v.port(fmt.Sprintf("db.replicas[%d].port", i), replica.Port)
+	}
+	v.oneOf("db.replica_balancing", config.DB.ReplicaBalancing, "round_robin", "least_connections")
+	v.notNegative("db.health_check_interval", config.DB.HealthCheckInterval)
+
+	//zero would purge users deleted a moment ago, leaving no time to restore them
+	v.positive("api.purge_retention", config.API.PurgeRetention)
+
+	v.notNegative("auth.cache_ttl", config.Auth.CacheTTL)
+	for i, key := range config.Auth.StaticKeys {
+		v.required(fmt.Sprintf("auth.static_keys[%d].key", i), key.Key)
+		v.required(fmt.Sprintf("auth.static_keys[%d].owner", i), key.Owner)
+	}
+	v.notNegative("auth.jwt.clock_skew", config.Auth.JWT.ClockSkew)
+	for i, cert := range config.Auth.ClientCerts {
+		v.required(fmt.Sprintf("auth.client_certs[%d].subject", i), cert.Subject)
+	}
+
+	for _, group := range slices.Sorted(maps.Keys(config.RateLimit.Groups)) {
+		limit := config.RateLimit.Groups[group]
+		v.check(limit.RequestsPerSecond >= 0, "rate_limit.groups."+group+".requests_per_second",
+			"must not be negative, got %v", limit.RequestsPerSecond)
+		v.check(limit.Burst >= 0, "rate_limit.groups."+group+".burst", "must not be negative, got %d", limit.Burst)
+	}
+
+	v.oneOf("log.level", strings.ToUpper(config.Log.Level), "DEBUG", "INFO", "WARN", "ERROR")
+
+	v.oneOf("tracing.exporter", config.Tracing.Exporter, "none", "otlp", "stdout", "file")
+	if config.Tracing.Exporter == "file" {
+		v.required("tracing.file", config.Tracing.File)
+	}
+	v.check(config.Tracing.SampleRatio >= 0 && config.Tracing.SampleRatio <= 1, "tracing.sample_ratio",
+		"must be between 0 and 1, got %v", config.Tracing.SampleRatio)
+
+	v.notNegative("health.cache_ttl", config.Health.CacheTTL)
+	v.notNegative("health.timeout", config.Health.Timeout)
+	v.notNegative("health.shutdown_delay", config.Health.ShutdownDelay)
+
+	if len(v.errs) > 0 {
+		return fmt.Errorf("%w:\n%w", ErrInvalid, errors.Join(v.errs...))
+	}
+	return nil
+}
+
+func (v *validator) tls(key string, config *TLSConfig) {
+	if config.CertFile == "" {
+		v.check(config.ClientAuth == "" || config.ClientAuth == "none", key+".client_auth", "requires cert_file")
+		return
+	}
+	v.required(key+".key_file", config.KeyFile)
+	v.oneOf(key+".min_version", config.MinVersion, "1.2", "1.3")
+	secure := make([]string, 0, len(tls.CipherSuites()))
+	for _, suite := range tls.CipherSuites() {
+		secure = append(secure, suite.Name)
+	}
+	for i, name := range config.CipherSuites {
+		v.check(slices.Contains(secure, name), fmt.Sprintf("%s.cipher_suites[%d]", key, i), "unknown or insecure suite %q", name)
+	}
+	v.oneOf(key+".client_auth", config.ClientAuth, "none", "optional", "require")
+	if config.ClientAuth == "optional" || config.ClientAuth == "require" {
+		v.required(key+".client_ca_file", config.ClientCAFile)
+	}
+	v.notNegative(key+".reload_interval", config.ReloadInterval)
+}
+
+type validator struct {
+	errs []error
+}
+
+func (v *validator) check(valid bool, key string, format string, args ...any) {
+	if !valid {
+		v.errs = append(v.errs, fmt.Errorf("%s: %s", key, fmt.Sprintf(format, args...)))
+	}
+}
+
+func (v *validator) required(key string, value string) {
+	v.check(value != "", key, "is required")
+}
+
+func (v *validator) port(key string, port int) {
+	v.check(port >= 1 && port <= 65535, key, "must be between 1 and 65535, got %d", port)
+}
+
+func (v *validator) positive(key string, duration time.Duration) {
+	v.check(duration > 0, key, "must be positive, got %v", duration)
+}
+
+func (v *validator) notNegative(key string, duration time.Duration) {
+	v.check(duration >= 0, key, "must not be negative, got %v", duration)
+}
+
+// oneOf allows empty value too, it selects default
+func (v *validator) oneOf(key string, value string, allowed ...string) {
+	v.check(value == "" || slices.Contains(allowed, value), key, "must be one of %s, got %q", strings.Join(allowed, ", "), value)
+}
@@ -0,0 +1,258 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go-examples/rest/model"
+	"go.opentelemetry.io/otel/trace"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// replica balancing policies
+const (
+	RoundRobin       = "round_robin"
+	LeastConnections = "least_connections"
+)
+
+// ErrNotChecked is reported for node whose health isn't known yet
+var ErrNotChecked = errors.New("node not checked yet")
+
+// node roles reported by Status
+const (
+	RolePrimary = "primary"
+	RoleReplica = "replica"
+)
+
+// Node single postgres server, unhealthy until the first successful check
+type Node struct {
+	Database
+	Name string
+	//connections in use, least connections balancing picks replica with the fewest
+	InUse   func() int32
+	healthy atomic.Bool
+	//error of the last check, nil until node is checked for the first time
+	lastErr atomic.Pointer[error]
+}
+
+func NewNode(name string, database Database, inUse func() int32) *Node {
+	return &Node{Database: database, Name: name, InUse: inUse}
+}
+
+// PoolNode traces statements run on the pool, connections in use are read from pool statistics
+func PoolNode(name string, pool *pgxpool.Pool, provider trace.TracerProvider) *Node {
+	return NewNode(name, NewTracedDatabase(pool, provider), func() int32 {
+		return pool.Stat().AcquiredConns()
+	})
+}
+
+// Cluster runs statements on the primary, only read-only statements obtained through Reader go to replicas.
+// Replica is chosen among healthy ones, primary serves reads when there is none.
+type Cluster struct {
+	primary   *Node
+	replicas  []*Node
+	balancing string
+	next      atomic.Uint64
+}
+
+// NewCluster balances reads with round robin unless balancing is LeastConnections
+func NewCluster(primary *Node, replicas []*Node, balancing string) *Cluster {
+	return &Cluster{primary: primary, replicas: replicas, balancing: balancing}
+}
+
+func (cluster *Cluster) Ping(ctx context.Context) error {
+	return cluster.primary.Ping(ctx)
+}
+
+func (cluster *Cluster) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	markWritten(ctx)
+	return cluster.primary.Query(ctx, sql, args...)
+}
+
+func (cluster *Cluster) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	markWritten(ctx)
+	return cluster.primary.Exec(ctx, sql, args...)
+}
+
+func (cluster *Cluster) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	markWritten(ctx)
+	return cluster.primary.QueryRow(ctx, sql, args...)
+}
+
+func (cluster *Cluster) Begin(ctx context.Context) (pgx.Tx, error) {
+	markWritten(ctx)
+	return cluster.primary.Begin(ctx)
+}
+
+func (cluster *Cluster) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	markWritten(ctx)
+	return cluster.primary.BeginTx(ctx, opts)
+}
+
+// reader is the primary once request wrote, so it reads its own writes, healthy replica otherwise
+func (cluster *Cluster) reader(ctx context.Context) Querier {
+	if written(ctx) {
+		return cluster.primary
+	}
+	if replica := cluster.pick(); replica != nil {
+		return replica
+	}
+	return cluster.primary
+}
+
+func (cluster *Cluster) pick() *Node {
+	healthy := make([]*Node, 0, len(cluster.replicas))
+	for _, replica := range cluster.replicas {
+		if replica.healthy.Load() {
+			healthy = append(healthy, replica)
+		}
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	if cluster.balancing == LeastConnections {
+		least := healthy[0]
+		for _, replica := range healthy[1:] {
+			if replica.InUse() < least.InUse() {
+				least = replica
+			}
+		}
+		return least
+	}
+	return healthy[(cluster.next.Add(1)-1)%uint64(len(healthy))]
+}
+
+// Check pings all nodes at once and updates their health, unhealthy replica gets no reads until it passes again
+func (cluster *Cluster) Check(ctx context.Context) []model.NodeStatus {
+	nodes := cluster.nodes()
+	statuses := make([]model.NodeStatus, len(nodes))
+	var wg sync.WaitGroup
+	for i, node := range nodes {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			statuses[i] = check(ctx, node, cluster.role(node))
+		}()
+	}
+	wg.Wait()
+	return statuses
+}
+
+// Statuses reports nodes as of their last check, nodes aren't pinged
+func (cluster *Cluster) Statuses() []model.NodeStatus {
+	nodes := cluster.nodes()
+	statuses := make([]model.NodeStatus, len(nodes))
+	for i, node := range nodes {
+		statuses[i] = status(node, cluster.role(node))
+	}
+	return statuses
+}
+
+func (cluster *Cluster) nodes() []*Node {
+	return append([]*Node{cluster.primary}, cluster.replicas...)
+}
+
+func (cluster *Cluster) role(node *Node) string {
+	if node == cluster.primary {
+		return RolePrimary
+	}
+	return RoleReplica
+}
+
+// Run checks nodes every interval until ctx is done, each check is limited by the interval
+func (cluster *Cluster) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			checkCtx, cancel := context.WithTimeout(ctx, interval)
+			cluster.Check(checkCtx)
+			cancel()
+		}
+	}
+}
+
+func check(ctx context.Context, node *Node, role string) model.NodeStatus {
+	err := node.Ping(ctx)
+	node.lastErr.Store(&err)
+	node.healthy.Store(err == nil)
+	healthy := 0.0
+	if err == nil {
+		healthy = 1
+	}
+	nodeHealthy.WithLabelValues(node.Name, role).Set(healthy)
+	return status(node, role)
+}
+
+func status(node *Node, role string) model.NodeStatus {
+	err := ErrNotChecked
+	if lastErr := node.lastErr.Load(); lastErr != nil {
+		err = *lastErr
+	}
+	return model.NodeStatus{Name: node.Name, Role: role, Healthy: err == nil, InUse: node.InUse(), Err: err}
+}
+
+// Status reports every node of db. Cluster is reported as of its last check, so requests can't overload nodes with pings,
+// db that isn't Cluster has no checks running and is pinged as the only primary.
+func Status(ctx context.Context, db Database) []model.NodeStatus {
+	if cluster, ok := db.(*Cluster); ok {
+		return cluster.Statuses()
+	}
+	err := db.Ping(ctx)
+	return []model.NodeStatus{{Name: RolePrimary, Role: RolePrimary, Healthy: err == nil, Err: err}}
+}
+
+// Reader returns where read-only statements of ctx should run: transaction carried by ctx,
+// node chosen by db when it's Cluster or db itself otherwise
+func Reader(ctx context.Context, db Database) Querier {
+	if tx := TxFrom(ctx); tx != nil {
+		return tx
+	}
+	if cluster, ok := db.(*Cluster); ok {
+		return cluster.reader(ctx)
+	}
+	return db
+}
+
+// Primary returns where reads that have to see the latest committed state should run: transaction carried by ctx,
+// primary of db when it's Cluster or db itself otherwise. Unlike writes, such reads don't make request stick to the primary.
+func Primary(ctx context.Context, db Database) Querier {
+	if tx := TxFrom(ctx); tx != nil {
+		return tx
+	}
+	if cluster, ok := db.(*Cluster); ok {
+		return cluster.primary
+	}
+	return db
+}
+
+type sessionKey struct{}
+
+// session remembers whether request wrote to the primary
+type session struct {
+	written atomic.Bool
+}
+
+// WithSession makes reads of ctx go to the primary after its first write, so request reads its own writes
+// despite replication lag
+func WithSession(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sessionKey{}, new(session))
+}
+
+func markWritten(ctx context.Context) {
+	if s, ok := ctx.Value(sessionKey{}).(*session); ok {
+		s.written.Store(true)
+	}
+}
+
+func written(ctx context.Context) bool {
+	s, ok := ctx.Value(sessionKey{}).(*session)
+	return ok && s.written.Load()
+}
@@ -0,0 +1,173 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/test"
+	"testing"
+)
+
+func TestReaderRoundRobinOverHealthyReplicas(t *testing.T) {
+	//given second replica fails its check
+	cluster, primary, replicas := newClusterMocks(RoundRobin, nil, nil, errors.New("connection refused"), nil)
+	cluster.Check(context.Background())
+
+	//when
+	var readers []Querier
+	for range 4 {
+		readers = append(readers, Reader(context.Background(), cluster))
+	}
+
+	//then
+	require.Equal(t, []Querier{replicas[0], replicas[2], replicas[0], replicas[2]}, readers)
+	require.NotContains(t, readers, primary)
+}
+
+func TestReaderLeastConnections(t *testing.T) {
+	//given
+	cluster, _, replicas := newClusterMocks(LeastConnections, nil, nil, nil, nil)
+	cluster.Check(context.Background())
+	inUse := map[*Node]int32{replicas[0]: 3, replicas[1]: 1, replicas[2]: 2}
+	for _, replica := range replicas {
+		replica.InUse = func() int32 { return inUse[replica] }
+	}
+
+	//when
+	reader := Reader(context.Background(), cluster)
+
+	//then
+	require.Same(t, replicas[1], reader)
+}
+
+func TestReaderFallsBackToPrimary(t *testing.T) {
+	//given replicas are unhealthy until checked
+	cluster, primary, _ := newClusterMocks(RoundRobin, nil, nil)
+
+	//when
+	reader := Reader(context.Background(), cluster)
+
+	//then
+	require.Same(t, primary, reader)
+}
+
+func TestReaderReadsOwnWrites(t *testing.T) {
+	//given
+	cluster, primary, replicas := newClusterMocks(RoundRobin, nil, nil)
+	cluster.Check(context.Background())
+	primary.Database.(*test.DatabaseMock).On("Exec", mock.Anything, mock.Anything, mock.Anything).
+		Return(pgconn.CommandTag{}, nil)
+	ctx := WithSession(context.Background())
+
+	//when
+	before := Reader(ctx, cluster)
+	_, err := cluster.Exec(ctx, "UPDATE")
+	after := Reader(ctx, cluster)
+
+	//then
+	require.NoError(t, err)
+	require.Same(t, replicas[0], before)
+	require.Same(t, primary, after)
+	require.Same(t, replicas[0], Reader(context.Background(), cluster))
+}
+
+func TestReaderJoinsTransaction(t *testing.T) {
+	//given
+	cluster, primary, _ := newClusterMocks(RoundRobin, nil, nil)
+	cluster.Check(context.Background())
+	tx := &test.TxMock{}
+	primary.Database.(*test.DatabaseMock).On("BeginTx", mock.Anything, pgx.TxOptions{}).Return(tx, nil)
+	tx.On("Commit", mock.Anything).Return(nil)
+	tx.On("Rollback", mock.Anything).Return(nil)
+
+	//when
+	var reader Querier
+	err := WithTx(context.Background(), cluster, pgx.TxOptions{}, func(ctx context.Context, _ pgx.Tx) error {
+		reader = Reader(ctx, cluster)
+		return nil
+	})
+
+	//then
+	require.NoError(t, err)
+	require.Same(t, tx, reader)
+}
+
+func TestPrimaryDoesNotStick(t *testing.T) {
+	//given
+	cluster, primary, replicas := newClusterMocks(RoundRobin, nil, nil)
+	cluster.Check(context.Background())
+	ctx := WithSession(context.Background())
+
+	//when
+	conn := Primary(ctx, cluster)
+
+	//then
+	require.Same(t, primary, conn)
+	require.Same(t, replicas[0], Reader(ctx, cluster))
+}
+
+func TestStatus(t *testing.T) {
+	//given
+	pingErr := errors.New("connection refused")
+	cluster, primary, _ := newClusterMocks(RoundRobin, pingErr, nil)
+	cluster.Check(context.Background())
+
+	//when
+	statuses := Status(context.Background(), cluster)
+
+	//then last check is reported without pinging nodes again
+	primary.Database.(*test.DatabaseMock).AssertNumberOfCalls(t, "Ping", 1)
+	require.Len(t, statuses, 2)
+	require.Equal(t, "primary", statuses[0].Name)
+	require.Equal(t, RolePrimary, statuses[0].Role)
+	require.False(t, statuses[0].Healthy)
+	require.Equal(t, pingErr, statuses[0].Err)
+	require.Equal(t, "replica-1", statuses[1].Name)
+	require.Equal(t, RoleReplica, statuses[1].Role)
+	require.True(t, statuses[1].Healthy)
+}
+
+func TestStatusBeforeFirstCheck(t *testing.T) {
+	//given
+	cluster, _, _ := newClusterMocks(RoundRobin, nil)
+
+	//when
+	statuses := Status(context.Background(), cluster)
+
+	//then
+	require.False(t, statuses[0].Healthy)
+	require.ErrorIs(t, statuses[0].Err, ErrNotChecked)
+}
+
+func TestStatusOfSingleDatabase(t *testing.T) {
+	//given
+	database := &test.DatabaseMock{}
+	database.On("Ping", mock.Anything).Return(nil)
+
+	//when
+	statuses := Status(context.Background(), database)
+
+	//then
+	require.Len(t, statuses, 1)
+	require.Equal(t, RolePrimary, statuses[0].Role)
+	require.True(t, statuses[0].Healthy)
+}
+
+// newClusterMocks pings of primary and replicas return given errors, first one belongs to primary
+func newClusterMocks(balancing string, pingErrs ...error) (*Cluster, *Node, []*Node) {
+	nodes := make([]*Node, len(pingErrs))
+	for i, pingErr := range pingErrs {
+		database := &test.DatabaseMock{}
+		database.On("Ping", mock.Anything).Return(pingErr)
+		name := "primary"
+		if i > 0 {
+			name = fmt.Sprintf("replica-%d", i)
+		}
+		nodes[i] = NewNode(name, database, func() int32 { return 0 })
+	}
+	return NewCluster(nodes[0], nodes[1:], balancing), nodes[0], nodes[1:]
+}
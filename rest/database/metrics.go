@@ -0,0 +1,88 @@
+package database
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+/*
+Collector reading pool statistics on every scrape, one per node
+Example metrics exposed:
+rest_app_db_pool_acquired_conns{node="primary"} 1
+rest_app_db_pool_acquire_duration_seconds_total{node="primary"} 0.0042
+rest_app_db_pool_empty_acquire_count_total{node="replica-1"} 3
+*/
+type poolCollector struct {
+	pool                 *pgxpool.Pool
+	acquiredConns        *prometheus.Desc
+	idleConns            *prometheus.Desc
+	totalConns           *prometheus.Desc
+	maxConns             *prometheus.Desc
+	constructingConns    *prometheus.Desc
+	acquireCount         *prometheus.Desc
+	acquireDuration      *prometheus.Desc
+	emptyAcquireCount    *prometheus.Desc
+	canceledAcquireCount *prometheus.Desc
+}
+
+func NewPoolCollector(node string, pool *pgxpool.Pool) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(prometheus.BuildFQName("rest_app", "db_pool", name), help, nil, prometheus.Labels{"node": node})
+	}
+	return &poolCollector{
+		pool:                 pool,
+		acquiredConns:        desc("acquired_conns", "Number of connections currently acquired from the pool"),
+		idleConns:            desc("idle_conns", "Number of idle connections in the pool"),
+		totalConns:           desc("total_conns", "Number of connections in the pool, acquired, idle and being constructed"),
+		maxConns:             desc("max_conns", "Maximum size of the pool"),
+		constructingConns:    desc("constructing_conns", "Number of connections being established"),
+		acquireCount:         desc("acquire_count_total", "Number of successful acquires from the pool"),
+		acquireDuration:      desc("acquire_duration_seconds_total", "Total time spent waiting for successful acquires"),
+		emptyAcquireCount:    desc("empty_acquire_count_total", "Number of successful acquires that had to wait because pool was empty"),
+		canceledAcquireCount: desc("canceled_acquire_count_total", "Number of acquires canceled by context"),
+	}
+}
+
+/*
+Gauge with node and role labels - 1 when node passed the last health check, 0 otherwise
+Example metrics exposed:
+rest_app_db_node_healthy{node="primary",role="primary"} 1
+rest_app_db_node_healthy{node="replica-1",role="replica"} 0
+*/
+var nodeHealthy = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+	Namespace: "rest_app",
+	Name:      "db_node_healthy",
+	Help:      "Whether database node passed the last health check",
+}, []string{"node", "role"})
+
+// RegisterMetrics exposes statistics of pools by node name and health of nodes, pool_max_conns is tuned by comparing
+// acquired to max conns and watching empty acquires and acquire wait time grow
+func RegisterMetrics(pools map[string]*pgxpool.Pool) {
+	for node, pool := range pools {
+		prometheus.MustRegister(NewPoolCollector(node, pool))
+	}
+	prometheus.MustRegister(nodeHealthy)
+}
+
+func (collector *poolCollector) Describe(descs chan<- *prometheus.Desc) {
+	prometheus.DescribeByCollect(collector, descs)
+}
+
+func (collector *poolCollector) Collect(metrics chan<- prometheus.Metric) {
+	stat := collector.pool.Stat()
+	gauge := func(desc *prometheus.Desc, value int32) {
+		metrics <- prometheus.MustNewConstMetric(desc, prometheus.GaugeValue, float64(value))
+	}
+	counter := func(desc *prometheus.Desc, value float64) {
+		metrics <- prometheus.MustNewConstMetric(desc, prometheus.CounterValue, value)
+	}
+	gauge(collector.acquiredConns, stat.AcquiredConns())
+	gauge(collector.idleConns, stat.IdleConns())
+	gauge(collector.totalConns, stat.TotalConns())
+	gauge(collector.maxConns, stat.MaxConns())
+	gauge(collector.constructingConns, stat.ConstructingConns())
+	counter(collector.acquireCount, float64(stat.AcquireCount()))
+	counter(collector.acquireDuration, stat.AcquireDuration().Seconds())
+	counter(collector.emptyAcquireCount, float64(stat.EmptyAcquireCount()))
+	counter(collector.canceledAcquireCount, float64(stat.CanceledAcquireCount()))
+}
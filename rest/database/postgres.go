@@ -2,11 +2,13 @@ package database
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"go-examples/rest/config"
+	"sync/atomic"
 	"time"
 )
 
@@ -15,10 +17,13 @@ type Database interface {
 	Query(context.Context, string, ...any) (pgx.Rows, error)
 	Exec(context.Context, string, ...any) (pgconn.CommandTag, error)
 	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Begin(context.Context) (pgx.Tx, error)
+	BeginTx(context.Context, pgx.TxOptions) (pgx.Tx, error)
 }
 
-func NewPostgresDatabase(config *config.AppConfig) (Database, func(), error) {
-	pool, err := pgxpool.New(context.Background(), connectionString(config))
+// NewPostgresDatabase connects to the primary, returns pool itself rather than Database so its statistics can be exported
+func NewPostgresDatabase(config *config.AppConfig) (*pgxpool.Pool, func(), error) {
+	pool, err := pgxpool.New(context.Background(), connectionString(config, config.DB.Host, config.DB.Port))
 	if err != nil {
 		return nil, nil, err
 	}
@@ -32,7 +37,44 @@ func NewPostgresDatabase(config *config.AppConfig) (Database, func(), error) {
 	}, nil
 }
 
-func connectionString(config *config.AppConfig) string {
+// NewReplicaPools opens pool per configured replica, keyed by name used for metrics and health.
+// Replicas aren't pinged, replica that is down only gets no reads until it passes health check.
+func NewReplicaPools(config *config.AppConfig) (map[string]*pgxpool.Pool, func(), error) {
+	pools := make(map[string]*pgxpool.Pool, len(config.DB.Replicas))
+	closeAll := func() {
+		for _, pool := range pools {
+			pool.Close()
+		}
+	}
+	for i, replica := range config.DB.Replicas {
+		pool, err := pgxpool.New(context.Background(), connectionString(config, replica.Host, replica.Port))
+		if err != nil {
+			closeAll()
+			return nil, nil, fmt.Errorf("replica %s:%d: %w", replica.Host, replica.Port, err)
+		}
+		pools[fmt.Sprintf("replica-%d", i+1)] = pool
+	}
+	return pools, closeAll, nil
+}
+
+// ErrPoolExhausted reported by PoolCheck while requests wait for connection
+var ErrPoolExhausted = errors.New("connection pool exhausted")
+
+// PoolCheck fails when all connections of the pool are in use and some acquire had to wait since the previous check
+func PoolCheck(pool *pgxpool.Pool) func(context.Context) error {
+	var emptyAcquires atomic.Int64
+	emptyAcquires.Store(pool.Stat().EmptyAcquireCount())
+	return func(context.Context) error {
+		stat := pool.Stat()
+		waited := stat.EmptyAcquireCount() > emptyAcquires.Swap(stat.EmptyAcquireCount())
+		if waited && stat.AcquiredConns() >= stat.MaxConns() {
+			return fmt.Errorf("%w: %d of %d connections in use", ErrPoolExhausted, stat.AcquiredConns(), stat.MaxConns())
+		}
+		return nil
+	}
+}
+
+func connectionString(config *config.AppConfig, host string, port int) string {
 	return fmt.Sprintf("postgres://%s:%s@%s:%d/%s?pool_max_conns=%d&pool_min_conns=%d",
-		config.DB.User, config.DB.Password, config.DB.Host, config.DB.Port, config.DB.Database, config.DB.PoolMax, config.DB.PoolMin)
+		config.DB.User, config.DB.Password, host, port, config.DB.Database, config.DB.PoolMax, config.DB.PoolMin)
 }
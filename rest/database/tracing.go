@@ -0,0 +1,214 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+const instrumentationName = "go-examples/rest/database"
+
+type tracedDatabase struct {
+	database Database
+	tracer   trace.Tracer
+}
+
+// NewTracedDatabase starts child span of the span in context for every call.
+// Query span ends when rows are closed, QueryRow span when row is scanned.
+// Transaction returned by Begin or BeginTx traces its statements, commit and rollback the same way.
+func NewTracedDatabase(database Database, provider trace.TracerProvider) Database {
+	return &tracedDatabase{
+		database: database,
+		tracer:   provider.Tracer(instrumentationName),
+	}
+}
+
+func (db *tracedDatabase) Ping(ctx context.Context) error {
+	ctx, span := db.start(ctx, "ping")
+	err := db.database.Ping(ctx)
+	end(span, err)
+	return err
+}
+
+func (db *tracedDatabase) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	ctx, span := db.start(ctx, "query", statement(sql)...)
+	rows, err := db.database.Query(ctx, sql, args...)
+	if err != nil {
+		end(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+func (db *tracedDatabase) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	ctx, span := db.start(ctx, "exec", statement(sql)...)
+	tag, err := db.database.Exec(ctx, sql, args...)
+	if err == nil {
+		span.SetAttributes(attribute.Int64("db.rows_affected", tag.RowsAffected()))
+	}
+	end(span, err)
+	return tag, err
+}
+
+func (db *tracedDatabase) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	ctx, span := db.start(ctx, "query_row", statement(sql)...)
+	return &tracedRow{row: db.database.QueryRow(ctx, sql, args...), span: span}
+}
+
+func (db *tracedDatabase) Begin(ctx context.Context) (pgx.Tx, error) {
+	ctx, span := db.start(ctx, "begin")
+	tx, err := db.database.Begin(ctx)
+	end(span, err)
+	return db.traced(tx, err)
+}
+
+func (db *tracedDatabase) BeginTx(ctx context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	ctx, span := db.start(ctx, "begin", attribute.String("db.transaction.isolation_level", isolationLevel(opts)))
+	tx, err := db.database.BeginTx(ctx, opts)
+	end(span, err)
+	return db.traced(tx, err)
+}
+
+func (db *tracedDatabase) traced(tx pgx.Tx, err error) (pgx.Tx, error) {
+	if err != nil {
+		return nil, err
+	}
+	return &tracedTx{Tx: tx, db: db}, nil
+}
+
+func (db *tracedDatabase) start(ctx context.Context, call string, attributes ...attribute.KeyValue) (context.Context, trace.Span) {
+	return db.tracer.Start(ctx, "postgres."+call,
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(semconv.DBSystemPostgreSQL),
+		trace.WithAttributes(attributes...),
+	)
+}
+
+func statement(sql string) []attribute.KeyValue {
+	attributes := []attribute.KeyValue{semconv.DBQueryText(sql)}
+	if fields := strings.Fields(sql); len(fields) > 0 {
+		attributes = append(attributes, semconv.DBOperationName(strings.ToUpper(fields[0])))
+	}
+	return attributes
+}
+
+// isolationLevel of transaction begun with opts, postgres default is read committed
+func isolationLevel(opts pgx.TxOptions) string {
+	if opts.IsoLevel == "" {
+		return string(pgx.ReadCommitted)
+	}
+	return string(opts.IsoLevel)
+}
+
+// end marks span as failed unless error only says there are no rows
+func end(span trace.Span, err error) {
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End()
+}
+
+// tracedTx starts spans for statements run within transaction, remaining pgx.Tx methods aren't traced
+type tracedTx struct {
+	pgx.Tx
+	db *tracedDatabase
+	//set once committed or rolled back, deferred rollback after commit is no-op so it isn't traced
+	closed bool
+}
+
+func (tx *tracedTx) Begin(ctx context.Context) (pgx.Tx, error) {
+	ctx, span := tx.db.start(ctx, "savepoint")
+	nested, err := tx.Tx.Begin(ctx)
+	end(span, err)
+	return tx.db.traced(nested, err)
+}
+
+func (tx *tracedTx) Query(ctx context.Context, sql string, args ...any) (pgx.Rows, error) {
+	ctx, span := tx.db.start(ctx, "query", statement(sql)...)
+	rows, err := tx.Tx.Query(ctx, sql, args...)
+	if err != nil {
+		end(span, err)
+		return nil, err
+	}
+	return &tracedRows{Rows: rows, span: span}, nil
+}
+
+func (tx *tracedTx) Exec(ctx context.Context, sql string, args ...any) (pgconn.CommandTag, error) {
+	ctx, span := tx.db.start(ctx, "exec", statement(sql)...)
+	tag, err := tx.Tx.Exec(ctx, sql, args...)
+	if err == nil {
+		span.SetAttributes(attribute.Int64("db.rows_affected", tag.RowsAffected()))
+	}
+	end(span, err)
+	return tag, err
+}
+
+func (tx *tracedTx) QueryRow(ctx context.Context, sql string, args ...any) pgx.Row {
+	ctx, span := tx.db.start(ctx, "query_row", statement(sql)...)
+	return &tracedRow{row: tx.Tx.QueryRow(ctx, sql, args...), span: span}
+}
+
+func (tx *tracedTx) CopyFrom(ctx context.Context, table pgx.Identifier, columns []string, source pgx.CopyFromSource) (int64, error) {
+	ctx, span := tx.db.start(ctx, "copy_from",
+		semconv.DBOperationName("COPY"), semconv.DBCollectionName(table.Sanitize()))
+	copied, err := tx.Tx.CopyFrom(ctx, table, columns, source)
+	if err == nil {
+		span.SetAttributes(attribute.Int64("db.rows_affected", copied))
+	}
+	end(span, err)
+	return copied, err
+}
+
+func (tx *tracedTx) Commit(ctx context.Context) error {
+	if tx.closed {
+		return tx.Tx.Commit(ctx)
+	}
+	tx.closed = true
+	ctx, span := tx.db.start(ctx, "commit")
+	err := tx.Tx.Commit(ctx)
+	end(span, err)
+	return err
+}
+
+func (tx *tracedTx) Rollback(ctx context.Context) error {
+	if tx.closed {
+		return tx.Tx.Rollback(ctx)
+	}
+	tx.closed = true
+	ctx, span := tx.db.start(ctx, "rollback")
+	err := tx.Tx.Rollback(ctx)
+	end(span, err)
+	return err
+}
+
+type tracedRows struct {
+	pgx.Rows
+	span  trace.Span
+	ended bool
+}
+
+func (rows *tracedRows) Close() {
+	rows.Rows.Close()
+	if !rows.ended {
+		rows.ended = true
+		end(rows.span, rows.Rows.Err())
+	}
+}
+
+type tracedRow struct {
+	row  pgx.Row
+	span trace.Span
+}
+
+func (row *tracedRow) Scan(dest ...any) error {
+	err := row.row.Scan(dest...)
+	end(row.span, err)
+	return err
+}
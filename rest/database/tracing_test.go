@@ -0,0 +1,193 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/test"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"testing"
+)
+
+func TestTracedDatabaseQuery(t *testing.T) {
+	//given
+	recorder, provider := newRecorder()
+	database := new(test.DatabaseMock)
+	rows := &rowsStub{err: errors.New("connection reset")}
+	database.On("Query", mock.Anything, "select id from public.user", mock.Anything).Return(rows, nil)
+	traced := NewTracedDatabase(database, provider)
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+
+	//when
+	result, err := traced.Query(ctx, "select id from public.user")
+
+	//then span ends only when rows are closed
+	require.NoError(t, err)
+	require.Empty(t, recorder.Ended())
+	result.Close()
+	result.Close()
+	require.True(t, rows.closed)
+
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	span := spans[0]
+	require.Equal(t, "postgres.query", span.Name())
+	require.Equal(t, trace.SpanKindClient, span.SpanKind())
+	require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	require.Contains(t, span.Attributes(), semconv.DBSystemPostgreSQL)
+	require.Contains(t, span.Attributes(), semconv.DBQueryText("select id from public.user"))
+	require.Contains(t, span.Attributes(), semconv.DBOperationName("SELECT"))
+	require.Equal(t, codes.Error, span.Status().Code)
+}
+
+func TestTracedDatabaseExec(t *testing.T) {
+	//given
+	recorder, provider := newRecorder()
+	database := new(test.DatabaseMock)
+	database.On("Exec", mock.Anything, "DELETE FROM public.user", mock.Anything).Return(pgconn.NewCommandTag("DELETE 2"), nil).Once()
+	database.On("Exec", mock.Anything, "DELETE FROM public.user", mock.Anything).Return(pgconn.CommandTag{}, errors.New("timeout")).Once()
+	traced := NewTracedDatabase(database, provider)
+
+	//when
+	_, okErr := traced.Exec(context.Background(), "DELETE FROM public.user")
+	_, failedErr := traced.Exec(context.Background(), "DELETE FROM public.user")
+
+	//then
+	require.NoError(t, okErr)
+	require.Error(t, failedErr)
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	require.Contains(t, spans[0].Attributes(), attribute.Int64("db.rows_affected", 2))
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+	require.Equal(t, codes.Error, spans[1].Status().Code)
+	require.Len(t, spans[1].Events(), 1)
+}
+
+func TestTracedDatabaseQueryRow(t *testing.T) {
+	//given
+	recorder, provider := newRecorder()
+	database := new(test.DatabaseMock)
+	database.On("QueryRow", mock.Anything, "SELECT 1", mock.Anything).Return(&rowStub{err: pgx.ErrNoRows})
+	traced := NewTracedDatabase(database, provider)
+
+	//when
+	row := traced.QueryRow(context.Background(), "SELECT 1")
+
+	//then span ends when row is scanned, no rows isn't a failure
+	require.Empty(t, recorder.Ended())
+	require.ErrorIs(t, row.Scan(), pgx.ErrNoRows)
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "postgres.query_row", spans[0].Name())
+	require.Equal(t, codes.Unset, spans[0].Status().Code)
+}
+
+func TestTracedDatabasePing(t *testing.T) {
+	//given
+	recorder, provider := newRecorder()
+	database := new(test.DatabaseMock)
+	database.On("Ping", mock.Anything).Return(nil)
+	traced := NewTracedDatabase(database, provider)
+
+	//when
+	err := traced.Ping(context.Background())
+
+	//then
+	require.NoError(t, err)
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "postgres.ping", spans[0].Name())
+	//context passed down carries the span
+	ctx := database.Calls[0].Arguments.Get(0).(context.Context)
+	require.Equal(t, spans[0].SpanContext().SpanID(), trace.SpanContextFromContext(ctx).SpanID())
+}
+
+func TestTracedDatabaseBeginTx(t *testing.T) {
+	//given
+	recorder, provider := newRecorder()
+	database := new(test.DatabaseMock)
+	opts := pgx.TxOptions{IsoLevel: pgx.Serializable}
+	database.On("BeginTx", mock.Anything, opts).Return(new(test.TxMock), nil)
+	traced := NewTracedDatabase(database, provider)
+
+	//when
+	_, err := traced.BeginTx(context.Background(), opts)
+
+	//then
+	require.NoError(t, err)
+	spans := recorder.Ended()
+	require.Len(t, spans, 1)
+	require.Equal(t, "postgres.begin", spans[0].Name())
+	require.Contains(t, spans[0].Attributes(), attribute.String("db.transaction.isolation_level", "serializable"))
+}
+
+func TestTracedDatabaseWithTx(t *testing.T) {
+	//given
+	recorder, provider := newRecorder()
+	database := new(test.DatabaseMock)
+	tx := new(test.TxMock)
+	database.On("BeginTx", mock.Anything, pgx.TxOptions{}).Return(tx, nil)
+	tx.On("Exec", mock.Anything, "UPDATE public.user SET email = $1", mock.Anything).Return(pgconn.NewCommandTag("UPDATE 1"), nil)
+	tx.On("Commit", mock.Anything).Return(nil)
+	tx.On("Rollback", mock.Anything).Return(pgx.ErrTxClosed)
+	traced := NewTracedDatabase(database, provider)
+	ctx, parent := provider.Tracer("test").Start(context.Background(), "parent")
+
+	//when
+	err := WithTx(ctx, traced, pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		_, err := Conn(ctx, traced).Exec(ctx, "UPDATE public.user SET email = $1", "email@example.com")
+		return err
+	})
+	parent.End()
+
+	//then statement and commit are children of the parent, rollback after commit isn't traced
+	require.NoError(t, err)
+	tx.AssertExpectations(t)
+	spans := recorder.Ended()
+	require.Len(t, spans, 4)
+	var names []string
+	for _, span := range spans[:3] {
+		names = append(names, span.Name())
+		require.Equal(t, parent.SpanContext().TraceID(), span.SpanContext().TraceID())
+		require.Equal(t, parent.SpanContext().SpanID(), span.Parent().SpanID())
+	}
+	require.Equal(t, []string{"postgres.begin", "postgres.exec", "postgres.commit"}, names)
+	require.Contains(t, spans[1].Attributes(), semconv.DBQueryText("UPDATE public.user SET email = $1"))
+	require.Contains(t, spans[1].Attributes(), attribute.Int64("db.rows_affected", 1))
+	require.Equal(t, "parent", spans[3].Name())
+}
+
+func newRecorder() (*tracetest.SpanRecorder, *sdktrace.TracerProvider) {
+	recorder := tracetest.NewSpanRecorder()
+	return recorder, sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+}
+
+type rowsStub struct {
+	pgx.Rows
+	err    error
+	closed bool
+}
+
+func (rows *rowsStub) Close() {
+	rows.closed = true
+}
+
+func (rows *rowsStub) Err() error {
+	return rows.err
+}
+
+type rowStub struct {
+	err error
+}
+
+func (row *rowStub) Scan(...any) error {
+	return row.err
+}
@@ -0,0 +1,89 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"math/rand/v2"
+	"time"
+)
+
+// serializationFailure SQLSTATE returned by postgres when repeatable read or serializable transaction has to be retried
+const serializationFailure = "40001"
+
+// transaction is attempted that many times in total before serialization failure is returned,
+// backoff before each retry is random up to txBackoff doubled with every attempt
+var (
+	txAttempts = 5
+	txBackoff  = 10 * time.Millisecond
+)
+
+// Querier runs statements either directly or within transaction, implemented by Database and pgx.Tx
+type Querier interface {
+	Query(context.Context, string, ...any) (pgx.Rows, error)
+	Exec(context.Context, string, ...any) (pgconn.CommandTag, error)
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+type txKey struct{}
+
+// WithTx runs fn within transaction begun with opts, transaction is committed when fn returns nil and rolled back otherwise.
+// Context passed to fn carries the transaction, Conn returns it so statements of nested calls run within it too.
+// When ctx already carries transaction fn joins it - opts are ignored and commit is left to the outermost WithTx.
+// Whole transaction is retried with backoff on serialization failure, fn may run several times so its only
+// side effects should be the statements it runs.
+func WithTx(ctx context.Context, db Database, opts pgx.TxOptions, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	if tx := TxFrom(ctx); tx != nil {
+		return fn(ctx, tx)
+	}
+	var err error
+	for attempt := 1; ; attempt++ {
+		err = runTx(ctx, db, opts, fn)
+		if !isSerializationFailure(err) || attempt == txAttempts {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return errors.Join(err, ctx.Err())
+		case <-time.After(backoff(attempt)):
+		}
+	}
+}
+
+func runTx(ctx context.Context, db Database, opts pgx.TxOptions, fn func(ctx context.Context, tx pgx.Tx) error) error {
+	tx, err := db.BeginTx(ctx, opts)
+	if err != nil {
+		return err
+	}
+	//no-op when transaction is already committed
+	defer tx.Rollback(context.Background())
+	if err := fn(context.WithValue(ctx, txKey{}, tx), tx); err != nil {
+		return err
+	}
+	return tx.Commit(ctx)
+}
+
+// TxFrom returns transaction carried by ctx, nil outside of WithTx
+func TxFrom(ctx context.Context) pgx.Tx {
+	tx, _ := ctx.Value(txKey{}).(pgx.Tx)
+	return tx
+}
+
+// Conn returns transaction carried by ctx, db when there is none
+func Conn(ctx context.Context, db Database) Querier {
+	if tx := TxFrom(ctx); tx != nil {
+		return tx
+	}
+	return db
+}
+
+func isSerializationFailure(err error) bool {
+	var pgErr *pgconn.PgError
+	return errors.As(err, &pgErr) && pgErr.Code == serializationFailure
+}
+
+// backoff is full jitter exponential backoff, transactions that conflicted once shouldn't retry in lockstep
+func backoff(attempt int) time.Duration {
+	return rand.N(txBackoff << (attempt - 1))
+}
@@ -0,0 +1,168 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/test"
+	"testing"
+	"time"
+)
+
+func TestWithTxCommits(t *testing.T) {
+	//given
+	database, tx := newTxMocks()
+	opts := pgx.TxOptions{IsoLevel: pgx.Serializable, AccessMode: pgx.ReadOnly}
+	database.On("BeginTx", mock.Anything, opts).Return(tx, nil)
+	tx.On("Commit", mock.Anything).Return(nil)
+
+	//when
+	var conn Querier
+	err := WithTx(context.Background(), database, opts, func(ctx context.Context, _ pgx.Tx) error {
+		conn = Conn(ctx, database)
+		return nil
+	})
+
+	//then
+	require.NoError(t, err)
+	require.Same(t, tx, conn)
+	tx.AssertCalled(t, "Commit", mock.Anything)
+}
+
+func TestWithTxRollsBack(t *testing.T) {
+	//given
+	database, tx := newTxMocks()
+	database.On("BeginTx", mock.Anything, pgx.TxOptions{}).Return(tx, nil)
+	fnErr := errors.New("user not found")
+
+	//when
+	err := WithTx(context.Background(), database, pgx.TxOptions{}, func(context.Context, pgx.Tx) error {
+		return fnErr
+	})
+
+	//then
+	require.ErrorIs(t, err, fnErr)
+	tx.AssertNotCalled(t, "Commit", mock.Anything)
+	tx.AssertCalled(t, "Rollback", mock.Anything)
+}
+
+func TestWithTxRetriesSerializationFailure(t *testing.T) {
+	//given
+	setBackoff(t, time.Millisecond)
+	database, tx := newTxMocks()
+	database.On("BeginTx", mock.Anything, mock.Anything).Return(tx, nil)
+	tx.On("Commit", mock.Anything).Return(&pgconn.PgError{Code: serializationFailure}).Once()
+	tx.On("Commit", mock.Anything).Return(nil).Once()
+	runs := 0
+
+	//when
+	err := WithTx(context.Background(), database, pgx.TxOptions{IsoLevel: pgx.Serializable}, func(context.Context, pgx.Tx) error {
+		runs++
+		return nil
+	})
+
+	//then
+	require.NoError(t, err)
+	require.Equal(t, 2, runs)
+	database.AssertNumberOfCalls(t, "BeginTx", 2)
+}
+
+func TestWithTxGivesUpRetrying(t *testing.T) {
+	//given
+	setBackoff(t, time.Millisecond)
+	database, tx := newTxMocks()
+	database.On("BeginTx", mock.Anything, mock.Anything).Return(tx, nil)
+	conflict := &pgconn.PgError{Code: serializationFailure}
+
+	//when
+	err := WithTx(context.Background(), database, pgx.TxOptions{}, func(context.Context, pgx.Tx) error {
+		return conflict
+	})
+
+	//then
+	require.ErrorIs(t, err, conflict)
+	database.AssertNumberOfCalls(t, "BeginTx", txAttempts)
+}
+
+func TestWithTxDoesNotRetryOtherErrors(t *testing.T) {
+	//given
+	database, tx := newTxMocks()
+	database.On("BeginTx", mock.Anything, mock.Anything).Return(tx, nil)
+	uniqueViolation := &pgconn.PgError{Code: "23505"}
+
+	//when
+	err := WithTx(context.Background(), database, pgx.TxOptions{}, func(context.Context, pgx.Tx) error {
+		return uniqueViolation
+	})
+
+	//then
+	require.ErrorIs(t, err, uniqueViolation)
+	database.AssertNumberOfCalls(t, "BeginTx", 1)
+}
+
+func TestWithTxStopsRetryingWhenCancelled(t *testing.T) {
+	//given
+	setBackoff(t, time.Hour)
+	database, tx := newTxMocks()
+	database.On("BeginTx", mock.Anything, mock.Anything).Return(tx, nil)
+	ctx, cancel := context.WithCancel(context.Background())
+
+	//when
+	err := WithTx(ctx, database, pgx.TxOptions{}, func(context.Context, pgx.Tx) error {
+		cancel()
+		return &pgconn.PgError{Code: serializationFailure}
+	})
+
+	//then
+	require.ErrorIs(t, err, context.Canceled)
+	database.AssertNumberOfCalls(t, "BeginTx", 1)
+}
+
+func TestWithTxJoinsOuterTransaction(t *testing.T) {
+	//given
+	database, tx := newTxMocks()
+	database.On("BeginTx", mock.Anything, mock.Anything).Return(tx, nil)
+	tx.On("Commit", mock.Anything).Return(nil)
+
+	//when
+	err := WithTx(context.Background(), database, pgx.TxOptions{}, func(ctx context.Context, outer pgx.Tx) error {
+		return WithTx(ctx, database, pgx.TxOptions{IsoLevel: pgx.Serializable}, func(ctx context.Context, inner pgx.Tx) error {
+			require.Same(t, outer, inner)
+			return nil
+		})
+	})
+
+	//then
+	require.NoError(t, err)
+	database.AssertNumberOfCalls(t, "BeginTx", 1)
+	tx.AssertNumberOfCalls(t, "Commit", 1)
+}
+
+func TestConnOutsideTransaction(t *testing.T) {
+	//given
+	database := new(test.DatabaseMock)
+
+	//when
+	conn := Conn(context.Background(), database)
+
+	//then
+	require.Same(t, database, conn)
+	require.Nil(t, TxFrom(context.Background()))
+}
+
+func newTxMocks() (*test.DatabaseMock, *test.TxMock) {
+	tx := new(test.TxMock)
+	tx.On("Rollback", mock.Anything).Return(nil)
+	return new(test.DatabaseMock), tx
+}
+
+func setBackoff(t *testing.T, backoff time.Duration) {
+	previous := txBackoff
+	txBackoff = backoff
+	t.Cleanup(func() {
+		txBackoff = previous
+	})
+}
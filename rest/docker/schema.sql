@@ -1,5 +0,0 @@
-CREATE TABLE "user"
-(
-    id    uuid PRIMARY KEY,
-    email VARCHAR(255) NOT NULL
-);
\ No newline at end of file
@@ -0,0 +1,114 @@
+package health
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"go-examples/rest/config"
+	"go-examples/rest/model"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrShuttingDown reported by readiness once server started shutting down
+var ErrShuttingDown = errors.New("shutting down")
+
+// ShutdownCheck name of the check failing readiness during graceful shutdown
+const ShutdownCheck = "shutdown"
+
+const (
+	defaultCacheTTL = time.Second
+	defaultTimeout  = time.Second
+)
+
+// Check reports dependency as down by returning error
+type Check func(ctx context.Context) error
+
+// Registry runs checks registered by components when readiness is probed. Result of each check is reused for cache ttl
+// and concurrent probes wait for the one check in flight, so probes can't reach dependencies more often than that.
+type Registry struct {
+	ttl      time.Duration
+	timeout  time.Duration
+	mu       sync.RWMutex
+	checks   []*check
+	draining atomic.Bool
+}
+
+type check struct {
+	name   string
+	fn     Check
+	mu     sync.Mutex
+	result model.CheckResult
+}
+
+func NewRegistry(config *config.HealthConfig) *Registry {
+	return &Registry{ttl: cmp.Or(config.CacheTTL, defaultCacheTTL), timeout: cmp.Or(config.Timeout, defaultTimeout)}
+}
+
+// Register adds check reported under name, checks are reported in order they were registered
+func (registry *Registry) Register(name string, fn Check) {
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+	registry.checks = append(registry.checks, &check{name: name, fn: fn})
+}
+
+// Drain fails readiness from now on without running checks, it's called before server stops accepting connections
+func (registry *Registry) Drain() {
+	registry.draining.Store(true)
+}
+
+// Ready runs all checks at once, it's up only when all of them passed
+func (registry *Registry) Ready(ctx context.Context) model.Probe {
+	if registry.draining.Load() {
+		return model.Probe{Status: model.HealthDown, Checks: []model.CheckResult{
+			{Name: ShutdownCheck, Status: model.HealthDown, CheckedAt: time.Now(), Err: ErrShuttingDown},
+		}}
+	}
+	registry.mu.RLock()
+	checks := registry.checks
+	registry.mu.RUnlock()
+
+	probe := model.Probe{Status: model.HealthUp, Checks: make([]model.CheckResult, len(checks))}
+	var wg sync.WaitGroup
+	for i, check := range checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			probe.Checks[i] = registry.run(ctx, check)
+		}()
+	}
+	wg.Wait()
+	for _, result := range probe.Checks {
+		if result.Err != nil {
+			probe.Status = model.HealthDown
+		}
+	}
+	return probe
+}
+
+// run returns cached result while it's fresh. Result is shared by probes so check isn't cancelled with the probe
+// that happened to run it, it's limited by timeout only.
+func (registry *Registry) run(ctx context.Context, check *check) model.CheckResult {
+	check.mu.Lock()
+	defer check.mu.Unlock()
+	if !check.result.CheckedAt.IsZero() && time.Since(check.result.CheckedAt) < registry.ttl {
+		return check.result
+	}
+	checkCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), registry.timeout)
+	defer cancel()
+	start := time.Now()
+	err := check.fn(checkCtx)
+	status := model.HealthUp
+	if err != nil {
+		status = model.HealthDown
+	}
+	check.result = model.CheckResult{
+		Name:      check.name,
+		Status:    status,
+		LatencyMs: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: start,
+		Err:       err,
+	}
+	return check.result
+}
@@ -0,0 +1,116 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/config"
+	"go-examples/rest/model"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestReadyRunsAllChecks(t *testing.T) {
+	//given
+	registry := NewRegistry(&config.HealthConfig{})
+	pingErr := errors.New("connection refused")
+	registry.Register("database", func(context.Context) error { return nil })
+	registry.Register("cache", func(context.Context) error { return pingErr })
+
+	//when
+	probe := registry.Ready(context.Background())
+
+	//then
+	require.Equal(t, model.HealthDown, probe.Status)
+	require.Len(t, probe.Checks, 2)
+	require.Equal(t, "database", probe.Checks[0].Name)
+	require.Equal(t, model.HealthUp, probe.Checks[0].Status)
+	require.NoError(t, probe.Checks[0].Err)
+	require.Equal(t, "cache", probe.Checks[1].Name)
+	require.Equal(t, model.HealthDown, probe.Checks[1].Status)
+	require.Equal(t, pingErr, probe.Checks[1].Err)
+	require.False(t, probe.Checks[1].CheckedAt.IsZero())
+}
+
+func TestReadyCachesResults(t *testing.T) {
+	//given
+	registry := NewRegistry(&config.HealthConfig{CacheTTL: time.Hour})
+	var runs atomic.Int32
+	registry.Register("database", func(context.Context) error {
+		runs.Add(1)
+		time.Sleep(10 * time.Millisecond)
+		return nil
+	})
+
+	//when probes come at once
+	var wg sync.WaitGroup
+	for range 10 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			require.Equal(t, model.HealthUp, registry.Ready(context.Background()).Status)
+		}()
+	}
+	wg.Wait()
+
+	//then
+	require.Equal(t, int32(1), runs.Load())
+}
+
+func TestReadyRerunsStaleChecks(t *testing.T) {
+	//given
+	registry := NewRegistry(&config.HealthConfig{CacheTTL: time.Millisecond})
+	var runs atomic.Int32
+	registry.Register("database", func(context.Context) error {
+		runs.Add(1)
+		return nil
+	})
+
+	//when
+	registry.Ready(context.Background())
+	time.Sleep(5 * time.Millisecond)
+	registry.Ready(context.Background())
+
+	//then
+	require.Equal(t, int32(2), runs.Load())
+}
+
+func TestReadyLimitsCheck(t *testing.T) {
+	//given check blocking until cancelled and probe whose request is cancelled already
+	registry := NewRegistry(&config.HealthConfig{Timeout: 10 * time.Millisecond})
+	registry.Register("database", func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	})
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	//when
+	probe := registry.Ready(ctx)
+
+	//then check ran until timeout rather than probe cancellation
+	require.Equal(t, model.HealthDown, probe.Status)
+	require.ErrorIs(t, probe.Checks[0].Err, context.DeadlineExceeded)
+	require.GreaterOrEqual(t, probe.Checks[0].LatencyMs, 10.0)
+}
+
+func TestReadyFailsWhenDraining(t *testing.T) {
+	//given
+	registry := NewRegistry(&config.HealthConfig{})
+	registry.Register("database", func(context.Context) error {
+		t.Fatal("check run while draining")
+		return nil
+	})
+
+	//when
+	registry.Drain()
+	probe := registry.Ready(context.Background())
+
+	//then
+	require.Equal(t, model.HealthDown, probe.Status)
+	require.Len(t, probe.Checks, 1)
+	require.Equal(t, ShutdownCheck, probe.Checks[0].Name)
+	require.ErrorIs(t, probe.Checks[0].Err, ErrShuttingDown)
+}
@@ -1,39 +1,208 @@
 package middleware
 
 import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"errors"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"go-examples/rest/api"
+	"go-examples/rest/config"
+	"go-examples/rest/model"
+	"go-examples/rest/repository"
 	"net/http"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
 )
 
 var apiKeyHeader = "X-API-KEY"
 
+// authentication schemes, also used as model.Principal method
+const (
+	schemeAPIKey = "api_key"
+	schemeBearer = "bearer"
+	//certificate verified during TLS handshake, see config.TLSConfig
+	schemeClientCert = "client_cert"
+)
+
+type APIKeyStore interface {
+	FindByKey(ctx context.Context, key string) (*model.APIKey, error)
+}
+
 type Authentication interface {
 	RequireAPIToken() gin.HandlerFunc
+	RequireBearerToken() gin.HandlerFunc
+	RequireClientCert() gin.HandlerFunc
+	// RequireGroup accepts any of the schemes configured for route group
+	RequireGroup(group string) gin.HandlerFunc
+	// Reload replaces static keys and scopes of client certificates, other settings take effect after restart
+	Reload(config *config.AuthConfig)
 }
 
 type authentication struct {
-	allowlist map[string]bool
+	store      APIKeyStore
+	cache      *keyCache
+	staticKeys atomic.Pointer[map[[sha256.Size]byte]*model.APIKey]
+	//scopes of client certificates by subject
+	certScopes atomic.Pointer[map[string][]string]
+	jwt        *jwtVerifier
+	groups     map[string][]string
+	now        func() time.Time
+}
+
+// NewAuthentication api keys are looked up in static keys from config first, then in the store through the cache.
+// Fails when JWKS file can't be loaded or route group uses unknown scheme.
+func NewAuthentication(store APIKeyStore, config *config.AuthConfig) (Authentication, error) {
+	for group, schemes := range config.Groups {
+		for _, scheme := range schemes {
+			if scheme != schemeAPIKey && scheme != schemeBearer && scheme != schemeClientCert {
+				return nil, fmt.Errorf("unknown authentication scheme %s for group %s", scheme, group)
+			}
+		}
+	}
+	verifier, err := newJWTVerifier(&config.JWT)
+	if err != nil {
+		return nil, err
+	}
+	auth := &authentication{
+		store:  store,
+		cache:  newKeyCache(config.CacheTTL),
+		jwt:    verifier,
+		groups: config.Groups,
+		now:    time.Now,
+	}
+	auth.Reload(config)
+	return auth, nil
+}
+
+func (auth *authentication) Reload(config *config.AuthConfig) {
+	staticKeys := make(map[[sha256.Size]byte]*model.APIKey, len(config.StaticKeys))
+	for _, key := range config.StaticKeys {
+		staticKeys[sha256.Sum256([]byte(key.Key))] = &model.APIKey{ID: "static:" + key.Owner, Owner: key.Owner, Scopes: key.Scopes}
+	}
+	auth.staticKeys.Store(&staticKeys)
+	certScopes := make(map[string][]string, len(config.ClientCerts))
+	for _, cert := range config.ClientCerts {
+		certScopes[cert.Subject] = cert.Scopes
+	}
+	auth.certScopes.Store(&certScopes)
 }
 
-//Naive implementation just to show example of auth middleware
+func (auth *authentication) RequireGroup(group string) gin.HandlerFunc {
+	schemes, ok := auth.groups[group]
+	if !ok {
+		schemes = []string{schemeAPIKey}
+	}
+	apiKey, bearer, clientCert := auth.RequireAPIToken(), auth.RequireBearerToken(), auth.RequireClientCert()
+	return func(context *gin.Context) {
+		//first scheme whose credentials are present is used, certificate comes with connection so explicit credentials win
+		if slices.Contains(schemes, schemeBearer) && context.GetHeader("Authorization") != "" {
+			bearer(context)
+			return
+		}
+		if slices.Contains(schemes, schemeAPIKey) && context.GetHeader(apiKeyHeader) != "" {
+			apiKey(context)
+			return
+		}
+		if slices.Contains(schemes, schemeClientCert) && verifiedClientCert(context.Request) != nil {
+			clientCert(context)
+			return
+		}
+		api.Abort(context, http.StatusUnauthorized, model.ErrorCodeMissingCredentials, "missing credentials")
+	}
+}
 
-func NewAuthentication() Authentication {
-	return &authentication{allowlist: map[string]bool{
-		"token": true,
-	}}
+// RequireBearerToken validates JWT from Authorization header, on success puts model.Principal built from claims into the context
+func (auth *authentication) RequireBearerToken() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		scheme, token, _ := strings.Cut(context.GetHeader("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || token == "" {
+			context.Header("WWW-Authenticate", "Bearer")
+			api.Abort(context, http.StatusUnauthorized, model.ErrorCodeMissingCredentials, "missing bearer token")
+			return
+		}
+		if auth.jwt == nil {
+			api.Abort(context, http.StatusUnauthorized, model.ErrorCodeInvalidCredentials, "bearer tokens not supported")
+			return
+		}
+		principal, err := auth.jwt.verify(token)
+		if err != nil {
+			context.Header("WWW-Authenticate", `Bearer error="invalid_token"`)
+			api.AbortWithContextError(context, http.StatusUnauthorized, model.ErrorCodeInvalidCredentials, "invalid bearer token", err)
+			return
+		}
+		context.Set(model.PrincipalKey, principal)
+	}
 }
 
+// RequireAPIToken on success puts model.Principal into the context under model.PrincipalKey
 func (auth *authentication) RequireAPIToken() gin.HandlerFunc {
 	return func(context *gin.Context) {
 		apiKey := context.GetHeader(apiKeyHeader)
 		if apiKey == "" {
-			api.Abort(context, http.StatusUnauthorized, "missing api key")
+			api.Abort(context, http.StatusUnauthorized, model.ErrorCodeMissingCredentials, "missing api key")
+			return
+		}
+		key, err := auth.lookup(context, apiKey)
+		if err != nil {
+			api.AbortWithContextError(context, http.StatusInternalServerError, model.ErrorCodeInternal, "error authenticating", err)
+			return
+		}
+		if key == nil || !key.Active(auth.now()) {
+			api.Abort(context, http.StatusUnauthorized, model.ErrorCodeInvalidCredentials, "invalid api key")
 			return
 		}
-		if !auth.allowlist[apiKey] {
-			api.Abort(context, http.StatusUnauthorized, "invalid api key")
+		context.Set(model.PrincipalKey, &model.Principal{
+			ID:     key.ID,
+			Owner:  key.Owner,
+			Scopes: key.Scopes,
+			Method: schemeAPIKey,
+		})
+	}
+}
+
+// RequireClientCert accepts certificate verified during TLS handshake, its subject becomes the principal.
+// Certificate whose subject isn't configured is authenticated but granted no scopes.
+func (auth *authentication) RequireClientCert() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		cert := verifiedClientCert(context.Request)
+		if cert == nil {
+			api.Abort(context, http.StatusUnauthorized, model.ErrorCodeMissingCredentials, "missing client certificate")
 			return
 		}
+		subject := cert.Subject.String()
+		context.Set(model.PrincipalKey, &model.Principal{
+			ID:     "cert:" + subject,
+			Owner:  cert.Subject.CommonName,
+			Scopes: (*auth.certScopes.Load())[subject],
+			Method: schemeClientCert,
+		})
+	}
+}
+
+// verifiedClientCert returns leaf of the first chain client certificate was verified with, nil when there is none
+func verifiedClientCert(request *http.Request) *x509.Certificate {
+	if request.TLS == nil || len(request.TLS.VerifiedChains) == 0 {
+		return nil
+	}
+	return request.TLS.VerifiedChains[0][0]
+}
+
+// lookup returns nil key when it doesn't exist, missing keys are cached as well
+func (auth *authentication) lookup(ctx context.Context, apiKey string) (*model.APIKey, error) {
+	if key, ok := (*auth.staticKeys.Load())[sha256.Sum256([]byte(apiKey))]; ok {
+		return key, nil
+	}
+	if key, ok := auth.cache.get(apiKey); ok {
+		return key, nil
+	}
+	key, err := auth.store.FindByKey(ctx, apiKey)
+	if err != nil && !errors.Is(err, repository.ErrAPIKeyNotFound) {
+		return nil, err
 	}
+	auth.cache.put(apiKey, key)
+	return key, nil
 }
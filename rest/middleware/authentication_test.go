@@ -1,16 +1,33 @@
 package middleware
 
 import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
 	"github.com/gin-gonic/gin"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
+	"go-examples/rest/config"
+	"go-examples/rest/model"
+	"go-examples/rest/repository"
+	"go-examples/rest/test"
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
 )
 
+var authConfig = config.AuthConfig{
+	CacheTTL: time.Minute,
+	StaticKeys: []config.StaticKeyConfig{
+		{Key: "token", Owner: "local", Scopes: []string{"users:read"}},
+	},
+}
+
 type AuthenticationSuite struct {
 	suite.Suite
+	storeMock      *test.APIKeyRepositoryMock
 	authentication Authentication
 	ctx            *gin.Context
 	recorder       *httptest.ResponseRecorder
@@ -24,7 +41,8 @@ func (s *AuthenticationSuite) BeforeTest(suiteName, testName string) {
 	gin.SetMode(gin.TestMode)
 	s.recorder = httptest.NewRecorder()
 	s.ctx, _ = gin.CreateTestContext(s.recorder)
-	s.authentication = NewAuthentication()
+	s.storeMock = new(test.APIKeyRepositoryMock)
+	s.authentication, _ = NewAuthentication(s.storeMock, &authConfig)
 }
 
 func (s *AuthenticationSuite) TestAuthenticationSuccessful() {
@@ -38,6 +56,116 @@ func (s *AuthenticationSuite) TestAuthenticationSuccessful() {
 
 	//
 	require.Equal(s.T(), http.StatusOK, s.recorder.Code)
+	require.Equal(s.T(), &model.Principal{ID: "static:local", Owner: "local", Scopes: []string{"users:read"}, Method: schemeAPIKey}, model.PrincipalFrom(s.ctx))
+	s.storeMock.AssertNotCalled(s.T(), "FindByKey")
+}
+
+func (s *AuthenticationSuite) TestAuthenticationReloadedStaticKeys() {
+	//given key is rotated
+	s.storeMock.On("FindByKey", "token").Return((*model.APIKey)(nil), repository.ErrAPIKeyNotFound)
+	s.authentication.Reload(&config.AuthConfig{StaticKeys: []config.StaticKeyConfig{{Key: "rotated", Owner: "local"}}})
+	rq := httptest.NewRequest("GET", "/", nil)
+	rq.Header.Add(apiKeyHeader, "rotated")
+	s.ctx.Request = rq
+
+	//when
+	s.authentication.RequireAPIToken()(s.ctx)
+	oldRecorder := httptest.NewRecorder()
+	oldCtx, _ := gin.CreateTestContext(oldRecorder)
+	oldCtx.Request = httptest.NewRequest("GET", "/", nil)
+	oldCtx.Request.Header.Add(apiKeyHeader, "token")
+	s.authentication.RequireAPIToken()(oldCtx)
+
+	//then new key is accepted, old one isn't
+	require.Equal(s.T(), http.StatusOK, s.recorder.Code)
+	require.Equal(s.T(), "static:local", model.PrincipalFrom(s.ctx).ID)
+	require.Equal(s.T(), http.StatusUnauthorized, oldRecorder.Code)
+}
+
+func (s *AuthenticationSuite) TestAuthenticationClientCert() {
+	//given certificate verified during handshake
+	ca, err := test.NewCA("test CA")
+	require.NoError(s.T(), err)
+	cert, err := ca.Issue(pkix.Name{CommonName: "reporting", Organization: []string{"Example"}})
+	require.NoError(s.T(), err)
+	auth, err := NewAuthentication(s.storeMock, &config.AuthConfig{
+		Groups:      map[string][]string{"users": {schemeAPIKey, schemeClientCert}},
+		ClientCerts: []config.ClientCertConfig{{Subject: "CN=reporting,O=Example", Scopes: []string{"users:read"}}},
+	})
+	require.NoError(s.T(), err)
+	s.ctx.Request = httptest.NewRequest("GET", "/", nil)
+	s.ctx.Request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert.Cert, ca.Cert}}}
+
+	//when
+	auth.RequireGroup("users")(s.ctx)
+
+	//then subject becomes the principal
+	require.Equal(s.T(), http.StatusOK, s.recorder.Code)
+	require.Equal(s.T(), &model.Principal{ID: "cert:CN=reporting,O=Example", Owner: "reporting", Scopes: []string{"users:read"}, Method: schemeClientCert}, model.PrincipalFrom(s.ctx))
+}
+
+func (s *AuthenticationSuite) TestAuthenticationClientCertUnknownSubject() {
+	//given
+	ca, err := test.NewCA("test CA")
+	require.NoError(s.T(), err)
+	cert, err := ca.Issue(pkix.Name{CommonName: "unknown"})
+	require.NoError(s.T(), err)
+	s.ctx.Request = httptest.NewRequest("GET", "/", nil)
+	s.ctx.Request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert.Cert, ca.Cert}}}
+
+	//when
+	s.authentication.RequireClientCert()(s.ctx)
+
+	//then authenticated without scopes
+	require.Equal(s.T(), http.StatusOK, s.recorder.Code)
+	require.Empty(s.T(), model.PrincipalFrom(s.ctx).Scopes)
+}
+
+func (s *AuthenticationSuite) TestAuthenticationClientCertNotVerified() {
+	//given connection with certificate that wasn't verified
+	s.ctx.Request = httptest.NewRequest("GET", "/", nil)
+	s.ctx.Request.TLS = &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{}}}
+
+	//when
+	s.authentication.RequireClientCert()(s.ctx)
+
+	//then
+	require.Equal(s.T(), http.StatusUnauthorized, s.recorder.Code)
+	require.Contains(s.T(), s.recorder.Body.String(), "missing client certificate")
+}
+
+func (s *AuthenticationSuite) TestAuthenticationSuccessfulStoredKey() {
+	//given
+	rq := httptest.NewRequest("GET", "/", nil)
+	rq.Header.Add(apiKeyHeader, "stored")
+	s.ctx.Request = rq
+	expiresAt := time.Now().Add(time.Hour)
+	s.storeMock.On("FindByKey", "stored").Return(&model.APIKey{ID: "id", Owner: "owner", Scopes: []string{"users:write"}, ExpiresAt: &expiresAt}, nil)
+
+	//when
+	s.authentication.RequireAPIToken()(s.ctx)
+
+	//
+	require.Equal(s.T(), http.StatusOK, s.recorder.Code)
+	require.Equal(s.T(), &model.Principal{ID: "id", Owner: "owner", Scopes: []string{"users:write"}, Method: schemeAPIKey}, model.PrincipalFrom(s.ctx))
+}
+
+func (s *AuthenticationSuite) TestAuthenticationCachesLookups() {
+	//given
+	s.storeMock.On("FindByKey", "stored").Return(&model.APIKey{ID: "id", Owner: "owner"}, nil)
+	s.storeMock.On("FindByKey", "unknown").Return((*model.APIKey)(nil), repository.ErrAPIKeyNotFound)
+
+	for _, key := range []string{"stored", "stored", "unknown", "unknown"} {
+		//when
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		ctx.Request = httptest.NewRequest("GET", "/", nil)
+		ctx.Request.Header.Add(apiKeyHeader, key)
+		s.authentication.RequireAPIToken()(ctx)
+	}
+
+	//then
+	s.storeMock.AssertNumberOfCalls(s.T(), "FindByKey", 2)
 }
 
 func (s *AuthenticationSuite) TestAuthenticationMissingToken() {
@@ -54,15 +182,66 @@ func (s *AuthenticationSuite) TestAuthenticationMissingToken() {
 }
 
 func (s *AuthenticationSuite) TestAuthenticationInvalidToken() {
+	past := time.Now().Add(-time.Minute)
+	tests := []struct {
+		name string
+		key  *model.APIKey
+		err  error
+	}{
+		{"unknown", nil, repository.ErrAPIKeyNotFound},
+		{"expired", &model.APIKey{ID: "id", ExpiresAt: &past}, nil},
+		{"revoked", &model.APIKey{ID: "id", RevokedAt: &past}, nil},
+	}
+	for _, test := range tests {
+		//given
+		s.BeforeTest("", "")
+		rq := httptest.NewRequest("GET", "/", nil)
+		rq.Header.Add(apiKeyHeader, "invalid")
+		s.ctx.Request = rq
+		s.storeMock.On("FindByKey", "invalid").Return(test.key, test.err)
+
+		//when
+		s.authentication.RequireAPIToken()(s.ctx)
+
+		//
+		require.Equal(s.T(), http.StatusUnauthorized, s.recorder.Code, test.name)
+		require.Contains(s.T(), s.recorder.Body.String(), "invalid api key")
+		require.Nil(s.T(), model.PrincipalFrom(s.ctx))
+	}
+}
+
+func (s *AuthenticationSuite) TestAuthenticationStoreError() {
 	//given
 	rq := httptest.NewRequest("GET", "/", nil)
-	rq.Header.Add(apiKeyHeader, "invalid")
+	rq.Header.Add(apiKeyHeader, "stored")
 	s.ctx.Request = rq
+	s.storeMock.On("FindByKey", "stored").Return((*model.APIKey)(nil), fmt.Errorf("db error"))
 
 	//when
 	s.authentication.RequireAPIToken()(s.ctx)
 
 	//
-	require.Equal(s.T(), http.StatusUnauthorized, s.recorder.Code)
-	require.Contains(s.T(), s.recorder.Body.String(), "invalid api key")
+	require.Equal(s.T(), http.StatusInternalServerError, s.recorder.Code)
+}
+
+func TestKeyCacheExpiresEntries(t *testing.T) {
+	//given
+	now := time.Now()
+	cache := newKeyCache(time.Second)
+	cache.now = func() time.Time { return now }
+	cache.put("key", &model.APIKey{ID: "id"})
+
+	//when
+	cached, ok := cache.get("key")
+
+	//then
+	require.True(t, ok)
+	require.Equal(t, "id", cached.ID)
+
+	//and when ttl passes
+	now = now.Add(2 * time.Second)
+	_, ok = cache.get("key")
+
+	//then
+	require.False(t, ok)
 }
@@ -0,0 +1,18 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go-examples/rest/api"
+	"go-examples/rest/model"
+	"net/http"
+)
+
+// RequireScope must run after authentication, aborts with 403 unless principal holds the scope
+func RequireScope(scope string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		if !model.PrincipalFrom(context).HasScope(scope) {
+			api.Abort(context, http.StatusForbidden, model.ErrorCodeInsufficientScope, fmt.Sprintf("missing scope %s", scope))
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/model"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRequireScope(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name           string
+		principal      *model.Principal
+		expectedStatus int
+	}{
+		{"granted", &model.Principal{Scopes: []string{model.ScopeUsersRead, model.ScopeUsersDelete}}, http.StatusOK},
+		{"missing scope", &model.Principal{Scopes: []string{model.ScopeUsersRead}}, http.StatusForbidden},
+		{"no scopes", &model.Principal{}, http.StatusForbidden},
+		{"no principal", nil, http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		ctx.Request = httptest.NewRequest("DELETE", "/", nil)
+		if test.principal != nil {
+			ctx.Set(model.PrincipalKey, test.principal)
+		}
+
+		//when
+		RequireScope(model.ScopeUsersDelete)(ctx)
+
+		//then
+		require.Equal(t, test.expectedStatus, recorder.Code, test.name)
+		require.Equal(t, test.expectedStatus != http.StatusOK, ctx.IsAborted(), test.name)
+	}
+}
@@ -0,0 +1,37 @@
+package middleware
+
+import (
+	"cmp"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"go-examples/rest/api"
+	"go-examples/rest/config"
+	"go-examples/rest/model"
+	"net/http"
+	"strings"
+)
+
+const defaultMaxBodyBytes = 1 << 20
+
+// BodyLimit rejects body declared larger than limit of the route with 413 right away, body without declared length
+// is cut at the limit and handler reading it responds 413
+func BodyLimit(config *config.ServerConfig) gin.HandlerFunc {
+	//config keys are lowercased when read
+	limits := make(map[string]int64, len(config.RouteMaxBodyBytes))
+	for route, limit := range config.RouteMaxBodyBytes {
+		limits[strings.ToLower(route)] = limit
+	}
+	defaultLimit := cmp.Or(config.MaxBodyBytes, defaultMaxBodyBytes)
+	return func(context *gin.Context) {
+		limit, ok := limits[strings.ToLower(context.Request.Method+" "+context.FullPath())]
+		if !ok {
+			limit = defaultLimit
+		}
+		if context.Request.ContentLength > limit {
+			api.Abort(context, http.StatusRequestEntityTooLarge, model.ErrorCodeRequestTooLarge, fmt.Sprintf("request body exceeds %d bytes", limit))
+			return
+		}
+		context.Set(model.BodyLimitKey, limit)
+		context.Request.Body = http.MaxBytesReader(context.Writer, context.Request.Body, limit)
+	}
+}
@@ -0,0 +1,77 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/api"
+	"go-examples/rest/config"
+	"go-examples/rest/model"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestBodyLimitRejectsDeclaredLength(t *testing.T) {
+	//given
+	router := bodyLimitRouter(&config.ServerConfig{MaxBodyBytes: 4})
+	request := httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("12345"))
+
+	//when
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	//then handler isn't reached
+	require.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	require.Contains(t, recorder.Body.String(), model.ErrorCodeRequestTooLarge)
+	require.NotContains(t, recorder.Body.String(), "read")
+}
+
+func TestBodyLimitCutsStreamedBody(t *testing.T) {
+	//given
+	router := bodyLimitRouter(&config.ServerConfig{MaxBodyBytes: 4})
+	request := httptest.NewRequest(http.MethodPost, "/users", io.MultiReader(strings.NewReader("12345")))
+	request.ContentLength = -1
+
+	//when
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	//then
+	require.Equal(t, http.StatusRequestEntityTooLarge, recorder.Code)
+	require.Contains(t, recorder.Body.String(), "request body exceeds 4 bytes")
+}
+
+func TestBodyLimitOfRoute(t *testing.T) {
+	//given viper lowercases keys
+	router := bodyLimitRouter(&config.ServerConfig{MaxBodyBytes: 4, RouteMaxBodyBytes: map[string]int64{"post /users/import": 8}})
+
+	//when
+	imported := httptest.NewRecorder()
+	router.ServeHTTP(imported, httptest.NewRequest(http.MethodPost, "/users/import", strings.NewReader("12345")))
+	created := httptest.NewRecorder()
+	router.ServeHTTP(created, httptest.NewRequest(http.MethodPost, "/users", strings.NewReader("12345")))
+
+	//then
+	require.Equal(t, http.StatusOK, imported.Code)
+	require.Equal(t, "read 5", imported.Body.String())
+	require.Equal(t, http.StatusRequestEntityTooLarge, created.Code)
+}
+
+// bodyLimitRouter handlers respond with number of bytes read
+func bodyLimitRouter(serverConfig *config.ServerConfig) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(BodyLimit(serverConfig))
+	read := func(context *gin.Context) {
+		body, err := io.ReadAll(context.Request.Body)
+		if api.AbortWithBodyError(context, err) {
+			return
+		}
+		context.String(http.StatusOK, "read %d", len(body))
+	}
+	router.POST("/users", read)
+	router.POST("/users/import", read)
+	return router
+}
@@ -0,0 +1,62 @@
+package middleware
+
+import (
+	"crypto/sha256"
+	"go-examples/rest/model"
+	"sync"
+	"time"
+)
+
+// upper bound for cached keys, invalid keys are cached too so it protects against flooding with random keys
+const maxCachedKeys = 10_000
+
+type cacheEntry struct {
+	key     *model.APIKey //nil when key doesn't exist
+	expires time.Time
+}
+
+// keyCache caches api key lookups for short ttl, entries are indexed by key hash so no plaintext is kept in memory
+type keyCache struct {
+	mutex   sync.Mutex
+	ttl     time.Duration
+	entries map[[sha256.Size]byte]cacheEntry
+	now     func() time.Time
+}
+
+func newKeyCache(ttl time.Duration) *keyCache {
+	return &keyCache{
+		ttl:     ttl,
+		entries: make(map[[sha256.Size]byte]cacheEntry),
+		now:     time.Now,
+	}
+}
+
+func (cache *keyCache) get(apiKey string) (*model.APIKey, bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	entry, ok := cache.entries[sha256.Sum256([]byte(apiKey))]
+	if !ok || cache.now().After(entry.expires) {
+		return nil, false
+	}
+	return entry.key, true
+}
+
+func (cache *keyCache) put(apiKey string, key *model.APIKey) {
+	if cache.ttl <= 0 {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	now := cache.now()
+	if len(cache.entries) >= maxCachedKeys {
+		for hash, entry := range cache.entries {
+			if now.After(entry.expires) {
+				delete(cache.entries, hash)
+			}
+		}
+		if len(cache.entries) >= maxCachedKeys {
+			clear(cache.entries)
+		}
+	}
+	cache.entries[sha256.Sum256([]byte(apiKey))] = cacheEntry{key: key, expires: now.Add(cache.ttl)}
+}
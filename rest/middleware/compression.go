@@ -0,0 +1,179 @@
+package middleware
+
+import (
+	"cmp"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"go-examples/rest/config"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const defaultMinCompressSize = 1024
+
+// compressor is implemented by writers of all supported encodings, they're pooled and reset for each response
+type compressor interface {
+	io.WriteCloser
+	Flush() error
+	Reset(io.Writer)
+}
+
+var compressors = map[string]*sync.Pool{
+	"br": {New: func() any {
+		return brotli.NewWriterLevel(nil, brotli.DefaultCompression)
+	}},
+	"zstd": {New: func() any {
+		//single goroutine per response, streamed export would otherwise spawn one per cpu
+		encoder, _ := zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+		return encoder
+	}},
+	"gzip": {New: func() any {
+		return gzip.NewWriter(nil)
+	}},
+}
+
+// Compression compresses responses with the preferred encoding client accepts. Response is buffered until it reaches
+// min size, smaller ones and those encoded by handler already are sent as they are.
+func Compression(config *config.CompressionConfig) gin.HandlerFunc {
+	minSize := cmp.Or(config.MinSize, defaultMinCompressSize)
+	return func(context *gin.Context) {
+		if len(config.Encodings) == 0 || context.Request.Method == http.MethodHead {
+			context.Next()
+			return
+		}
+		context.Writer.Header().Add("Vary", "Accept-Encoding")
+		encoding := negotiateEncoding(context.GetHeader("Accept-Encoding"), config.Encodings)
+		if encoding == "" {
+			context.Next()
+			return
+		}
+		writer := &compressWriter{ResponseWriter: context.Writer, encoding: encoding, minSize: minSize}
+		context.Writer = writer
+		defer writer.close()
+		context.Next()
+	}
+}
+
+// negotiateEncoding picks encoding with the highest quality, ties are resolved by order of supported ones.
+// Empty result means response is sent uncompressed.
+func negotiateEncoding(header string, supported []string) string {
+	best, bestQuality := "", 0.0
+	for _, encoding := range supported {
+		quality := encodingQuality(header, encoding)
+		if quality > bestQuality {
+			best, bestQuality = encoding, quality
+		}
+	}
+	return best
+}
+
+// encodingQuality of encoding in Accept-Encoding header, wildcard applies when encoding isn't listed explicitly
+func encodingQuality(header string, encoding string) float64 {
+	wildcard := 0.0
+	for _, item := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(item), ";")
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		switch name = strings.ToLower(strings.TrimSpace(name)); name {
+		case encoding:
+			return q
+		case "*":
+			wildcard = q
+		}
+	}
+	return wildcard
+}
+
+// compressWriter buffers response until it's known to be large enough, then writes it through compressor
+type compressWriter struct {
+	gin.ResponseWriter
+	encoding string
+	minSize  int
+	buffer   []byte
+	//nil until compression started
+	compressor compressor
+	//set when response is sent as it is
+	passthrough bool
+}
+
+func (writer *compressWriter) Write(data []byte) (int, error) {
+	switch {
+	case writer.passthrough:
+		return writer.ResponseWriter.Write(data)
+	case writer.compressor != nil:
+		return writer.compressor.Write(data)
+	}
+	//response encoded by handler, e.g. metrics, isn't touched
+	if writer.Header().Get("Content-Encoding") != "" {
+		writer.passthrough = true
+		return writer.ResponseWriter.Write(data)
+	}
+	writer.buffer = append(writer.buffer, data...)
+	if len(writer.buffer) >= writer.minSize {
+		if err := writer.start(); err != nil {
+			return 0, err
+		}
+	}
+	return len(data), nil
+}
+
+func (writer *compressWriter) WriteString(s string) (int, error) {
+	return writer.Write([]byte(s))
+}
+
+// Written reports buffered response as written, so handlers don't append error response to it
+func (writer *compressWriter) Written() bool {
+	return len(writer.buffer) > 0 || writer.ResponseWriter.Written()
+}
+
+// Flush sends what was written so far, streamed response is compressed even when it's small yet
+func (writer *compressWriter) Flush() {
+	if writer.compressor == nil && !writer.passthrough && len(writer.buffer) > 0 {
+		if err := writer.start(); err != nil {
+			return
+		}
+	}
+	if writer.compressor != nil {
+		if err := writer.compressor.Flush(); err != nil {
+			return
+		}
+	}
+	writer.ResponseWriter.Flush()
+}
+
+func (writer *compressWriter) start() error {
+	header := writer.Header()
+	header.Set("Content-Encoding", writer.encoding)
+	header.Del("Content-Length")
+	//ETag is kept, it's version of the user rather than hash of bytes so it holds for every encoding
+	writer.compressor = compressors[writer.encoding].Get().(compressor)
+	writer.compressor.Reset(writer.ResponseWriter)
+	buffered := writer.buffer
+	writer.buffer = nil
+	_, err := writer.compressor.Write(buffered)
+	return err
+}
+
+// close finishes compressed stream or sends response smaller than min size uncompressed
+func (writer *compressWriter) close() {
+	if writer.compressor != nil {
+		_ = writer.compressor.Close()
+		writer.compressor.Reset(nil)
+		compressors[writer.encoding].Put(writer.compressor)
+		return
+	}
+	if len(writer.buffer) > 0 {
+		_, _ = writer.ResponseWriter.Write(writer.buffer)
+	}
+}
@@ -0,0 +1,209 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/api"
+	"go-examples/rest/config"
+	"go-examples/rest/model"
+	"go-examples/rest/test"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+var compressionConfig = &config.CompressionConfig{Encodings: []string{"br", "zstd", "gzip"}, MinSize: 100}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate, br", "br"},
+		{"gzip;q=1.0, br;q=0.5", "gzip"},
+		{"zstd, br;q=0", "zstd"},
+		{"*", "br"},
+		{"*;q=0.1, gzip;q=0.5", "gzip"},
+		{"identity, deflate", ""},
+		{"GZIP", "gzip"},
+	}
+	for _, tt := range tests {
+		//when
+		encoding := negotiateEncoding(tt.header, compressionConfig.Encodings)
+
+		//then
+		require.Equal(t, tt.expected, encoding, tt.header)
+	}
+}
+
+func TestCompression(t *testing.T) {
+	body := strings.Repeat(`{"id":"1","email":"a@example.com"}`, 10)
+	for _, encoding := range compressionConfig.Encodings {
+		//given
+		router := compressionRouter(body)
+		request := httptest.NewRequest(http.MethodGet, "/users", nil)
+		request.Header.Set("Accept-Encoding", encoding)
+
+		//when
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+
+		//then
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Equal(t, encoding, recorder.Header().Get("Content-Encoding"))
+		require.Equal(t, "Accept-Encoding", recorder.Header().Get("Vary"))
+		require.Equal(t, `"1"`, recorder.Header().Get("ETag"))
+		require.Equal(t, "application/json; charset=utf-8", recorder.Header().Get("Content-Type"))
+		require.Equal(t, body, decompress(t, encoding, recorder.Body))
+	}
+}
+
+func TestCompressedETagMatchesOnUpdate(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	repositoryMock := new(test.UserRepositoryMock)
+	user := &model.User{ID: "5b1a0d5e-3c4f-4a8e-9a55-2f0f3f1c6d11", Email: "email@example.com", Version: 3}
+	repositoryMock.On("GetUserById", user.ID).Return(user, nil)
+	repositoryMock.On("Update", user.ID, &model.PostUser{Email: "other@example.com"}, 3).Return(user, nil)
+	userAPI := api.NewUserAPI(repositoryMock, &config.APIConfig{})
+	router := gin.New()
+	router.Use(Compression(&config.CompressionConfig{Encodings: []string{"gzip"}, MinSize: 1}))
+	router.GET("/users/:id", userAPI.GetUserById)
+	router.PUT("/users/:id", userAPI.UpdateUser)
+	get := httptest.NewRequest(http.MethodGet, "/users/"+user.ID, nil)
+	get.Header.Set("Accept-Encoding", "gzip")
+	fetched := httptest.NewRecorder()
+	router.ServeHTTP(fetched, get)
+	require.Equal(t, "gzip", fetched.Header().Get("Content-Encoding"))
+
+	//when ETag of compressed response is sent back
+	put := httptest.NewRequest(http.MethodPut, "/users/"+user.ID, strings.NewReader(`{"email": "other@example.com"}`))
+	put.Header.Set("Content-Type", "application/json")
+	put.Header.Set("Accept-Encoding", "gzip")
+	put.Header.Set("If-Match", fetched.Header().Get("ETag"))
+	updated := httptest.NewRecorder()
+	router.ServeHTTP(updated, put)
+
+	//then
+	require.Equal(t, http.StatusOK, updated.Code)
+	repositoryMock.AssertExpectations(t)
+}
+
+func TestCompressionSkipsSmallResponse(t *testing.T) {
+	//given
+	router := compressionRouter(`{"id":"1"}`)
+	request := httptest.NewRequest(http.MethodGet, "/users", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	//when
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	//then
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.Empty(t, recorder.Header().Get("Content-Encoding"))
+	require.Equal(t, `"1"`, recorder.Header().Get("ETag"))
+	require.Equal(t, `{"id":"1"}`, recorder.Body.String())
+}
+
+func TestCompressionKeepsResponseEncodedByHandler(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Compression(compressionConfig))
+	router.GET("/metrics", func(context *gin.Context) {
+		context.Header("Content-Encoding", "gzip")
+		context.Data(http.StatusOK, "text/plain", bytes.Repeat([]byte("x"), 200))
+	})
+	request := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	request.Header.Set("Accept-Encoding", "br, gzip")
+
+	//when
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	//then
+	require.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	require.Equal(t, 200, recorder.Body.Len())
+}
+
+func TestCompressionFlushesStreamedResponse(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Compression(compressionConfig))
+	recorder := httptest.NewRecorder()
+	var flushed int
+	router.GET("/users/export", func(context *gin.Context) {
+		_, _ = context.Writer.WriteString("{\"id\":\"1\"}\n")
+		context.Writer.Flush()
+		flushed = recorder.Body.Len()
+		_, _ = context.Writer.WriteString("{\"id\":\"2\"}\n")
+	})
+	request := httptest.NewRequest(http.MethodGet, "/users/export", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	//when
+	router.ServeHTTP(recorder, request)
+
+	//then small streamed response is compressed and sent once flushed
+	require.Positive(t, flushed)
+	require.Equal(t, "gzip", recorder.Header().Get("Content-Encoding"))
+	require.Equal(t, "{\"id\":\"1\"}\n{\"id\":\"2\"}\n", decompress(t, "gzip", recorder.Body))
+}
+
+func TestCompressionDisabled(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Compression(&config.CompressionConfig{}))
+	router.GET("/users", func(context *gin.Context) {
+		context.String(http.StatusOK, strings.Repeat("x", 2000))
+	})
+	request := httptest.NewRequest(http.MethodGet, "/users", nil)
+	request.Header.Set("Accept-Encoding", "gzip")
+
+	//when
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, request)
+
+	//then
+	require.Empty(t, recorder.Header().Get("Content-Encoding"))
+	require.Equal(t, 2000, recorder.Body.Len())
+}
+
+func compressionRouter(body string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Compression(compressionConfig))
+	router.GET("/users", func(context *gin.Context) {
+		context.Header("ETag", `"1"`)
+		context.Data(http.StatusOK, "application/json; charset=utf-8", []byte(body))
+	})
+	return router
+}
+
+func decompress(t *testing.T, encoding string, body io.Reader) string {
+	var reader io.Reader
+	var err error
+	switch encoding {
+	case "gzip":
+		reader, err = gzip.NewReader(body)
+	case "zstd":
+		reader, err = zstd.NewReader(body)
+	case "br":
+		reader = brotli.NewReader(body)
+	}
+	require.NoError(t, err)
+	decompressed, err := io.ReadAll(reader)
+	require.NoError(t, err)
+	return string(decompressed)
+}
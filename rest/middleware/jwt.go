@@ -0,0 +1,193 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/golang-jwt/jwt/v5"
+	"go-examples/rest/config"
+	"go-examples/rest/model"
+	"math/big"
+	"os"
+	"strings"
+)
+
+var errUnknownKey = errors.New("no key matching token")
+
+// tokenClaims scopes are accepted either as space separated "scope" (RFC 8693) or "scopes" array
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	Scope  string   `json:"scope"`
+	Scopes []string `json:"scopes"`
+}
+
+func (claims *tokenClaims) principal() *model.Principal {
+	scopes := claims.Scopes
+	if claims.Scope != "" {
+		scopes = append(scopes, strings.Fields(claims.Scope)...)
+	}
+	return &model.Principal{
+		ID:     claims.Subject,
+		Owner:  claims.Subject,
+		Scopes: scopes,
+		Method: schemeBearer,
+	}
+}
+
+// jwtVerifier validates HS256 tokens with shared secret and RS256/ES256 tokens with keys from local JWKS file
+type jwtVerifier struct {
+	parser   *jwt.Parser
+	hsSecret []byte
+	keys     map[string][]jwk //by alg
+}
+
+type jwk struct {
+	id  string
+	key any
+}
+
+// newJWTVerifier returns nil verifier when neither secret nor JWKS file is configured
+func newJWTVerifier(config *config.JWTConfig) (*jwtVerifier, error) {
+	if config.HS256Secret == "" && config.JWKSFile == "" {
+		return nil, nil
+	}
+	verifier := &jwtVerifier{keys: make(map[string][]jwk)}
+	if config.HS256Secret != "" {
+		verifier.hsSecret = []byte(config.HS256Secret)
+	}
+	if config.JWKSFile != "" {
+		if err := verifier.loadJWKS(config.JWKSFile); err != nil {
+			return nil, fmt.Errorf("error loading jwks %s: %w", config.JWKSFile, err)
+		}
+	}
+	options := []jwt.ParserOption{
+		jwt.WithValidMethods(verifier.methods()),
+		jwt.WithLeeway(config.ClockSkew),
+		jwt.WithExpirationRequired(),
+	}
+	if config.Issuer != "" {
+		options = append(options, jwt.WithIssuer(config.Issuer))
+	}
+	if config.Audience != "" {
+		options = append(options, jwt.WithAudience(config.Audience))
+	}
+	verifier.parser = jwt.NewParser(options...)
+	return verifier, nil
+}
+
+// verify checks signature, exp, nbf, iss and aud, returns principal built from claims
+func (verifier *jwtVerifier) verify(token string) (*model.Principal, error) {
+	claims := new(tokenClaims)
+	if _, err := verifier.parser.ParseWithClaims(token, claims, verifier.key); err != nil {
+		return nil, err
+	}
+	if claims.Subject == "" {
+		return nil, errors.New("token has no subject")
+	}
+	return claims.principal(), nil
+}
+
+// key picks verification key by alg and kid, configured HS256 secret is used when no JWKS key matches
+func (verifier *jwtVerifier) key(token *jwt.Token) (any, error) {
+	alg := token.Method.Alg()
+	kid, _ := token.Header["kid"].(string)
+	candidates := verifier.keys[alg]
+	for _, key := range candidates {
+		//token without kid can be verified only when there's single candidate key
+		if key.id == kid || (kid == "" && len(candidates) == 1) {
+			return key.key, nil
+		}
+	}
+	if alg == jwt.SigningMethodHS256.Alg() && verifier.hsSecret != nil {
+		return verifier.hsSecret, nil
+	}
+	return nil, errUnknownKey
+}
+
+func (verifier *jwtVerifier) methods() []string {
+	var methods []string
+	for alg := range verifier.keys {
+		methods = append(methods, alg)
+	}
+	if _, ok := verifier.keys[jwt.SigningMethodHS256.Alg()]; !ok && verifier.hsSecret != nil {
+		methods = append(methods, jwt.SigningMethodHS256.Alg())
+	}
+	return methods
+}
+
+// loadJWKS supports RSA, EC P-256 and symmetric (oct) keys, other keys in the set are skipped
+func (verifier *jwtVerifier) loadJWKS(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	var set struct {
+		Keys []struct {
+			Kty string `json:"kty"`
+			Kid string `json:"kid"`
+			Use string `json:"use"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+			K   string `json:"k"`
+		} `json:"keys"`
+	}
+	if err := json.Unmarshal(content, &set); err != nil {
+		return err
+	}
+	for _, key := range set.Keys {
+		if key.Use != "" && key.Use != "sig" {
+			continue
+		}
+		switch {
+		case key.Kty == "RSA":
+			n, err := decodeBigInt(key.N)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", key.Kid, err)
+			}
+			e, err := decodeBigInt(key.E)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", key.Kid, err)
+			}
+			verifier.add(jwt.SigningMethodRS256.Alg(), key.Kid, &rsa.PublicKey{N: n, E: int(e.Int64())})
+		case key.Kty == "EC" && key.Crv == "P-256":
+			x, err := decodeBigInt(key.X)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", key.Kid, err)
+			}
+			y, err := decodeBigInt(key.Y)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", key.Kid, err)
+			}
+			if !elliptic.P256().IsOnCurve(x, y) {
+				return fmt.Errorf("key %s: point not on curve", key.Kid)
+			}
+			verifier.add(jwt.SigningMethodES256.Alg(), key.Kid, &ecdsa.PublicKey{Curve: elliptic.P256(), X: x, Y: y})
+		case key.Kty == "oct":
+			secret, err := base64.RawURLEncoding.DecodeString(key.K)
+			if err != nil {
+				return fmt.Errorf("key %s: %w", key.Kid, err)
+			}
+			verifier.add(jwt.SigningMethodHS256.Alg(), key.Kid, secret)
+		}
+	}
+	return nil
+}
+
+func (verifier *jwtVerifier) add(alg string, id string, key any) {
+	verifier.keys[alg] = append(verifier.keys[alg], jwk{id: id, key: key})
+}
+
+func decodeBigInt(encoded string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
@@ -0,0 +1,256 @@
+package middleware
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/config"
+	"go-examples/rest/model"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var hsSecret = "secret"
+
+func TestJWTVerifierValidTokens(t *testing.T) {
+	//given
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	verifier := newTestVerifier(t, rsaKey, ecKey)
+
+	tests := []struct {
+		name   string
+		method jwt.SigningMethod
+		kid    string
+		key    any
+	}{
+		{"HS256", jwt.SigningMethodHS256, "", []byte(hsSecret)},
+		{"RS256", jwt.SigningMethodRS256, "rsa", rsaKey},
+		{"ES256", jwt.SigningMethodES256, "ec", ecKey},
+		{"RS256 without kid", jwt.SigningMethodRS256, "", rsaKey},
+	}
+	for _, test := range tests {
+		//when
+		principal, err := verifier.verify(sign(t, test.method, test.kid, test.key, validClaims()))
+
+		//then
+		require.NoError(t, err, test.name)
+		require.Equal(t, &model.Principal{ID: "user", Owner: "user", Scopes: []string{"users:read", "users:write"}, Method: schemeBearer}, principal)
+	}
+}
+
+func TestJWTVerifierInvalidTokens(t *testing.T) {
+	//given
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	otherKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	verifier := newTestVerifier(t, rsaKey, ecKey)
+
+	withClaims := func(modify func(claims *tokenClaims)) *tokenClaims {
+		claims := validClaims()
+		modify(claims)
+		return claims
+	}
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"expired", sign(t, jwt.SigningMethodHS256, "", []byte(hsSecret), withClaims(func(c *tokenClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		}))},
+		{"missing exp", sign(t, jwt.SigningMethodHS256, "", []byte(hsSecret), withClaims(func(c *tokenClaims) {
+			c.ExpiresAt = nil
+		}))},
+		{"not yet valid", sign(t, jwt.SigningMethodHS256, "", []byte(hsSecret), withClaims(func(c *tokenClaims) {
+			c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute))
+		}))},
+		{"wrong issuer", sign(t, jwt.SigningMethodHS256, "", []byte(hsSecret), withClaims(func(c *tokenClaims) {
+			c.Issuer = "other"
+		}))},
+		{"wrong audience", sign(t, jwt.SigningMethodHS256, "", []byte(hsSecret), withClaims(func(c *tokenClaims) {
+			c.Audience = jwt.ClaimStrings{"other"}
+		}))},
+		{"missing subject", sign(t, jwt.SigningMethodHS256, "", []byte(hsSecret), withClaims(func(c *tokenClaims) {
+			c.Subject = ""
+		}))},
+		{"wrong secret", sign(t, jwt.SigningMethodHS256, "", []byte("other"), validClaims())},
+		{"wrong key", sign(t, jwt.SigningMethodRS256, "rsa", otherKey, validClaims())},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, "other", rsaKey, validClaims())},
+		{"unsupported alg", sign(t, jwt.SigningMethodHS512, "", []byte(hsSecret), validClaims())},
+		{"none alg", sign(t, jwt.SigningMethodNone, "", jwt.UnsafeAllowNoneSignatureType, validClaims())},
+		{"malformed", "not.a.token"},
+	}
+	for _, test := range tests {
+		//when
+		_, err := verifier.verify(test.token)
+
+		//then
+		require.Error(t, err, test.name)
+	}
+}
+
+func TestJWTVerifierClockSkew(t *testing.T) {
+	//given
+	verifier, err := newJWTVerifier(&config.JWTConfig{HS256Secret: hsSecret, ClockSkew: time.Minute})
+	require.NoError(t, err)
+	claims := validClaims()
+	claims.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-30 * time.Second))
+	claims.NotBefore = jwt.NewNumericDate(time.Now().Add(30 * time.Second))
+
+	//when
+	_, err = verifier.verify(sign(t, jwt.SigningMethodHS256, "", []byte(hsSecret), claims))
+
+	//then
+	require.NoError(t, err)
+}
+
+func TestJWTVerifierNotConfigured(t *testing.T) {
+	//when
+	verifier, err := newJWTVerifier(&config.JWTConfig{})
+
+	//then
+	require.NoError(t, err)
+	require.Nil(t, verifier)
+}
+
+func TestRequireBearerToken(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	auth, err := NewAuthentication(nil, &config.AuthConfig{JWT: config.JWTConfig{HS256Secret: hsSecret, Issuer: "issuer", Audience: "rest"}})
+	require.NoError(t, err)
+	token := sign(t, jwt.SigningMethodHS256, "", []byte(hsSecret), validClaims())
+
+	tests := []struct {
+		header         string
+		expectedStatus int
+	}{
+		{"Bearer " + token, http.StatusOK},
+		{"bearer " + token, http.StatusOK},
+		{"", http.StatusUnauthorized},
+		{"Basic abc", http.StatusUnauthorized},
+		{"Bearer invalid", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		ctx.Request = httptest.NewRequest("GET", "/", nil)
+		ctx.Request.Header.Set("Authorization", test.header)
+
+		//when
+		auth.RequireBearerToken()(ctx)
+
+		//then
+		require.Equal(t, test.expectedStatus, recorder.Code, test.header)
+		if test.expectedStatus == http.StatusOK {
+			require.Equal(t, "user", model.PrincipalFrom(ctx).Owner)
+		} else {
+			require.NotEmpty(t, recorder.Header().Get("WWW-Authenticate"))
+		}
+	}
+}
+
+func TestRequireGroup(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	auth, err := NewAuthentication(nil, &config.AuthConfig{
+		StaticKeys: []config.StaticKeyConfig{{Key: "token", Owner: "local"}},
+		JWT:        config.JWTConfig{HS256Secret: hsSecret, Issuer: "issuer", Audience: "rest"},
+		Groups:     map[string][]string{"both": {"api_key", "bearer"}, "bearer": {"bearer"}},
+	})
+	require.NoError(t, err)
+	token := sign(t, jwt.SigningMethodHS256, "", []byte(hsSecret), validClaims())
+
+	tests := []struct {
+		group          string
+		header         string
+		value          string
+		expectedStatus int
+		expectedMethod string
+	}{
+		{"both", "Authorization", "Bearer " + token, http.StatusOK, schemeBearer},
+		{"both", apiKeyHeader, "token", http.StatusOK, schemeAPIKey},
+		{"both", "Other", "", http.StatusUnauthorized, ""},
+		{"bearer", apiKeyHeader, "token", http.StatusUnauthorized, ""},
+		{"bearer", "Authorization", "Bearer " + token, http.StatusOK, schemeBearer},
+		//group missing in config accepts api keys only
+		{"unknown", "Authorization", "Bearer " + token, http.StatusUnauthorized, ""},
+		{"unknown", apiKeyHeader, "token", http.StatusOK, schemeAPIKey},
+	}
+	for _, test := range tests {
+		recorder := httptest.NewRecorder()
+		ctx, _ := gin.CreateTestContext(recorder)
+		ctx.Request = httptest.NewRequest("GET", "/", nil)
+		ctx.Request.Header.Set(test.header, test.value)
+
+		//when
+		auth.RequireGroup(test.group)(ctx)
+
+		//then
+		require.Equal(t, test.expectedStatus, recorder.Code, "%s %s", test.group, test.header)
+		if test.expectedStatus == http.StatusOK {
+			require.Equal(t, test.expectedMethod, model.PrincipalFrom(ctx).Method)
+		}
+	}
+}
+
+func TestNewAuthenticationUnknownScheme(t *testing.T) {
+	//when
+	_, err := NewAuthentication(nil, &config.AuthConfig{Groups: map[string][]string{"users": {"basic"}}})
+
+	//then
+	require.Error(t, err)
+}
+
+func validClaims() *tokenClaims {
+	return &tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			Subject:   "user",
+			Issuer:    "issuer",
+			Audience:  jwt.ClaimStrings{"rest"},
+			ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+			NotBefore: jwt.NewNumericDate(time.Now().Add(-time.Minute)),
+		},
+		Scopes: []string{"users:read"},
+		Scope:  "users:write",
+	}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims *tokenClaims) string {
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	require.NoError(t, err)
+	return signed
+}
+
+// newTestVerifier writes public keys into JWKS file, HS256 secret is taken from config
+func newTestVerifier(t *testing.T, rsaKey *rsa.PrivateKey, ecKey *ecdsa.PrivateKey) *jwtVerifier {
+	encode := func(b []byte) string {
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	jwks := map[string]any{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa", "use": "sig", "n": encode(rsaKey.N.Bytes()), "e": encode(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec", "crv": "P-256", "x": encode(ecKey.X.FillBytes(make([]byte, 32))), "y": encode(ecKey.Y.FillBytes(make([]byte, 32)))},
+		{"kty": "RSA", "kid": "enc", "use": "enc", "n": encode(rsaKey.N.Bytes()), "e": "AQAB"},
+	}}
+	content, err := json.Marshal(jwks)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	require.NoError(t, os.WriteFile(path, content, 0600))
+	verifier, err := newJWTVerifier(&config.JWTConfig{HS256Secret: hsSecret, JWKSFile: path, Issuer: "issuer", Audience: "rest"})
+	require.NoError(t, err)
+	return verifier
+}
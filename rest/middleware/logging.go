@@ -0,0 +1,81 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"go-examples/rest/model"
+	"go.opentelemetry.io/otel/trace"
+	"log/slog"
+	"net/http"
+	"time"
+)
+
+const (
+	requestIDHeader = "X-Request-ID"
+	//longer or non printable ids from clients are replaced so they can't flood or forge log lines
+	maxRequestIDLength = 128
+)
+
+// RequestLogger propagates X-Request-ID (generated when missing), puts request scoped logger into context
+// and logs single line per request once it's served. Runs after Tracing to log trace id.
+func RequestLogger(logger *slog.Logger) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		start := time.Now()
+		requestID := context.GetHeader(requestIDHeader)
+		if !validRequestID(requestID) {
+			requestID = uuid.NewString()
+		}
+		requestLogger := logger.With(slog.String("request_id", requestID))
+		if span := trace.SpanContextFromContext(context.Request.Context()); span.IsValid() {
+			requestLogger = requestLogger.With(slog.String("trace_id", span.TraceID().String()))
+		}
+		context.Set(model.RequestIDKey, requestID)
+		context.Set(model.LoggerKey, requestLogger)
+		context.Header(requestIDHeader, requestID)
+
+		context.Next()
+
+		status := context.Writer.Status()
+		attrs := []slog.Attr{
+			slog.String("method", context.Request.Method),
+			slog.String("route", context.FullPath()),
+			slog.String("path", context.Request.URL.Path),
+			slog.Int("status", status),
+			slog.Duration("latency", time.Since(start)),
+			slog.String("client_ip", context.ClientIP()),
+		}
+		if principal := model.PrincipalFrom(context); principal != nil {
+			attrs = append(attrs, slog.Group("principal",
+				slog.String("id", principal.ID),
+				slog.String("method", principal.Method),
+			))
+		}
+		if len(context.Errors) > 0 {
+			attrs = append(attrs, slog.Any("errors", context.Errors.Errors()))
+		}
+		requestLogger.LogAttrs(context, level(status), "request", attrs...)
+	}
+}
+
+func level(status int) slog.Level {
+	switch {
+	case status >= http.StatusInternalServerError:
+		return slog.LevelError
+	case status >= http.StatusBadRequest:
+		return slog.LevelWarn
+	default:
+		return slog.LevelInfo
+	}
+}
+
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
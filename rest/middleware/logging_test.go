@@ -0,0 +1,124 @@
+package middleware
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/model"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestLogger(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	router := gin.New()
+	router.Use(RequestLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+	router.GET("/users/:id", func(context *gin.Context) {
+		context.Set(model.PrincipalKey, &model.Principal{ID: "key", Method: schemeAPIKey})
+		model.LoggerFrom(context).Info("handler")
+		context.Status(http.StatusOK)
+	})
+
+	//when
+	rq := httptest.NewRequest("GET", "/users/abc", nil)
+	rq.Header.Set(requestIDHeader, "client-id")
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, rq)
+
+	//then
+	require.Equal(t, "client-id", recorder.Header().Get(requestIDHeader))
+	lines := decodeLogs(t, &logs)
+	require.Len(t, lines, 2)
+	require.Equal(t, "handler", lines[0]["msg"])
+	require.Equal(t, "client-id", lines[0]["request_id"])
+
+	line := lines[1]
+	require.Equal(t, "INFO", line["level"])
+	require.Equal(t, "request", line["msg"])
+	require.Equal(t, "client-id", line["request_id"])
+	require.Equal(t, "GET", line["method"])
+	require.Equal(t, "/users/:id", line["route"])
+	require.Equal(t, "/users/abc", line["path"])
+	require.Equal(t, float64(200), line["status"])
+	require.Contains(t, line, "latency")
+	require.Equal(t, map[string]any{"id": "key", "method": schemeAPIKey}, line["principal"])
+	require.NotContains(t, line, "errors")
+}
+
+func TestRequestLoggerErrors(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	var logs bytes.Buffer
+	router := gin.New()
+	router.Use(RequestLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+	router.GET("/users", func(context *gin.Context) {
+		_ = context.Error(errors.New("error getting users: connection refused"))
+		context.Status(http.StatusInternalServerError)
+	})
+
+	//when
+	recorder := httptest.NewRecorder()
+	router.ServeHTTP(recorder, httptest.NewRequest("GET", "/users", nil))
+
+	//then
+	lines := decodeLogs(t, &logs)
+	require.Len(t, lines, 1)
+	require.Equal(t, "ERROR", lines[0]["level"])
+	require.Equal(t, []any{"error getting users: connection refused"}, lines[0]["errors"])
+	require.NotContains(t, lines[0], "principal")
+}
+
+func TestRequestLoggerGeneratesRequestID(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	tests := []struct {
+		name      string
+		requestID string
+	}{
+		{"missing", ""},
+		{"too long", strings.Repeat("a", maxRequestIDLength+1)},
+		{"non printable", "id\nforged"},
+	}
+	for _, test := range tests {
+		var logs bytes.Buffer
+		router := gin.New()
+		router.Use(RequestLogger(slog.New(slog.NewJSONHandler(&logs, nil))))
+		router.GET("/", func(context *gin.Context) {})
+		rq := httptest.NewRequest("GET", "/", nil)
+		rq.Header.Set(requestIDHeader, test.requestID)
+
+		//when
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, rq)
+
+		//then
+		requestID := recorder.Header().Get(requestIDHeader)
+		require.NoError(t, uuid.Validate(requestID), test.name)
+		require.Equal(t, requestID, decodeLogs(t, &logs)[0]["request_id"], test.name)
+	}
+}
+
+func TestLevel(t *testing.T) {
+	require.Equal(t, slog.LevelInfo, level(http.StatusNotModified))
+	require.Equal(t, slog.LevelWarn, level(http.StatusNotFound))
+	require.Equal(t, slog.LevelError, level(http.StatusServiceUnavailable))
+}
+
+func decodeLogs(t *testing.T, logs *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+	decoder := json.NewDecoder(logs)
+	for decoder.More() {
+		line := make(map[string]any)
+		require.NoError(t, decoder.Decode(&line))
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.opentelemetry.io/otel/trace"
 	"strconv"
 	"time"
 )
@@ -19,6 +20,17 @@ var requestCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
 	Help:      "Counts the number of requests served by http server",
 }, []string{"method", "path", "status"})
 
+/*
+Counter of requests rejected by rate limiter per route group
+Example metric exposed:
+rest_app_throttled_request_count{group="users"} 1
+*/
+var throttledCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "rest_app",
+	Name:      "throttled_request_count",
+	Help:      "Counts the number of requests rejected by rate limiter",
+}, []string{"group"})
+
 /*
 Histogram with labels here - used to measure the duration of request and put it into buckets
 Example of histogram metrics exposed:
@@ -39,6 +51,7 @@ var requestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 func RegisterMetrics() {
 	prometheus.MustRegister(requestCounter)
 	prometheus.MustRegister(requestDuration)
+	prometheus.MustRegister(throttledCounter)
 }
 
 func Metrics() gin.HandlerFunc {
@@ -53,14 +66,24 @@ func Metrics() gin.HandlerFunc {
 			"status": strconv.Itoa(ctx.Writer.Status()),
 		}).Inc()
 
-		requestDuration.With(prometheus.Labels{
+		observer := requestDuration.With(prometheus.Labels{
 			"path": ctx.FullPath(),
-		}).Observe(duration)
+		})
+		//sampled trace id is attached as exemplar, it links latency buckets to traces
+		if span := trace.SpanContextFromContext(ctx.Request.Context()); span.IsSampled() {
+			observer.(prometheus.ExemplarObserver).ObserveWithExemplar(duration, prometheus.Labels{"trace_id": span.TraceID().String()})
+		} else {
+			observer.Observe(duration)
+		}
 	}
 }
 
 func MetricsHandler() gin.HandlerFunc {
+	//exemplars are exposed only in OpenMetrics format
+	handler := promhttp.InstrumentMetricHandler(prometheus.DefaultRegisterer,
+		promhttp.HandlerFor(prometheus.DefaultGatherer, promhttp.HandlerOpts{EnableOpenMetrics: true}),
+	)
 	return func(ctx *gin.Context) {
-		promhttp.Handler().ServeHTTP(ctx.Writer, ctx.Request)
+		handler.ServeHTTP(ctx.Writer, ctx.Request)
 	}
 }
@@ -0,0 +1,72 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-examples/rest/api"
+	"go-examples/rest/config"
+	"go-examples/rest/model"
+	"math"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+type RateLimiter interface {
+	// Limit throttles requests of route group per client, client is the authenticated principal or ip when there is none,
+	// e.g. when limit runs before authentication
+	Limit(group string) gin.HandlerFunc
+	// Reload applies limits to subsequent requests, buckets of clients are kept
+	Reload(config *config.RateLimitConfig)
+}
+
+type rateLimiter struct {
+	store  RateLimitStore
+	groups atomic.Pointer[map[string]config.RateLimit]
+}
+
+func NewRateLimiter(store RateLimitStore, config *config.RateLimitConfig) RateLimiter {
+	limiter := &rateLimiter{store: store}
+	limiter.Reload(config)
+	return limiter
+}
+
+func (limiter *rateLimiter) Reload(config *config.RateLimitConfig) {
+	limiter.groups.Store(&config.Groups)
+}
+
+func (limiter *rateLimiter) Limit(group string) gin.HandlerFunc {
+	return func(context *gin.Context) {
+		//limit is looked up per request since it may be reloaded
+		limit, ok := (*limiter.groups.Load())[group]
+		if !ok || limit.RequestsPerSecond <= 0 || limit.Burst <= 0 {
+			return
+		}
+		decision, err := limiter.store.Take(context, group+":"+client(context), limit)
+		if err != nil {
+			//fail open, unavailable store shouldn't take the api down
+			_ = context.Error(err)
+			return
+		}
+		context.Header("RateLimit-Limit", strconv.Itoa(limit.Burst))
+		context.Header("RateLimit-Remaining", strconv.Itoa(decision.Remaining))
+		context.Header("RateLimit-Reset", ceilSeconds(decision.Reset))
+		if !decision.Allowed {
+			throttledCounter.WithLabelValues(group).Inc()
+			context.Header("Retry-After", ceilSeconds(decision.RetryAfter))
+			api.Abort(context, http.StatusTooManyRequests, model.ErrorCodeRateLimited, "rate limit exceeded")
+		}
+	}
+}
+
+func client(context *gin.Context) string {
+	if principal := model.PrincipalFrom(context); principal != nil {
+		return "principal:" + principal.ID
+	}
+	return "ip:" + context.ClientIP()
+}
+
+// ceilSeconds headers carry whole seconds, rounding down would invite retry before token is available
+func ceilSeconds(duration time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(duration.Seconds())))
+}
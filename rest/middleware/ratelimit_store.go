@@ -0,0 +1,91 @@
+package middleware
+
+import (
+	"context"
+	"go-examples/rest/config"
+	"math"
+	"sync"
+	"time"
+)
+
+// upper bound for buckets kept in memory, full buckets are dropped first since they are equal to a new one
+const maxBuckets = 100_000
+
+// RateLimitStore keeps token buckets, in-process store can be replaced with one shared by all instances
+type RateLimitStore interface {
+	// Take consumes single token from the bucket under key
+	Take(ctx context.Context, key string, limit config.RateLimit) (*RateLimitDecision, error)
+}
+
+type RateLimitDecision struct {
+	Allowed   bool
+	Remaining int
+	//time until next token is available, zero when request was allowed
+	RetryAfter time.Duration
+	//time until bucket is full again
+	Reset time.Duration
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+type memoryRateLimitStore struct {
+	mutex   sync.Mutex
+	buckets map[string]*bucket
+	now     func() time.Time
+}
+
+func NewMemoryRateLimitStore() RateLimitStore {
+	return &memoryRateLimitStore{
+		buckets: make(map[string]*bucket),
+		now:     time.Now,
+	}
+}
+
+func (store *memoryRateLimitStore) Take(_ context.Context, key string, limit config.RateLimit) (*RateLimitDecision, error) {
+	store.mutex.Lock()
+	defer store.mutex.Unlock()
+	now := store.now()
+	burst := float64(limit.Burst)
+
+	b, ok := store.buckets[key]
+	if !ok {
+		store.evict(now, limit)
+		b = &bucket{tokens: burst, last: now}
+		store.buckets[key] = b
+	}
+	b.tokens = math.Min(burst, b.tokens+now.Sub(b.last).Seconds()*limit.RequestsPerSecond)
+	b.last = now
+
+	decision := &RateLimitDecision{}
+	if b.tokens >= 1 {
+		b.tokens--
+		decision.Allowed = true
+	} else {
+		decision.RetryAfter = seconds((1 - b.tokens) / limit.RequestsPerSecond)
+	}
+	decision.Remaining = int(b.tokens)
+	decision.Reset = seconds((burst - b.tokens) / limit.RequestsPerSecond)
+	return decision, nil
+}
+
+// evict is called with mutex held, buckets refilled by now are removed, everything when that's not enough
+func (store *memoryRateLimitStore) evict(now time.Time, limit config.RateLimit) {
+	if len(store.buckets) < maxBuckets {
+		return
+	}
+	for key, b := range store.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*limit.RequestsPerSecond >= float64(limit.Burst) {
+			delete(store.buckets, key)
+		}
+	}
+	if len(store.buckets) >= maxBuckets {
+		clear(store.buckets)
+	}
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
@@ -0,0 +1,195 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/config"
+	"go-examples/rest/model"
+	"go-examples/rest/repository"
+	"go-examples/rest/test"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+var limit = config.RateLimit{RequestsPerSecond: 2, Burst: 3}
+
+func TestMemoryRateLimitStore(t *testing.T) {
+	//given
+	now := time.Now()
+	store := NewMemoryRateLimitStore().(*memoryRateLimitStore)
+	store.now = func() time.Time { return now }
+
+	//when burst is used up
+	for i := 2; i >= 0; i-- {
+		decision, err := store.Take(context.Background(), "client", limit)
+		require.NoError(t, err)
+		require.True(t, decision.Allowed)
+		require.Equal(t, i, decision.Remaining)
+	}
+	decision, err := store.Take(context.Background(), "client", limit)
+
+	//then
+	require.NoError(t, err)
+	require.False(t, decision.Allowed)
+	require.Equal(t, 0, decision.Remaining)
+	require.Equal(t, 500*time.Millisecond, decision.RetryAfter)
+	require.Equal(t, 1500*time.Millisecond, decision.Reset)
+
+	//when other client
+	decision, _ = store.Take(context.Background(), "other", limit)
+
+	//then
+	require.True(t, decision.Allowed)
+
+	//when token is refilled
+	now = now.Add(500 * time.Millisecond)
+	decision, _ = store.Take(context.Background(), "client", limit)
+
+	//then
+	require.True(t, decision.Allowed)
+	require.Equal(t, 0, decision.Remaining)
+
+	//when idle long enough, bucket doesn't exceed burst
+	now = now.Add(time.Hour)
+	decision, _ = store.Take(context.Background(), "client", limit)
+
+	//then
+	require.True(t, decision.Allowed)
+	require.Equal(t, 2, decision.Remaining)
+}
+
+func TestRateLimiter(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), &config.RateLimitConfig{Groups: map[string]config.RateLimit{"users": limit}})
+	handler := limiter.Limit("users")
+
+	//when burst is used up
+	for i := 0; i < 3; i++ {
+		recorder, _ := serve(handler, &model.Principal{ID: "key"})
+		require.Equal(t, http.StatusOK, recorder.Code)
+	}
+	recorder, ctx := serve(handler, &model.Principal{ID: "key"})
+
+	//then
+	require.Equal(t, http.StatusTooManyRequests, recorder.Code)
+	require.True(t, ctx.IsAborted())
+	require.Equal(t, "1", recorder.Header().Get("Retry-After"))
+	require.Equal(t, "3", recorder.Header().Get("RateLimit-Limit"))
+	require.Equal(t, "0", recorder.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, "2", recorder.Header().Get("RateLimit-Reset"))
+
+	//when other principal and request without principal
+	other, _ := serve(handler, &model.Principal{ID: "other"})
+	anonymous, _ := serve(handler, nil)
+
+	//then
+	require.Equal(t, http.StatusOK, other.Code)
+	require.Equal(t, "2", other.Header().Get("RateLimit-Remaining"))
+	require.Equal(t, http.StatusOK, anonymous.Code)
+	require.Empty(t, anonymous.Header().Get("Retry-After"))
+}
+
+func TestRateLimiterBeforeAuthenticationThrottlesInvalidKeys(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	store := new(test.APIKeyRepositoryMock)
+	store.On("FindByKey", mock.Anything).Return((*model.APIKey)(nil), repository.ErrAPIKeyNotFound)
+	authentication, err := NewAuthentication(store, &authConfig)
+	require.NoError(t, err)
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), &config.RateLimitConfig{Groups: map[string]config.RateLimit{"authentication": limit}})
+	router := gin.New()
+	router.GET("/users", limiter.Limit("authentication"), authentication.RequireAPIToken(), func(context *gin.Context) {
+		context.Status(http.StatusOK)
+	})
+	codes := make([]int, 0, 4)
+
+	//when client guesses keys
+	for i := 0; i < 4; i++ {
+		request := httptest.NewRequest(http.MethodGet, "/users", nil)
+		request.Header.Set(apiKeyHeader, fmt.Sprintf("guess-%d", i))
+		recorder := httptest.NewRecorder()
+		router.ServeHTTP(recorder, request)
+		codes = append(codes, recorder.Code)
+	}
+
+	//then it's throttled by ip once burst is used up
+	require.Equal(t, []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests}, codes)
+	store.AssertNumberOfCalls(t, "FindByKey", 3)
+}
+
+func TestRateLimiterGroupWithoutLimit(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), &config.RateLimitConfig{Groups: map[string]config.RateLimit{"users": limit}})
+	handler := limiter.Limit("keys")
+
+	for i := 0; i < 10; i++ {
+		//when
+		recorder, _ := serve(handler, nil)
+
+		//then
+		require.Equal(t, http.StatusOK, recorder.Code)
+		require.Empty(t, recorder.Header().Get("RateLimit-Limit"))
+	}
+}
+
+func TestRateLimiterReload(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	limiter := NewRateLimiter(NewMemoryRateLimitStore(), &config.RateLimitConfig{})
+	handler := limiter.Limit("users")
+
+	//when limit is configured after handler was created
+	limiter.Reload(&config.RateLimitConfig{Groups: map[string]config.RateLimit{"users": {RequestsPerSecond: 1, Burst: 1}}})
+	first, _ := serve(handler, nil)
+	second, _ := serve(handler, nil)
+
+	//then
+	require.Equal(t, http.StatusOK, first.Code)
+	require.Equal(t, "1", first.Header().Get("RateLimit-Limit"))
+	require.Equal(t, http.StatusTooManyRequests, second.Code)
+}
+
+func TestRateLimiterStoreError(t *testing.T) {
+	//given
+	gin.SetMode(gin.TestMode)
+	store := new(RateLimitStoreMock)
+	store.On("Take", mock.Anything, "users:ip:192.0.2.1", limit).Return(nil, errors.New("store unavailable"))
+	limiter := NewRateLimiter(store, &config.RateLimitConfig{Groups: map[string]config.RateLimit{"users": limit}})
+
+	//when
+	recorder, ctx := serve(limiter.Limit("users"), nil)
+
+	//then
+	require.Equal(t, http.StatusOK, recorder.Code)
+	require.False(t, ctx.IsAborted())
+	require.Len(t, ctx.Errors, 1)
+}
+
+func serve(handler gin.HandlerFunc, principal *model.Principal) (*httptest.ResponseRecorder, *gin.Context) {
+	recorder := httptest.NewRecorder()
+	ctx, _ := gin.CreateTestContext(recorder)
+	ctx.Request = httptest.NewRequest("GET", "/", nil)
+	if principal != nil {
+		ctx.Set(model.PrincipalKey, principal)
+	}
+	handler(ctx)
+	return recorder, ctx
+}
+
+type RateLimitStoreMock struct {
+	mock.Mock
+}
+
+func (m *RateLimitStoreMock) Take(ctx context.Context, key string, limit config.RateLimit) (*RateLimitDecision, error) {
+	args := m.Called(ctx, key, limit)
+	decision, _ := args.Get(0).(*RateLimitDecision)
+	return decision, args.Error(1)
+}
@@ -0,0 +1,15 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-examples/rest/database"
+)
+
+// ReadYourWrites starts database session per request, once request writes its reads go to the primary instead of replicas.
+// Session is put into request context, router needs ContextWithFallback same as for Tracing.
+func ReadYourWrites() gin.HandlerFunc {
+	return func(context *gin.Context) {
+		context.Request = context.Request.WithContext(database.WithSession(context.Request.Context()))
+		context.Next()
+	}
+}
@@ -0,0 +1,48 @@
+package middleware
+
+import (
+	"github.com/gin-gonic/gin"
+	"go-examples/rest/tracing"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+)
+
+// Tracing starts server span per request continuing trace from W3C traceparent header.
+// Span is put into request context, so router needs ContextWithFallback for handlers to pass it on through gin context.
+func Tracing(provider trace.TracerProvider) gin.HandlerFunc {
+	tracer := provider.Tracer(tracing.InstrumentationName)
+	return func(context *gin.Context) {
+		ctx := tracing.Propagator.Extract(context.Request.Context(), propagation.HeaderCarrier(context.Request.Header))
+		name := context.Request.Method
+		if route := context.FullPath(); route != "" {
+			name += " " + route
+		}
+		ctx, span := tracer.Start(ctx, name,
+			trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				semconv.HTTPRequestMethodKey.String(context.Request.Method),
+				semconv.HTTPRoute(context.FullPath()),
+				semconv.URLPath(context.Request.URL.Path),
+				semconv.ClientAddress(context.ClientIP()),
+				semconv.UserAgentOriginal(context.Request.UserAgent()),
+			),
+		)
+		defer span.End()
+		context.Request = context.Request.WithContext(ctx)
+
+		context.Next()
+
+		status := context.Writer.Status()
+		span.SetAttributes(semconv.HTTPResponseStatusCode(status))
+		for _, err := range context.Errors {
+			span.RecordError(err.Err)
+		}
+		//4xx are client errors, server span is failed only on 5xx
+		if status >= http.StatusInternalServerError {
+			span.SetStatus(codes.Error, http.StatusText(status))
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"errors"
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/database"
+	"go-examples/rest/test"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTracingSpanTree(t *testing.T) {
+	//given
+	recorder, router := tracedRouter()
+	router.GET("/health/:id", func(context *gin.Context) {
+		_ = context.Error(errors.New("database down"))
+		context.Status(http.StatusServiceUnavailable)
+	})
+
+	//when
+	rq := httptest.NewRequest("GET", "/health/abc", nil)
+	router.ServeHTTP(httptest.NewRecorder(), rq)
+
+	//then
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	db, server := spans[0], spans[1]
+	require.Equal(t, "GET /health/:id", server.Name())
+	require.Equal(t, trace.SpanKindServer, server.SpanKind())
+	require.False(t, server.Parent().IsValid())
+	require.Contains(t, server.Attributes(), semconv.HTTPRoute("/health/:id"))
+	require.Contains(t, server.Attributes(), semconv.HTTPResponseStatusCode(http.StatusServiceUnavailable))
+	require.Equal(t, codes.Error, server.Status().Code)
+	require.Len(t, server.Events(), 1)
+
+	require.Equal(t, "postgres.ping", db.Name())
+	require.Equal(t, server.SpanContext().TraceID(), db.SpanContext().TraceID())
+	require.Equal(t, server.SpanContext().SpanID(), db.Parent().SpanID())
+}
+
+func TestTracingPropagation(t *testing.T) {
+	//given
+	recorder, router := tracedRouter()
+	router.GET("/users", func(context *gin.Context) {})
+
+	//when
+	rq := httptest.NewRequest("GET", "/users", nil)
+	rq.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	router.ServeHTTP(httptest.NewRecorder(), rq)
+
+	//then
+	spans := recorder.Ended()
+	require.Len(t, spans, 2)
+	server := spans[1]
+	require.Equal(t, "4bf92f3577b34da6a3ce929d0e0e4736", server.SpanContext().TraceID().String())
+	require.Equal(t, "00f067aa0ba902b7", server.Parent().SpanID().String())
+	require.True(t, server.Parent().IsRemote())
+	require.Equal(t, codes.Unset, server.Status().Code)
+}
+
+func TestMetricsExemplar(t *testing.T) {
+	//given
+	recorder, router := tracedRouter()
+	router.Use(Metrics())
+	router.GET("/exemplar", func(context *gin.Context) {})
+
+	//when
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/exemplar", nil))
+
+	//then
+	metric := new(dto.Metric)
+	require.NoError(t, requestDuration.With(prometheus.Labels{"path": "/exemplar"}).(prometheus.Metric).Write(metric))
+	var exemplar *dto.Exemplar
+	for _, bucket := range metric.GetHistogram().GetBucket() {
+		if bucket.GetExemplar() != nil {
+			exemplar = bucket.GetExemplar()
+		}
+	}
+	require.NotNil(t, exemplar)
+	require.Equal(t, "trace_id", exemplar.GetLabel()[0].GetName())
+	require.Equal(t, recorder.Ended()[0].SpanContext().TraceID().String(), exemplar.GetLabel()[0].GetValue())
+}
+
+// tracedRouter pings traced database in every request, like health check would
+func tracedRouter() (*tracetest.SpanRecorder, *gin.Engine) {
+	gin.SetMode(gin.TestMode)
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	databaseMock := new(test.DatabaseMock)
+	databaseMock.On("Ping", mock.Anything).Return(nil)
+	traced := database.NewTracedDatabase(databaseMock, provider)
+
+	router := gin.New()
+	router.ContextWithFallback = true
+	router.Use(Tracing(provider), func(context *gin.Context) {
+		_ = traced.Ping(context)
+	})
+	return recorder, router
+}
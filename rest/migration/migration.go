@@ -0,0 +1,205 @@
+package migration
+
+import (
+	"cmp"
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+)
+
+//go:embed migrations/*.sql
+var embedded embed.FS
+
+// lockKey identifies advisory lock held while migrating, replicas starting at once wait for each other
+const lockKey = 7_142_019_001
+
+var (
+	createMigrationsTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+    version    BIGINT PRIMARY KEY,
+    name       TEXT        NOT NULL,
+    applied_at TIMESTAMPTZ NOT NULL DEFAULT now()
+)`
+	selectApplied   = "SELECT version FROM schema_migrations ORDER BY version"
+	insertMigration = "INSERT INTO schema_migrations (version, name) VALUES ($1, $2)"
+	deleteMigration = "DELETE FROM schema_migrations WHERE version = $1"
+)
+
+// file names are <version>_<name>.<up|down>.sql, e.g. 0001_create_user.up.sql
+var fileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      string
+	//empty when migration can't be reverted
+	Down string
+}
+
+// Migrator applies migrations in version order, each one in its own transaction together with its schema_migrations row
+type Migrator struct {
+	pool       *pgxpool.Pool
+	migrations []Migration
+}
+
+// New uses migrations embedded from migrations directory
+func New(pool *pgxpool.Pool) (*Migrator, error) {
+	migrations, err := Load(embedded)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{pool: pool, migrations: migrations}, nil
+}
+
+// Load reads migrations from migrations directory of fsys ordered by version
+func Load(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := make(map[int64]*Migration)
+	for _, entry := range entries {
+		match := fileName.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("invalid migration file name %s", entry.Name())
+		}
+		version, err := strconv.ParseInt(match[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration version %s: %w", entry.Name(), err)
+		}
+		content, err := fs.ReadFile(fsys, "migrations/"+entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != match[2] {
+			return nil, fmt.Errorf("migration %d has files with different names", version)
+		}
+		if match[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, migration := range byVersion {
+		if migration.Up == "" {
+			return nil, fmt.Errorf("migration %d has no up file", migration.Version)
+		}
+		migrations = append(migrations, *migration)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int {
+		return cmp.Compare(a.Version, b.Version)
+	})
+	return migrations, nil
+}
+
+// Up applies all pending migrations, returns versions applied
+func (migrator *Migrator) Up(ctx context.Context) ([]int64, error) {
+	var applied []int64
+	err := migrator.locked(ctx, func(conn *pgxpool.Conn, done []int64) error {
+		for _, migration := range migrator.migrations {
+			if slices.Contains(done, migration.Version) {
+				continue
+			}
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, migration.Up); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, insertMigration, migration.Version, migration.Name)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error applying migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			applied = append(applied, migration.Version)
+		}
+		return nil
+	})
+	return applied, err
+}
+
+// Down reverts up to steps most recently applied migrations, returns versions reverted
+func (migrator *Migrator) Down(ctx context.Context, steps int) ([]int64, error) {
+	var reverted []int64
+	err := migrator.locked(ctx, func(conn *pgxpool.Conn, done []int64) error {
+		for i := len(done) - 1; i >= 0 && len(reverted) < steps; i-- {
+			index := slices.IndexFunc(migrator.migrations, func(migration Migration) bool {
+				return migration.Version == done[i]
+			})
+			if index < 0 {
+				return fmt.Errorf("applied migration %d is unknown", done[i])
+			}
+			migration := migrator.migrations[index]
+			if migration.Down == "" {
+				return fmt.Errorf("migration %d_%s can't be reverted", migration.Version, migration.Name)
+			}
+			err := pgx.BeginFunc(ctx, conn, func(tx pgx.Tx) error {
+				if _, err := tx.Exec(ctx, migration.Down); err != nil {
+					return err
+				}
+				_, err := tx.Exec(ctx, deleteMigration, migration.Version)
+				return err
+			})
+			if err != nil {
+				return fmt.Errorf("error reverting migration %d_%s: %w", migration.Version, migration.Name, err)
+			}
+			reverted = append(reverted, migration.Version)
+		}
+		return nil
+	})
+	return reverted, err
+}
+
+// Version returns the latest applied version, 0 when nothing is applied yet
+func (migrator *Migrator) Version(ctx context.Context) (int64, error) {
+	var version int64
+	err := migrator.locked(ctx, func(_ *pgxpool.Conn, done []int64) error {
+		if len(done) > 0 {
+			version = done[len(done)-1]
+		}
+		return nil
+	})
+	return version, err
+}
+
+// locked runs migrate holding session advisory lock on single connection, applied versions are read under the lock
+func (migrator *Migrator) locked(ctx context.Context, migrate func(*pgxpool.Conn, []int64) error) (err error) {
+	conn, err := migrator.pool.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Release()
+	if _, err := conn.Exec(ctx, "SELECT pg_advisory_lock($1)", lockKey); err != nil {
+		return fmt.Errorf("error acquiring migration lock: %w", err)
+	}
+	defer func() {
+		//lock is released with the session anyway, connection is closed when unlock fails so it isn't reused holding it
+		if _, unlockErr := conn.Exec(context.Background(), "SELECT pg_advisory_unlock($1)", lockKey); unlockErr != nil {
+			_ = conn.Conn().Close(context.Background())
+			err = errors.Join(err, unlockErr)
+		}
+	}()
+	if _, err := conn.Exec(ctx, createMigrationsTable); err != nil {
+		return err
+	}
+	rows, err := conn.Query(ctx, selectApplied)
+	if err != nil {
+		return err
+	}
+	done, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return err
+	}
+	return migrate(conn, done)
+}
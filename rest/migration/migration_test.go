@@ -0,0 +1,65 @@
+package migration
+
+import (
+	"github.com/stretchr/testify/require"
+	"testing"
+	"testing/fstest"
+)
+
+func TestLoadEmbedded(t *testing.T) {
+	//when
+	migrations, err := Load(embedded)
+
+	//then versions are contiguous so none is skipped
+	require.NoError(t, err)
+	require.NotEmpty(t, migrations)
+	for i, migration := range migrations {
+		require.Equal(t, int64(i+1), migration.Version)
+		require.NotEmpty(t, migration.Up)
+		require.NotEmpty(t, migration.Down, migration.Name)
+	}
+}
+
+func TestLoadOrdersByVersion(t *testing.T) {
+	//given
+	fsys := fstest.MapFS{
+		"migrations/10_third.up.sql":   {Data: []byte("third")},
+		"migrations/2_second.up.sql":   {Data: []byte("second")},
+		"migrations/2_second.down.sql": {Data: []byte("revert second")},
+		"migrations/1_first.up.sql":    {Data: []byte("first")},
+	}
+
+	//when
+	migrations, err := Load(fsys)
+
+	//then
+	require.NoError(t, err)
+	require.Equal(t, []Migration{
+		{Version: 1, Name: "first", Up: "first"},
+		{Version: 2, Name: "second", Up: "second", Down: "revert second"},
+		{Version: 10, Name: "third", Up: "third"},
+	}, migrations)
+}
+
+func TestLoadInvalid(t *testing.T) {
+	tests := []struct {
+		name string
+		fsys fstest.MapFS
+	}{
+		{"invalid name", fstest.MapFS{"migrations/first.up.sql": {}}},
+		{"missing direction", fstest.MapFS{"migrations/1_first.sql": {}}},
+		{"missing up", fstest.MapFS{"migrations/1_first.down.sql": {Data: []byte("down")}}},
+		{"conflicting names", fstest.MapFS{
+			"migrations/1_first.up.sql": {Data: []byte("up")},
+			"migrations/1_other.up.sql": {Data: []byte("up")},
+		}},
+		{"missing directory", fstest.MapFS{}},
+	}
+	for _, test := range tests {
+		//when
+		_, err := Load(test.fsys)
+
+		//then
+		require.Error(t, err, test.name)
+	}
+}
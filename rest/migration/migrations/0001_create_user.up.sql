@@ -0,0 +1,20 @@
+-- idempotent, database created from former docker/schema.sql may have the table with all or some of these already
+CREATE TABLE IF NOT EXISTS "user"
+(
+    id    uuid PRIMARY KEY,
+    email VARCHAR(255) NOT NULL
+);
+
+ALTER TABLE "user" ADD COLUMN IF NOT EXISTS version INTEGER NOT NULL DEFAULT 1;
+
+DO
+$$
+    BEGIN
+        IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'user_email_key' AND conrelid = '"user"'::regclass) THEN
+            ALTER TABLE "user" ADD CONSTRAINT user_email_key UNIQUE (email);
+        END IF;
+    END
+$$;
+
+-- supports keyset pagination ordered by email
+CREATE INDEX IF NOT EXISTS user_email_id_idx ON "user" (email, id);
@@ -0,0 +1,13 @@
+-- only sha256 of the key is stored, plaintext is returned once on creation.
+-- Idempotent, database created from former docker/schema.sql has the table already
+CREATE TABLE IF NOT EXISTS api_key
+(
+    id         uuid PRIMARY KEY,
+    key_hash   BYTEA        NOT NULL,
+    owner      VARCHAR(255) NOT NULL,
+    scopes     TEXT[]       NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ,
+    CONSTRAINT api_key_hash_key UNIQUE (key_hash)
+);
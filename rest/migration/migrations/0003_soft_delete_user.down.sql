@@ -0,0 +1,11 @@
+-- deleted users would violate unique constraint again, they're removed for good
+DELETE FROM "user" WHERE deleted_at IS NOT NULL;
+
+DROP INDEX user_deleted_at_idx;
+DROP INDEX user_email_key;
+ALTER TABLE "user" ADD CONSTRAINT user_email_key UNIQUE (email);
+
+ALTER TABLE "user"
+    DROP COLUMN created_at,
+    DROP COLUMN updated_at,
+    DROP COLUMN deleted_at;
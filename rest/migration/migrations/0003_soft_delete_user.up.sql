@@ -0,0 +1,11 @@
+ALTER TABLE "user"
+    ADD COLUMN created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    ADD COLUMN deleted_at TIMESTAMPTZ;
+
+-- email of deleted user can be taken again, restore fails with conflict when it was
+ALTER TABLE "user" DROP CONSTRAINT user_email_key;
+CREATE UNIQUE INDEX user_email_key ON "user" (email) WHERE deleted_at IS NULL;
+
+-- supports purge of users deleted before retention
+CREATE INDEX user_deleted_at_idx ON "user" (deleted_at) WHERE deleted_at IS NOT NULL;
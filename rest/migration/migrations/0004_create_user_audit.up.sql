@@ -0,0 +1,28 @@
+-- append only history of user changes, kept after user is purged so there is no foreign key
+CREATE TABLE user_audit
+(
+    id         BIGINT GENERATED ALWAYS AS IDENTITY PRIMARY KEY,
+    user_id    uuid         NOT NULL,
+    actor      VARCHAR(255) NOT NULL,
+    action     VARCHAR(32)  NOT NULL,
+    before     JSONB,
+    after      JSONB,
+    request_id VARCHAR(128),
+    created_at TIMESTAMPTZ  NOT NULL DEFAULT now()
+);
+
+-- supports audit trail of single user paged from the newest entry
+CREATE INDEX user_audit_user_id_idx ON user_audit (user_id, id);
+
+CREATE FUNCTION user_audit_append_only() RETURNS trigger AS
+$$
+BEGIN
+    RAISE EXCEPTION 'user_audit is append only';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER user_audit_append_only
+    BEFORE UPDATE OR DELETE
+    ON user_audit
+    FOR EACH ROW
+EXECUTE FUNCTION user_audit_append_only();
@@ -0,0 +1,49 @@
+package model
+
+import (
+	"context"
+	"time"
+)
+
+// PrincipalKey under which authenticated Principal is stored in gin context
+const PrincipalKey = "principal"
+
+type APIKey struct {
+	ID        string     `json:"id"`
+	Owner     string     `json:"owner"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether key can still be used to authenticate
+func (key *APIKey) Active(now time.Time) bool {
+	return key.RevokedAt == nil && (key.ExpiresAt == nil || now.Before(*key.ExpiresAt))
+}
+
+type PostAPIKey struct {
+	Owner     string     `json:"owner" binding:"required,max=255"`
+	Scopes    []string   `json:"scopes"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// CreatedAPIKey carries plaintext key, only hash is stored so it's returned just once
+type CreatedAPIKey struct {
+	APIKey
+	Key string `json:"key"`
+}
+
+// Principal is the authenticated caller
+type Principal struct {
+	ID     string   `json:"id"`
+	Owner  string   `json:"owner"`
+	Scopes []string `json:"scopes"`
+	Method string   `json:"method"`
+}
+
+// PrincipalFrom returns principal stored in context, works with gin context and contexts derived from it
+func PrincipalFrom(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(PrincipalKey).(*Principal)
+	return principal
+}
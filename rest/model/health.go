@@ -0,0 +1,39 @@
+package model
+
+import "time"
+
+// health statuses, degraded means some replica is down while reads and writes are still served
+const (
+	HealthUp       = "up"
+	HealthDegraded = "degraded"
+	HealthDown     = "down"
+)
+
+type Health struct {
+	Status string       `json:"status"`
+	Nodes  []NodeStatus `json:"nodes"`
+}
+
+// NodeStatus health of single database node, error is logged but not exposed
+type NodeStatus struct {
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	Healthy bool   `json:"healthy"`
+	InUse   int32  `json:"in_use"`
+	Err     error  `json:"-"`
+}
+
+// Probe result of liveness or readiness probe, it's down when any of its checks is
+type Probe struct {
+	Status string        `json:"status"`
+	Checks []CheckResult `json:"checks"`
+}
+
+// CheckResult outcome of single dependency check, cached result keeps time and latency of the run that produced it
+type CheckResult struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	LatencyMs float64   `json:"latency_ms"`
+	CheckedAt time.Time `json:"checked_at"`
+	Err       error     `json:"-"`
+}
@@ -0,0 +1,12 @@
+package model
+
+type ImportResult struct {
+	Imported int64         `json:"imported"`
+	Errors   []ImportError `json:"errors,omitempty"`
+}
+
+// ImportError describes why single row of bulk import was rejected, line is 1-based
+type ImportError struct {
+	Line    int    `json:"line"`
+	Message string `json:"message"`
+}
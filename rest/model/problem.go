@@ -0,0 +1,51 @@
+package model
+
+// ProblemContentType RFC 7807 media type of error responses
+const ProblemContentType = "application/problem+json"
+
+// ProblemTypePrefix problem type URI is the prefix followed by the code
+const ProblemTypePrefix = "urn:rest-app:problem:"
+
+// Error codes are part of the API contract, clients can rely on them instead of parsing details
+const (
+	ErrorCodeInvalidRequest       = "invalid_request"
+	ErrorCodeInvalidQuery         = "invalid_query"
+	ErrorCodeInvalidID            = "invalid_id"
+	ErrorCodeInvalidCursor        = "invalid_cursor"
+	ErrorCodeInvalidPatch         = "invalid_patch"
+	ErrorCodeInvalidCSVHeader     = "invalid_csv_header"
+	ErrorCodeUnsupportedMediaType = "unsupported_media_type"
+	ErrorCodeNotAcceptable        = "not_acceptable"
+	ErrorCodeUserNotFound         = "user_not_found"
+	ErrorCodeUserAlreadyExists    = "user_already_exists"
+	ErrorCodeVersionMismatch      = "version_mismatch"
+	ErrorCodeAPIKeyNotFound       = "api_key_not_found"
+	ErrorCodeMissingCredentials   = "missing_credentials"
+	ErrorCodeInvalidCredentials   = "invalid_credentials"
+	ErrorCodeInsufficientScope    = "insufficient_scope"
+	ErrorCodeRateLimited          = "rate_limited"
+	ErrorCodeRequestTooLarge      = "request_too_large"
+	ErrorCodeRequestTimeout       = "request_timeout"
+	ErrorCodeDatabaseUnavailable  = "database_unavailable"
+	ErrorCodeInternal             = "internal_error"
+)
+
+// Problem RFC 7807 problem details, code and request id are extension members
+type Problem struct {
+	Type      string       `json:"type"`
+	Title     string       `json:"title"`
+	Status    int          `json:"status"`
+	Detail    string       `json:"detail,omitempty"`
+	Instance  string       `json:"instance,omitempty"`
+	Code      string       `json:"code"`
+	RequestID string       `json:"request_id,omitempty"`
+	Timestamp string       `json:"timestamp"`
+	Errors    []FieldError `json:"errors,omitempty"`
+}
+
+// FieldError single invalid field of request body or query, field is named as in json (or query parameter)
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
@@ -0,0 +1,28 @@
+package model
+
+import (
+	"context"
+	"log/slog"
+)
+
+// keys under which request scoped values are stored in gin context
+const (
+	RequestIDKey = "request_id"
+	LoggerKey    = "logger"
+	//max body size of the route, decompressed body is held to it as well
+	BodyLimitKey = "body_limit"
+)
+
+// RequestIDFrom returns id of the request being served, empty outside of request
+func RequestIDFrom(ctx context.Context) string {
+	id, _ := ctx.Value(RequestIDKey).(string)
+	return id
+}
+
+// LoggerFrom returns request scoped logger carrying request id, default logger outside of request
+func LoggerFrom(ctx context.Context) *slog.Logger {
+	if logger, ok := ctx.Value(LoggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return slog.Default()
+}
@@ -0,0 +1,22 @@
+package model
+
+import "slices"
+
+// scopes granted to api keys and bearer tokens, checked per route
+const (
+	ScopeUsersRead   = "users:read"
+	ScopeUsersWrite  = "users:write"
+	ScopeUsersDelete = "users:delete"
+	//purges deleted users for good
+	ScopeUsersAdmin = "users:admin"
+	//reads audit trail holding past states of users
+	ScopeAuditRead = "audit:read"
+	ScopeKeysAdmin = "keys:admin"
+	//reads config in effect, secrets are redacted
+	ScopeConfigRead = "config:read"
+)
+
+// HasScope reports whether principal was granted the scope
+func (principal *Principal) HasScope(scope string) bool {
+	return principal != nil && slices.Contains(principal.Scopes, scope)
+}
@@ -1,10 +1,75 @@
 package model
 
+import (
+	"strings"
+	"time"
+)
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
 type User struct {
 	ID    string `json:"id"`
 	Email string `json:"email"`
+	//exposed as ETag header, incremented on every update
+	Version   int       `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	//nil for active users, reads skip deleted ones
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
 }
 
+// PostUser email is limited to the size of email column, email_address is RFC 5322 addr-spec without display name
 type PostUser struct {
-	Email string `json:"email" binding:"required"`
+	Email string `json:"email" binding:"required,max=255,email_address"`
+}
+
+// Normalize is applied before validation, so validated value is the one that's stored
+func (user *PostUser) Normalize() {
+	user.Email = NormalizeEmail(user.Email)
+}
+
+// NormalizeEmail trims whitespace and lowercases domain, local part is case-sensitive according to RFC 5321
+func NormalizeEmail(email string) string {
+	email = strings.TrimSpace(email)
+	at := strings.LastIndex(email, "@")
+	if at < 0 {
+		return email
+	}
+	return email[:at+1] + strings.ToLower(email[at+1:])
+}
+
+// IDParam :id path parameter, ids are generated as UUIDs
+type IDParam struct {
+	ID string `uri:"id" binding:"required,uuid"`
+}
+
+// UserPatch holds changed fields only, nil field is left untouched
+type UserPatch struct {
+	Email *string
+}
+
+func (patch *UserPatch) Empty() bool {
+	return patch.Email == nil
+}
+
+// UserQuery holds GET /users query params, sort prefixed with "-" means descending order
+type UserQuery struct {
+	Limit       int    `form:"limit" binding:"omitempty,min=1,max=100"`
+	Cursor      string `form:"cursor"`
+	EmailPrefix string `form:"email_prefix"`
+	Domain      string `form:"domain"`
+	Sort        string `form:"sort" binding:"omitempty,oneof=id -id email -email"`
+}
+
+type UserPage struct {
+	Users      []*User `json:"users"`
+	NextCursor string  `json:"next_cursor,omitempty"`
+}
+
+// PurgeResult number of soft deleted users removed for good
+type PurgeResult struct {
+	Purged int64 `json:"purged"`
 }
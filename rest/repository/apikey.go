@@ -0,0 +1,143 @@
+package repository
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"go-examples/rest/config"
+	"go-examples/rest/database"
+	"go-examples/rest/model"
+	"time"
+)
+
+var (
+	selectAPIKeyByHash = "SELECT id, owner, scopes, created_at, expires_at, revoked_at FROM public.api_key WHERE key_hash = $1"
+	selectActiveAPIKey = "SELECT id, owner, scopes, created_at, expires_at, revoked_at FROM public.api_key WHERE id = $1 AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > now()) FOR UPDATE"
+	insertAPIKey       = "INSERT INTO public.api_key (id, key_hash, owner, scopes, expires_at) VALUES ($1, $2, $3, $4, $5) RETURNING created_at"
+	revokeAPIKey       = "UPDATE public.api_key SET revoked_at = now() WHERE id = $1 AND revoked_at IS NULL"
+)
+
+var ErrAPIKeyNotFound = errors.New("api key not found")
+
+type APIKeyRepository struct {
+	database database.Database
+	config   *config.DBConfig
+}
+
+func NewAPIKeyRepository(database database.Database, config *config.DBConfig) *APIKeyRepository {
+	return &APIKeyRepository{
+		database: database,
+		config:   config,
+	}
+}
+
+// FindByKey looks key up by its hash, revoked and expired keys are returned as well.
+func (repository *APIKeyRepository) FindByKey(ctx context.Context, key string) (*model.APIKey, error) {
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "FindAPIKey")
+	defer done()
+	//key created moments ago may not be replicated yet
+	found, err := scanAPIKey(database.Primary(timeoutCtx, repository.database).QueryRow(timeoutCtx, selectAPIKeyByHash, HashAPIKey(key)))
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, ErrAPIKeyNotFound
+		}
+		return nil, err
+	}
+	return found, nil
+}
+
+// Create generates new random key, only its hash is stored.
+func (repository *APIKeyRepository) Create(ctx context.Context, postKey *model.PostAPIKey) (*model.CreatedAPIKey, error) {
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "CreateAPIKey")
+	defer done()
+	return insertKey(timeoutCtx, repository.conn(timeoutCtx), postKey.Owner, postKey.Scopes, postKey.ExpiresAt)
+}
+
+// Rotate replaces active key with new one having the same owner, scopes and expiry, old key is revoked.
+// Returns ErrAPIKeyNotFound when key doesn't exist, is already revoked or expired - replacement would be expired as well.
+func (repository *APIKeyRepository) Rotate(ctx context.Context, id string) (*model.CreatedAPIKey, error) {
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "RotateAPIKey")
+	defer done()
+	var created *model.CreatedAPIKey
+	err := database.WithTx(timeoutCtx, repository.database, pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		old, err := scanAPIKey(tx.QueryRow(ctx, selectActiveAPIKey, id))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrAPIKeyNotFound
+			}
+			return err
+		}
+		created, err = insertKey(ctx, tx, old.Owner, old.Scopes, old.ExpiresAt)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(ctx, revokeAPIKey, id)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+// Revoke returns ErrAPIKeyNotFound when key doesn't exist or is already revoked.
+func (repository *APIKeyRepository) Revoke(ctx context.Context, id string) error {
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "RevokeAPIKey")
+	defer done()
+	tag, err := repository.conn(timeoutCtx).Exec(timeoutCtx, revokeAPIKey, id)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return ErrAPIKeyNotFound
+	}
+	return nil
+}
+
+// conn runs statements within transaction carried by ctx, same as UserRepository does
+func (repository *APIKeyRepository) conn(ctx context.Context) database.Querier {
+	return database.Conn(ctx, repository.database)
+}
+
+// HashAPIKey keys are random with 256 bits of entropy so plain sha256 is enough, no need for slow password hashing
+func HashAPIKey(key string) []byte {
+	hash := sha256.Sum256([]byte(key))
+	return hash[:]
+}
+
+// insertKey creates key with random secret through db, which is the pool or transaction the key is rotated in
+func insertKey(ctx context.Context, db database.Querier, owner string, scopes []string, expiresAt *time.Time) (*model.CreatedAPIKey, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if scopes == nil {
+		scopes = []string{}
+	}
+	created := &model.CreatedAPIKey{
+		APIKey: model.APIKey{
+			ID:        uuid.New().String(),
+			Owner:     owner,
+			Scopes:    scopes,
+			ExpiresAt: expiresAt,
+		},
+		Key: base64.RawURLEncoding.EncodeToString(secret),
+	}
+	err := db.QueryRow(ctx, insertAPIKey, created.ID, HashAPIKey(created.Key), owner, scopes, expiresAt).Scan(&created.CreatedAt)
+	if err != nil {
+		return nil, err
+	}
+	return created, nil
+}
+
+func scanAPIKey(row pgx.Row) (*model.APIKey, error) {
+	key := new(model.APIKey)
+	if err := row.Scan(&key.ID, &key.Owner, &key.Scopes, &key.CreatedAt, &key.ExpiresAt, &key.RevokedAt); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
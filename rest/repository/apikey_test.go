@@ -0,0 +1,104 @@
+package repository
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/model"
+	"time"
+)
+
+//api key tests share containers with user suite
+
+func (suite *UserSuite) TestAPIKeyCreate() {
+	//given
+	apiKeyRepository := NewAPIKeyRepository(suite.database, suite.userRepository.config)
+	expiresAt := time.Now().Add(time.Hour).UTC().Truncate(time.Microsecond)
+
+	//when
+	created, err := apiKeyRepository.Create(context.Background(), &model.PostAPIKey{Owner: "owner", Scopes: []string{"users:read"}, ExpiresAt: &expiresAt})
+
+	//then
+	require.NoError(suite.T(), err)
+	require.NotEmpty(suite.T(), created.Key)
+
+	//and
+	found, err := apiKeyRepository.FindByKey(context.Background(), created.Key)
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), created.ID, found.ID)
+	require.Equal(suite.T(), "owner", found.Owner)
+	require.Equal(suite.T(), []string{"users:read"}, found.Scopes)
+	require.True(suite.T(), expiresAt.Equal(*found.ExpiresAt))
+	require.True(suite.T(), found.Active(time.Now()))
+}
+
+func (suite *UserSuite) TestAPIKeyFindUnknown() {
+	//given
+	apiKeyRepository := NewAPIKeyRepository(suite.database, suite.userRepository.config)
+
+	//when
+	_, err := apiKeyRepository.FindByKey(context.Background(), "unknown")
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrAPIKeyNotFound)
+}
+
+func (suite *UserSuite) TestAPIKeyRotate() {
+	//given
+	apiKeyRepository := NewAPIKeyRepository(suite.database, suite.userRepository.config)
+	created, _ := apiKeyRepository.Create(context.Background(), &model.PostAPIKey{Owner: "owner", Scopes: []string{"users:read"}})
+
+	//when
+	rotated, err := apiKeyRepository.Rotate(context.Background(), created.ID)
+
+	//then
+	require.NoError(suite.T(), err)
+	require.NotEqual(suite.T(), created.Key, rotated.Key)
+	require.Equal(suite.T(), created.Owner, rotated.Owner)
+	require.Equal(suite.T(), created.Scopes, rotated.Scopes)
+
+	//and old key is revoked
+	old, err := apiKeyRepository.FindByKey(context.Background(), created.Key)
+	require.NoError(suite.T(), err)
+	require.False(suite.T(), old.Active(time.Now()))
+
+	//and revoked key can't be rotated again
+	_, err = apiKeyRepository.Rotate(context.Background(), created.ID)
+	require.ErrorIs(suite.T(), err, ErrAPIKeyNotFound)
+}
+
+func (suite *UserSuite) TestAPIKeyRotateExpired() {
+	//given
+	apiKeyRepository := NewAPIKeyRepository(suite.database, suite.userRepository.config)
+	expiredAt := time.Now().Add(-time.Minute)
+	created, _ := apiKeyRepository.Create(context.Background(), &model.PostAPIKey{Owner: "owner", ExpiresAt: &expiredAt})
+
+	//when
+	_, err := apiKeyRepository.Rotate(context.Background(), created.ID)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrAPIKeyNotFound)
+
+	//and expired key isn't revoked
+	old, err := apiKeyRepository.FindByKey(context.Background(), created.Key)
+	require.NoError(suite.T(), err)
+	require.Nil(suite.T(), old.RevokedAt)
+}
+
+func (suite *UserSuite) TestAPIKeyRevoke() {
+	//given
+	apiKeyRepository := NewAPIKeyRepository(suite.database, suite.userRepository.config)
+	created, _ := apiKeyRepository.Create(context.Background(), &model.PostAPIKey{Owner: "owner"})
+
+	//when
+	err := apiKeyRepository.Revoke(context.Background(), created.ID)
+
+	//then
+	require.NoError(suite.T(), err)
+	found, _ := apiKeyRepository.FindByKey(context.Background(), created.Key)
+	require.NotNil(suite.T(), found.RevokedAt)
+
+	//and revoking unknown key fails
+	err = apiKeyRepository.Revoke(context.Background(), uuid.New().String())
+	require.ErrorIs(suite.T(), err, ErrAPIKeyNotFound)
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"go-examples/rest/model"
+)
+
+// userJSON builds audit state of user row in sql, same shape as model.User marshalled to json
+const userJSON = "jsonb_strip_nulls(jsonb_build_object('id', id, 'email', email, 'created_at', created_at, 'updated_at', updated_at, 'deleted_at', deleted_at))"
+
+var (
+	insertAudit = "INSERT INTO user_audit (user_id, actor, action, before, after, request_id) VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''))"
+	//ids are the ones copied, transaction may be joined by caller that changed other users already
+	auditImported = "INSERT INTO user_audit (user_id, actor, action, after, request_id) SELECT id, $1, '" + model.AuditActionImport + "', " + userJSON +
+		", NULLIF($2, '') FROM public.user WHERE id = ANY($3)"
+	purgeUsers = "WITH purged AS (DELETE FROM public.user WHERE deleted_at < now() - make_interval(secs => $1) RETURNING *) " +
+		"INSERT INTO user_audit (user_id, actor, action, before, request_id) SELECT id, $2, '" + model.AuditActionPurge + "', " + userJSON +
+		", NULLIF($3, '') FROM purged"
+	selectAudit = "SELECT id, user_id, actor, action, before, after, COALESCE(request_id, ''), created_at FROM user_audit WHERE user_id = $1"
+)
+
+// GetUserAudit returns single page of user's audit trail, newest entries first.
+// Trail outlives the user, entries of purged user are still returned.
+func (repository *UserRepository) GetUserAudit(ctx context.Context, id string, query *model.AuditQuery) (*model.AuditPage, error) {
+	sql, args := selectAudit, []any{id}
+	if query.Cursor != "" {
+		after, err := decodeAuditCursor(query.Cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, after)
+		sql += fmt.Sprintf(" AND id < $%d", len(args))
+	}
+	limit := auditPageLimit(query)
+	args = append(args, limit+1)
+	sql += fmt.Sprintf(" ORDER BY id DESC LIMIT $%d", len(args))
+
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "GetUserAudit")
+	defer done()
+	rows, err := repository.reader(timeoutCtx).Query(timeoutCtx, sql, args...)
+	if err != nil {
+		return nil, err
+	}
+	entries, err := pgx.CollectRows(rows, func(row pgx.CollectableRow) (*model.AuditEntry, error) {
+		entry := new(model.AuditEntry)
+		err := row.Scan(&entry.ID, &entry.UserID, &entry.Actor, &entry.Action, &entry.Before, &entry.After, &entry.RequestID, &entry.CreatedAt)
+		return entry, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	page := &model.AuditPage{Entries: entries}
+	//one extra row is selected only to find out whether next page exists
+	if len(entries) > limit {
+		page.Entries = entries[:limit]
+		page.NextCursor = encodeAuditCursor(page.Entries[limit-1].ID)
+	}
+	return page, nil
+}
+
+// audit records change of user made within tx, before is nil for created user and after for removed one
+func audit(ctx context.Context, tx pgx.Tx, action string, before *model.User, after *model.User) error {
+	id := after
+	if id == nil {
+		id = before
+	}
+	_, err := tx.Exec(ctx, insertAudit, id.ID, actor(ctx), action, auditState(before), auditState(after), model.RequestIDFrom(ctx))
+	return err
+}
+
+// auditState is nil for missing user so the column is stored as NULL
+func auditState(user *model.User) []byte {
+	if user == nil {
+		return nil
+	}
+	//marshalling user can't fail
+	state, _ := json.Marshal(user)
+	return state
+}
+
+// actor is the authenticated principal making the change
+func actor(ctx context.Context) string {
+	if principal := model.PrincipalFrom(ctx); principal != nil {
+		return principal.ID
+	}
+	return model.AuditActorSystem
+}
+
+func auditPageLimit(query *model.AuditQuery) int {
+	if query.Limit <= 0 || query.Limit > model.MaxPageSize {
+		return model.DefaultPageSize
+	}
+	return query.Limit
+}
@@ -0,0 +1,179 @@
+package repository
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/database"
+	"go-examples/rest/model"
+	"testing"
+	"time"
+)
+
+func (suite *UserSuite) TestAuditTrail() {
+	//given
+	ctx := context.WithValue(context.Background(), model.PrincipalKey, &model.Principal{ID: "tester"})
+	ctx = context.WithValue(ctx, model.RequestIDKey, "request-1")
+	saved, _ := suite.userRepository.Save(ctx, &testUser)
+	updated, _ := suite.userRepository.Update(ctx, saved.ID, &model.PostUser{Email: "updated@example.org"}, AnyVersion)
+	_ = suite.userRepository.Delete(ctx, saved.ID, AnyVersion)
+	_, _ = suite.userRepository.Restore(context.Background(), saved.ID)
+
+	//when
+	page, err := suite.userRepository.GetUserAudit(context.Background(), saved.ID, &model.AuditQuery{})
+
+	//then newest first
+	require.NoError(suite.T(), err)
+	require.Empty(suite.T(), page.NextCursor)
+	require.Len(suite.T(), page.Entries, 4)
+	actions := make([]string, 0, len(page.Entries))
+	for _, entry := range page.Entries {
+		actions = append(actions, entry.Action)
+	}
+	require.Equal(suite.T(), []string{model.AuditActionRestore, model.AuditActionDelete, model.AuditActionUpdate, model.AuditActionCreate}, actions)
+	require.Equal(suite.T(), model.AuditActorSystem, page.Entries[0].Actor)
+	require.Empty(suite.T(), page.Entries[0].RequestID)
+
+	update := page.Entries[2]
+	require.Equal(suite.T(), saved.ID, update.UserID)
+	require.Equal(suite.T(), "tester", update.Actor)
+	require.Equal(suite.T(), "request-1", update.RequestID)
+	require.Equal(suite.T(), testUser.Email, auditEmail(suite.T(), update.Before))
+	require.Equal(suite.T(), updated.Email, auditEmail(suite.T(), update.After))
+
+	create := page.Entries[3]
+	require.Empty(suite.T(), create.Before)
+	require.Equal(suite.T(), testUser.Email, auditEmail(suite.T(), create.After))
+}
+
+func (suite *UserSuite) TestAuditNotWrittenWhenChangeFails() {
+	//given
+	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
+	other, _ := suite.userRepository.Save(context.Background(), &model.PostUser{Email: "other@example.org"})
+
+	//when
+	_, err := suite.userRepository.Update(context.Background(), other.ID, &testUser, AnyVersion)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrUserAlreadyExists)
+	page, err := suite.userRepository.GetUserAudit(context.Background(), other.ID, &model.AuditQuery{})
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), page.Entries, 1)
+
+	//when
+	err = suite.userRepository.Delete(context.Background(), saved.ID, saved.Version+1)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrVersionMismatch)
+	page, err = suite.userRepository.GetUserAudit(context.Background(), saved.ID, &model.AuditQuery{})
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), page.Entries, 1)
+}
+
+func (suite *UserSuite) TestAuditPagination() {
+	//given
+	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
+	for i := 0; i < 4; i++ {
+		_, _ = suite.userRepository.Update(context.Background(), saved.ID, &testUser, AnyVersion)
+	}
+
+	//when
+	first, err := suite.userRepository.GetUserAudit(context.Background(), saved.ID, &model.AuditQuery{Limit: 3})
+	require.NoError(suite.T(), err)
+	second, err := suite.userRepository.GetUserAudit(context.Background(), saved.ID, &model.AuditQuery{Limit: 3, Cursor: first.NextCursor})
+
+	//then
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), first.Entries, 3)
+	require.NotEmpty(suite.T(), first.NextCursor)
+	require.Len(suite.T(), second.Entries, 2)
+	require.Empty(suite.T(), second.NextCursor)
+	require.Less(suite.T(), second.Entries[0].ID, first.Entries[2].ID)
+	require.Equal(suite.T(), model.AuditActionCreate, second.Entries[1].Action)
+}
+
+func (suite *UserSuite) TestAuditImportAndPurge() {
+	//given
+	_, err := suite.userRepository.Import(context.Background(), sliceSource([]*model.PostUser{{Email: "a@example.org"}}, nil))
+	require.NoError(suite.T(), err)
+	exported, _ := suite.exportUsers()
+	imported := exported[0]
+	_ = suite.userRepository.Delete(context.Background(), imported.ID, AnyVersion)
+
+	//when
+	purged, err := suite.userRepository.Purge(context.Background(), -time.Hour)
+
+	//then
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), int64(1), purged)
+	page, err := suite.userRepository.GetUserAudit(context.Background(), imported.ID, &model.AuditQuery{})
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), page.Entries, 3)
+	require.Equal(suite.T(), model.AuditActionPurge, page.Entries[0].Action)
+	require.Empty(suite.T(), page.Entries[0].After)
+	require.Equal(suite.T(), imported.Email, auditEmail(suite.T(), page.Entries[0].Before))
+	require.Equal(suite.T(), model.AuditActionImport, page.Entries[2].Action)
+	require.Equal(suite.T(), imported.Email, auditEmail(suite.T(), page.Entries[2].After))
+}
+
+func (suite *UserSuite) TestAuditImportWithinTransaction() {
+	//given
+	var saved *model.User
+
+	//when user saved earlier in the same transaction
+	err := database.WithTx(context.Background(), suite.database, pgx.TxOptions{}, func(ctx context.Context, _ pgx.Tx) error {
+		var err error
+		saved, err = suite.userRepository.Save(ctx, &testUser)
+		if err != nil {
+			return err
+		}
+		_, err = suite.userRepository.Import(ctx, sliceSource([]*model.PostUser{{Email: "a@example.org"}}, nil))
+		return err
+	})
+
+	//then it isn't audited as imported
+	require.NoError(suite.T(), err)
+	page, err := suite.userRepository.GetUserAudit(context.Background(), saved.ID, &model.AuditQuery{})
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), page.Entries, 1)
+	require.Equal(suite.T(), model.AuditActionCreate, page.Entries[0].Action)
+}
+
+func (suite *UserSuite) TestAuditIsAppendOnly() {
+	//given
+	_, _ = suite.userRepository.Save(context.Background(), &testUser)
+
+	//when
+	_, updateErr := suite.database.Exec(context.Background(), "UPDATE user_audit SET actor = 'someone else'")
+	_, deleteErr := suite.database.Exec(context.Background(), "DELETE FROM user_audit")
+
+	//then
+	require.ErrorContains(suite.T(), updateErr, "append only")
+	require.ErrorContains(suite.T(), deleteErr, "append only")
+}
+
+func auditEmail(t *testing.T, state json.RawMessage) string {
+	user := new(model.User)
+	require.NoError(t, json.Unmarshal(state, user))
+	return user.Email
+}
+
+func TestAuditCursor(t *testing.T) {
+	//when
+	id, err := decodeAuditCursor(encodeAuditCursor(42))
+
+	//then
+	require.NoError(t, err)
+	require.Equal(t, int64(42), id)
+}
+
+func TestAuditCursorInvalid(t *testing.T) {
+	for _, encoded := range []string{"not base64!", "bm90IGEgbnVtYmVy", "MA"} {
+		//when
+		_, err := decodeAuditCursor(encoded)
+
+		//then
+		require.ErrorIs(t, err, ErrInvalidCursor, encoded)
+	}
+}
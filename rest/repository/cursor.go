@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"github.com/google/uuid"
+	"go-examples/rest/model"
+	"strconv"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor points at the last user of a page, next page starts right after it (keyset pagination)
+// sort is kept so cursor obtained with one ordering can't be used with another
+type cursor struct {
+	Sort  string `json:"s"`
+	ID    string `json:"id"`
+	Email string `json:"e,omitempty"`
+}
+
+func encodeCursor(sort string, user *model.User) string {
+	c := cursor{Sort: sort, ID: user.ID}
+	if sortColumn(sort) == "email" {
+		c.Email = user.Email
+	}
+	//marshalling struct of strings can't fail
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(sort string, encoded string) (*cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+	c := new(cursor)
+	if err := json.Unmarshal(b, c); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	if c.Sort != sort {
+		return nil, ErrInvalidCursor
+	}
+	if _, err := uuid.Parse(c.ID); err != nil {
+		return nil, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// audit cursor is id of the last entry of a page, entries are paged by id only
+func encodeAuditCursor(id int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(id, 10)))
+}
+
+func decodeAuditCursor(encoded string) (int64, error) {
+	b, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return 0, ErrInvalidCursor
+	}
+	id, err := strconv.ParseInt(string(b), 10, 64)
+	if err != nil || id <= 0 {
+		return 0, ErrInvalidCursor
+	}
+	return id, nil
+}
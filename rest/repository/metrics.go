@@ -0,0 +1,50 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"time"
+)
+
+/*
+Histogram with operation label - duration of repository operations including all their queries
+Example metric exposed:
+rest_app_db_query_duration_bucket{operation="GetAllUsers",le="0.005"} 1
+*/
+var queryDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: "rest_app",
+	Name:      "db_query_duration",
+	Help:      "Duration of repository operations",
+}, []string{"operation"})
+
+/*
+Counter of operations cancelled by DBConfig.Timeout (or BulkTimeout), cancellation by caller isn't counted
+Example metric exposed:
+rest_app_db_timeout_count{operation="Save"} 1
+*/
+var timeoutCounter = prometheus.NewCounterVec(prometheus.CounterOpts{
+	Namespace: "rest_app",
+	Name:      "db_timeout_count",
+	Help:      "Counts repository operations that exceeded configured timeout",
+}, []string{"operation"})
+
+func RegisterMetrics() {
+	prometheus.MustRegister(queryDuration)
+	prometheus.MustRegister(timeoutCounter)
+}
+
+// withTimeout bounds ctx by timeout like context.WithTimeout, returned done has to be deferred instead of cancel,
+// it records duration of the operation and whether the timeout expired
+func withTimeout(ctx context.Context, timeout time.Duration, operation string) (context.Context, func()) {
+	start := time.Now()
+	timeoutCtx, cancel := context.WithTimeout(ctx, timeout)
+	return timeoutCtx, func() {
+		queryDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+		//parent without error means deadline was set by this timeout, not inherited from caller
+		if errors.Is(timeoutCtx.Err(), context.DeadlineExceeded) && ctx.Err() == nil {
+			timeoutCounter.WithLabelValues(operation).Inc()
+		}
+		cancel()
+	}
+}
@@ -0,0 +1,57 @@
+package repository
+
+import (
+	"context"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+)
+
+func TestWithTimeoutExpired(t *testing.T) {
+	//given
+	ctx, done := withTimeout(context.Background(), time.Millisecond, "expired")
+
+	//when
+	<-ctx.Done()
+	done()
+
+	//then
+	require.Equal(t, float64(1), testutil.ToFloat64(timeoutCounter.WithLabelValues("expired")))
+	require.Equal(t, uint64(1), observations(t, "expired"))
+}
+
+func TestWithTimeoutCompleted(t *testing.T) {
+	//given
+	ctx, done := withTimeout(context.Background(), time.Minute, "completed")
+
+	//when
+	done()
+
+	//then cancelled by done, not by timeout
+	require.Error(t, ctx.Err())
+	require.Equal(t, float64(0), testutil.ToFloat64(timeoutCounter.WithLabelValues("completed")))
+	require.Equal(t, uint64(1), observations(t, "completed"))
+}
+
+func TestWithTimeoutParentDeadline(t *testing.T) {
+	//given
+	parent, cancel := context.WithTimeout(context.Background(), time.Millisecond)
+	defer cancel()
+	ctx, done := withTimeout(parent, time.Minute, "parent")
+
+	//when
+	<-ctx.Done()
+	done()
+
+	//then caller's deadline isn't counted as timeout of the operation
+	require.Equal(t, float64(0), testutil.ToFloat64(timeoutCounter.WithLabelValues("parent")))
+}
+
+func observations(t *testing.T, operation string) uint64 {
+	metric := new(dto.Metric)
+	require.NoError(t, queryDuration.WithLabelValues(operation).(prometheus.Metric).Write(metric))
+	return metric.GetHistogram().GetSampleCount()
+}
@@ -0,0 +1,79 @@
+package repository
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+)
+
+func (suite *UserSuite) TestMigrationsDownAndUp() {
+	//given
+	ctx := context.Background()
+	latest, err := suite.migrator.Version(ctx)
+	require.NoError(suite.T(), err)
+
+	//when
+	reverted, err := suite.migrator.Down(ctx, int(latest))
+
+	//then
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), reverted, int(latest))
+	version, err := suite.migrator.Version(ctx)
+	require.NoError(suite.T(), err)
+	require.Zero(suite.T(), version)
+	_, err = suite.database.Exec(ctx, "SELECT 1 FROM public.user")
+	require.Error(suite.T(), err)
+
+	//when
+	applied, err := suite.migrator.Up(ctx)
+
+	//then
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), reverted[0], applied[len(applied)-1])
+	applied, err = suite.migrator.Up(ctx)
+	require.NoError(suite.T(), err)
+	require.Empty(suite.T(), applied)
+}
+
+func (suite *UserSuite) TestMigrationsConcurrentUp() {
+	//given
+	ctx := context.Background()
+	results := make(chan error, 3)
+
+	//when replicas start at once, advisory lock serializes them
+	for i := 0; i < 3; i++ {
+		go func() {
+			_, err := suite.migrator.Up(ctx)
+			results <- err
+		}()
+	}
+
+	//then
+	for i := 0; i < 3; i++ {
+		require.NoError(suite.T(), <-results)
+	}
+}
+
+func (suite *UserSuite) TestMigrationsUpOnSchemaCreatedBeforeMigrations() {
+	//given schema of former docker/schema.sql without any applied migration
+	ctx := context.Background()
+	latest, err := suite.migrator.Version(ctx)
+	require.NoError(suite.T(), err)
+	_, err = suite.migrator.Down(ctx, int(latest))
+	require.NoError(suite.T(), err)
+	_, err = suite.database.Exec(ctx, `CREATE TABLE "user" (id uuid PRIMARY KEY, email VARCHAR(255) NOT NULL);
+CREATE TABLE api_key (id uuid PRIMARY KEY, key_hash BYTEA NOT NULL, owner VARCHAR(255) NOT NULL, scopes TEXT[] NOT NULL DEFAULT '{}',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(), expires_at TIMESTAMPTZ, revoked_at TIMESTAMPTZ, CONSTRAINT api_key_hash_key UNIQUE (key_hash))`)
+	require.NoError(suite.T(), err)
+
+	//when
+	applied, err := suite.migrator.Up(ctx)
+
+	//then missing column and constraint are added
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), applied, int(latest))
+	saved, err := suite.userRepository.Save(ctx, &testUser)
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), 1, saved.Version)
+	_, err = suite.userRepository.Save(ctx, &testUser)
+	require.ErrorIs(suite.T(), err, ErrUserAlreadyExists)
+}
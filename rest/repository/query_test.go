@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/model"
+	"testing"
+)
+
+const cursorUserID = "5b1a0d5e-3c4f-4a8e-9a55-2f0f3f1c6d11"
+
+func TestSelectUsersPage(t *testing.T) {
+	emailCursor := encodeCursor("-email", &model.User{ID: cursorUserID, Email: "a@example.org"})
+	idCursor := encodeCursor("", &model.User{ID: cursorUserID, Email: "a@example.org"})
+
+	tests := []struct {
+		query        *model.UserQuery
+		expectedSQL  string
+		expectedArgs []any
+	}{
+		{
+			&model.UserQuery{},
+			"SELECT id, email, version, created_at, updated_at, deleted_at FROM public.user WHERE deleted_at IS NULL ORDER BY id ASC LIMIT $1",
+			[]any{model.DefaultPageSize + 1},
+		},
+		{
+			&model.UserQuery{Limit: 5, Cursor: idCursor},
+			"SELECT id, email, version, created_at, updated_at, deleted_at FROM public.user WHERE deleted_at IS NULL AND id > $1 ORDER BY id ASC LIMIT $2",
+			[]any{cursorUserID, 6},
+		},
+		{
+			&model.UserQuery{Limit: 5, Sort: "-email", Cursor: emailCursor, EmailPrefix: "a%", Domain: "example.org"},
+			`SELECT id, email, version, created_at, updated_at, deleted_at FROM public.user WHERE deleted_at IS NULL AND email LIKE $1 ESCAPE '\' AND email ILIKE $2 ESCAPE '\' AND (email, id) < ($3, $4) ORDER BY email DESC, id DESC LIMIT $5`,
+			[]any{`a\%%`, "%@example.org", "a@example.org", cursorUserID, 6},
+		},
+	}
+	for _, test := range tests {
+		//when
+		sql, args, err := selectUsersPage(test.query)
+
+		//then
+		require.NoError(t, err)
+		require.Equal(t, test.expectedSQL, sql)
+		require.Equal(t, test.expectedArgs, args)
+	}
+}
+
+func TestSelectUsersPageInvalidCursor(t *testing.T) {
+	tests := []struct {
+		query *model.UserQuery
+	}{
+		{&model.UserQuery{Cursor: "not base64!"}},
+		{&model.UserQuery{Cursor: "bm90IGpzb24"}},
+		//cursor issued for different sort order
+		{&model.UserQuery{Sort: "email", Cursor: encodeCursor("-email", &model.User{ID: cursorUserID})}},
+		//well-formed cursor with id that isn't uuid, it would fail in postgres
+		{&model.UserQuery{Cursor: encodeCursor("", &model.User{ID: "id"})}},
+	}
+	for _, test := range tests {
+		//when
+		_, _, err := selectUsersPage(test.query)
+
+		//then
+		require.ErrorIs(t, err, ErrInvalidCursor)
+	}
+}
+
+func TestPatchUser(t *testing.T) {
+	//given
+	email := "new@example.org"
+
+	//when
+	sql, args := patchUser("id", &model.UserPatch{Email: &email}, 2)
+
+	//then
+	require.Equal(t, "UPDATE public.user SET email = $1, version = version + 1, updated_at = now() WHERE id = $2 AND deleted_at IS NULL AND ($3 = 0 OR version = $3) RETURNING id, email, version, created_at, updated_at, deleted_at", sql)
+	require.Equal(t, []any{email, "id", 2}, args)
+}
@@ -0,0 +1,75 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/database"
+	"go-examples/rest/model"
+)
+
+func (suite *UserSuite) TestRepositoryMethodsJoinTransaction() {
+	//given
+	abort := errors.New("abort")
+	var saved *model.User
+
+	//when
+	err := database.WithTx(context.Background(), suite.database, pgx.TxOptions{}, func(ctx context.Context, _ pgx.Tx) error {
+		var err error
+		saved, err = suite.userRepository.Save(ctx, &testUser)
+		require.NoError(suite.T(), err)
+		_, err = suite.userRepository.Update(ctx, saved.ID, &model.PostUser{Email: "other@example.org"}, AnyVersion)
+		require.NoError(suite.T(), err)
+		//visible within the transaction only
+		exists, err := suite.userRepository.Exists(ctx, saved.ID)
+		require.NoError(suite.T(), err)
+		require.True(suite.T(), exists)
+		return abort
+	})
+
+	//then
+	require.ErrorIs(suite.T(), err, abort)
+	exists, err := suite.userRepository.Exists(context.Background(), saved.ID)
+	require.NoError(suite.T(), err)
+	require.False(suite.T(), exists)
+	page, err := suite.userRepository.GetUserAudit(context.Background(), saved.ID, &model.AuditQuery{})
+	require.NoError(suite.T(), err)
+	require.Empty(suite.T(), page.Entries)
+}
+
+func (suite *UserSuite) TestSerializableTransactionRetried() {
+	//given
+	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
+	//pool has single connection held by the transaction, concurrent change is made through another one
+	conf := suite.dbConfig
+	other, err := pgx.Connect(context.Background(), fmt.Sprintf("postgres://%s:%s@%s:%d/%s", conf.User, conf.Password, conf.Host, conf.Port, conf.Database))
+	require.NoError(suite.T(), err)
+	defer other.Close(context.Background())
+	attempts := 0
+
+	//when
+	err = database.WithTx(context.Background(), suite.database, pgx.TxOptions{IsoLevel: pgx.Serializable}, func(ctx context.Context, _ pgx.Tx) error {
+		attempts++
+		user, err := suite.userRepository.GetUserById(ctx, saved.ID)
+		if err != nil {
+			return err
+		}
+		if attempts == 1 {
+			//commits after transaction snapshot was taken, so the update below can't be serialized
+			if _, err := other.Exec(context.Background(), "UPDATE public.user SET version = version + 1 WHERE id = $1", saved.ID); err != nil {
+				return err
+			}
+		}
+		_, err = suite.userRepository.Update(ctx, saved.ID, &testUser, user.Version)
+		return err
+	})
+
+	//then
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), 2, attempts)
+	user, err := suite.userRepository.GetUserById(context.Background(), saved.ID)
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), saved.Version+2, user.Version)
+}
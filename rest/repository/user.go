@@ -3,22 +3,47 @@ package repository
 import (
 	"context"
 	"errors"
+	"fmt"
 	"github.com/google/uuid"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"go-examples/rest/config"
 	"go-examples/rest/database"
 	"go-examples/rest/model"
+	"io"
+	"strings"
+	"time"
 )
 
+// userColumns selected into model.User by scanUser, in that order
+const userColumns = "id, email, version, created_at, updated_at, deleted_at"
+
+// deleted users are kept with deleted_at set until purged, every statement except restore and purge skips them,
+// purge is defined in audit.go as it writes audit entries as well
 var (
-	selectAllUsers = "SELECT * FROM public.user"
-	selectUserById = "SELECT * FROM public.user WHERE id = $1"
-	insertUser     = "INSERT INTO public.user (id, email) VALUES ($1, $2)"
-	updateUser     = "UPDATE public.user SET email = $1 WHERE id = $2"
-	deleteUser     = "DELETE FROM public.user WHERE id = $1"
+	selectUsers    = "SELECT " + userColumns + " FROM public.user"
+	selectUserById = "SELECT " + userColumns + " FROM public.user WHERE id = $1 AND deleted_at IS NULL"
+	insertUser     = "INSERT INTO public.user (id, email) VALUES ($1, $2) RETURNING " + userColumns
+	updateUser     = "UPDATE public.user SET email = $1, version = version + 1, updated_at = now() WHERE id = $2 AND deleted_at IS NULL AND ($3 = 0 OR version = $3) RETURNING " + userColumns
+	deleteUser     = "UPDATE public.user SET deleted_at = now(), updated_at = now(), version = version + 1 WHERE id = $1 AND deleted_at IS NULL AND ($2 = 0 OR version = $2) RETURNING " + userColumns
+	restoreUser    = "UPDATE public.user SET deleted_at = NULL, updated_at = now(), version = version + 1 WHERE id = $1 AND deleted_at IS NOT NULL RETURNING " + userColumns
+	//changes lock the row first so audit entry records the state they were applied to
+	selectUserForUpdate        = "SELECT " + userColumns + " FROM public.user WHERE id = $1 AND deleted_at IS NULL FOR UPDATE"
+	selectDeletedUserForUpdate = "SELECT " + userColumns + " FROM public.user WHERE id = $1 AND deleted_at IS NOT NULL FOR UPDATE"
+	exportUsers                = "SELECT " + userColumns + " FROM public.user WHERE deleted_at IS NULL ORDER BY id"
 )
 
-var ErrUserNotFound = errors.New("user not found")
+// AnyVersion passed as expected version skips optimistic concurrency check
+const AnyVersion = 0
+
+// uniqueViolation SQLSTATE returned by postgres when unique constraint is violated
+const uniqueViolation = "23505"
+
+var (
+	ErrUserNotFound      = errors.New("user not found")
+	ErrUserAlreadyExists = errors.New("user with given email already exists")
+	ErrVersionMismatch   = errors.New("user version mismatch")
+)
 
 type UserRepository struct {
 	database database.Database
@@ -32,32 +57,55 @@ func NewUserRepository(database database.Database, config *config.DBConfig) *Use
 	}
 }
 
-func (repository *UserRepository) GetAllUsers(ctx context.Context) ([]*model.User, error) {
-	timeoutCtx, cancel := context.WithTimeout(ctx, repository.config.Timeout)
-	defer cancel()
-	rows, err := repository.database.Query(timeoutCtx, selectAllUsers)
+// GetAllUsers streams single page of users matching the query to consume, rows are not buffered.
+// Returns cursor pointing to the next page or empty string when there are no more users.
+func (repository *UserRepository) GetAllUsers(ctx context.Context, query *model.UserQuery, consume func(*model.User) error) (string, error) {
+	sql, args, err := selectUsersPage(query)
 	if err != nil {
-		return nil, err
+		return "", err
+	}
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "GetAllUsers")
+	defer done()
+	rows, err := repository.reader(timeoutCtx).Query(timeoutCtx, sql, args...)
+	if err != nil {
+		return "", err
 	}
 	defer rows.Close()
-	var users []*model.User
+	var last *model.User
+	read := 0
 	for rows.Next() {
-		user := new(model.User)
-		err := rows.Scan(&user.ID, &user.Email)
+		user, err := scanUser(rows)
 		if err != nil {
-			return nil, err
+			return "", err
+		}
+		//one extra row is selected only to find out whether next page exists
+		if read++; read > pageLimit(query) {
+			return encodeCursor(query.Sort, last), nil
+		}
+		if err := consume(user); err != nil {
+			return "", err
 		}
-		users = append(users, user)
+		last = user
 	}
-	return users, nil
+	return "", rows.Err()
 }
 
 func (repository *UserRepository) GetUserById(ctx context.Context, id string) (*model.User, error) {
-	timeoutCtx, cancel := context.WithTimeout(ctx, repository.config.Timeout)
-	defer cancel()
-	row := repository.database.QueryRow(timeoutCtx, selectUserById, id)
-	user := new(model.User)
-	err := row.Scan(&user.ID, &user.Email)
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "GetUserById")
+	defer done()
+	return getUserById(timeoutCtx, repository.reader(timeoutCtx), id)
+}
+
+// GetLatestUserById reads user on the primary, so its version is the latest one even when replicas lag behind.
+// Meant for reads whose version is checked on update, lagging replica would fail such update with version mismatch.
+func (repository *UserRepository) GetLatestUserById(ctx context.Context, id string) (*model.User, error) {
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "GetLatestUserById")
+	defer done()
+	return getUserById(timeoutCtx, database.Primary(timeoutCtx, repository.database), id)
+}
+
+func getUserById(ctx context.Context, querier database.Querier, id string) (*model.User, error) {
+	user, err := scanUser(querier.QueryRow(ctx, selectUserById, id))
 	if err != nil {
 		if errors.Is(err, pgx.ErrNoRows) {
 			return nil, ErrUserNotFound
@@ -67,36 +115,51 @@ func (repository *UserRepository) GetUserById(ctx context.Context, id string) (*
 	return user, nil
 }
 
+// Save inserts user together with its audit entry
 func (repository *UserRepository) Save(ctx context.Context, postUser *model.PostUser) (*model.User, error) {
-	timeoutCtx, cancel := context.WithTimeout(ctx, repository.config.Timeout)
-	defer cancel()
-	id := uuid.New().String()
-	_, err := repository.database.Exec(timeoutCtx, insertUser, id, postUser.Email)
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "Save")
+	defer done()
+	var user *model.User
+	err := database.WithTx(timeoutCtx, repository.database, pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		var err error
+		user, err = scanUser(tx.QueryRow(ctx, insertUser, uuid.New().String(), postUser.Email))
+		if err != nil {
+			return mapUniqueViolation(err)
+		}
+		return audit(ctx, tx, model.AuditActionCreate, nil, user)
+	})
 	if err != nil {
 		return nil, err
 	}
-	return &model.User{
-		ID:    id,
-		Email: postUser.Email,
-	}, nil
+	return user, nil
 }
 
-func (repository *UserRepository) Update(ctx context.Context, id string, user *model.PostUser) (*model.User, error) {
-	timeoutCtx, cancel := context.WithTimeout(ctx, repository.config.Timeout)
-	defer cancel()
-	_, err := repository.database.Exec(timeoutCtx, updateUser, user.Email, id)
-	if err != nil {
-		return nil, err
+// Update changes user only when its current version equals expected one (or AnyVersion is passed), version is incremented.
+// Returns ErrUserNotFound when user doesn't exist (or was deleted concurrently), ErrVersionMismatch when version differs.
+func (repository *UserRepository) Update(ctx context.Context, id string, user *model.PostUser, version int) (*model.User, error) {
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "Update")
+	defer done()
+	return repository.change(timeoutCtx, selectUserForUpdate, id, version, model.AuditActionUpdate, func(ctx context.Context, tx pgx.Tx) pgx.Row {
+		return tx.QueryRow(ctx, updateUser, user.Email, id, version)
+	})
+}
+
+// Patch updates only columns present in the patch, same version semantics as Update apply.
+func (repository *UserRepository) Patch(ctx context.Context, id string, patch *model.UserPatch, version int) (*model.User, error) {
+	if patch.Empty() {
+		return nil, errors.New("patch has no changes")
 	}
-	return &model.User{
-		ID:    id,
-		Email: user.Email,
-	}, nil
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "Patch")
+	defer done()
+	sql, args := patchUser(id, patch, version)
+	return repository.change(timeoutCtx, selectUserForUpdate, id, version, model.AuditActionUpdate, func(ctx context.Context, tx pgx.Tx) pgx.Row {
+		return tx.QueryRow(ctx, sql, args...)
+	})
 }
 
 func (repository *UserRepository) Exists(ctx context.Context, id string) (bool, error) {
-	timeoutCtx, cancel := context.WithTimeout(ctx, repository.config.Timeout)
-	defer cancel()
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "Exists")
+	defer done()
 	_, err := repository.GetUserById(timeoutCtx, id)
 	if err != nil {
 		if errors.Is(err, ErrUserNotFound) {
@@ -107,12 +170,237 @@ func (repository *UserRepository) Exists(ctx context.Context, id string) (bool,
 	return true, nil
 }
 
-func (repository *UserRepository) Delete(ctx context.Context, id string) error {
-	timeoutCtx, cancel := context.WithTimeout(ctx, repository.config.Timeout)
-	defer cancel()
-	_, err := repository.database.Exec(timeoutCtx, deleteUser, id)
+// Delete marks user as deleted when its current version equals expected one (or AnyVersion is passed), version is incremented.
+// Returns ErrUserNotFound when there was nothing to delete, ErrVersionMismatch when version differs.
+func (repository *UserRepository) Delete(ctx context.Context, id string, version int) error {
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "Delete")
+	defer done()
+	_, err := repository.change(timeoutCtx, selectUserForUpdate, id, version, model.AuditActionDelete, func(ctx context.Context, tx pgx.Tx) pgx.Row {
+		return tx.QueryRow(ctx, deleteUser, id, version)
+	})
+	return err
+}
+
+// Restore brings back deleted user, version is incremented.
+// Returns ErrUserNotFound when there is no deleted user with the id, ErrUserAlreadyExists when its email was taken meanwhile.
+func (repository *UserRepository) Restore(ctx context.Context, id string) (*model.User, error) {
+	timeoutCtx, done := withTimeout(ctx, repository.config.Timeout, "Restore")
+	defer done()
+	return repository.change(timeoutCtx, selectDeletedUserForUpdate, id, AnyVersion, model.AuditActionRestore, func(ctx context.Context, tx pgx.Tx) pgx.Row {
+		return tx.QueryRow(ctx, restoreUser, id)
+	})
+}
+
+// Purge removes users deleted longer than retention ago for good, returns number of users removed.
+// Each removed user gets audit entry holding its last state.
+func (repository *UserRepository) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	timeoutCtx, done := withTimeout(ctx, repository.config.BulkTimeout, "Purge")
+	defer done()
+	tag, err := repository.conn(timeoutCtx).Exec(timeoutCtx, purgeUsers, retention.Seconds(), actor(timeoutCtx), model.RequestIDFrom(timeoutCtx))
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
+// conn runs statements within transaction carried by ctx, so repository methods compose within database.WithTx
+func (repository *UserRepository) conn(ctx context.Context) database.Querier {
+	return database.Conn(ctx, repository.database)
+}
+
+// reader runs read-only statements on replica unless ctx carries transaction or request already wrote
+func (repository *UserRepository) reader(ctx context.Context) database.Querier {
+	return database.Reader(ctx, repository.database)
+}
+
+// change locks user selected by lock, checks expected version and applies update returning changed user.
+// Update and its audit entry are committed together, nothing is changed when either fails.
+func (repository *UserRepository) change(ctx context.Context, lock string, id string, version int, action string, update func(context.Context, pgx.Tx) pgx.Row) (*model.User, error) {
+	var changed *model.User
+	err := database.WithTx(ctx, repository.database, pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		before, err := scanUser(tx.QueryRow(ctx, lock, id))
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return ErrUserNotFound
+			}
+			return err
+		}
+		if version != AnyVersion && version != before.Version {
+			return ErrVersionMismatch
+		}
+		changed, err = scanUser(update(ctx, tx))
+		if err != nil {
+			return mapUniqueViolation(err)
+		}
+		return audit(ctx, tx, action, before, changed)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return changed, nil
+}
+
+// Import copies users returned by next into the table using COPY protocol, next signals the end with io.EOF.
+// Runs in single transaction together with audit entries - nothing is imported when next fails or any row violates constraints.
+func (repository *UserRepository) Import(ctx context.Context, next func() (*model.PostUser, error)) (int64, error) {
+	timeoutCtx, done := withTimeout(ctx, repository.config.BulkTimeout, "Import")
+	defer done()
+	source := &userSource{next: next}
+	var imported int64
+	//read committed transaction is never retried, next can't be read again
+	err := database.WithTx(timeoutCtx, repository.database, pgx.TxOptions{}, func(ctx context.Context, tx pgx.Tx) error {
+		var err error
+		imported, err = tx.CopyFrom(ctx, pgx.Identifier{"public", "user"}, []string{"id", "email"}, source)
+		//when next fails copy is cancelled and postgres error is returned instead of the original one
+		if source.err != nil {
+			return source.err
+		}
+		if err != nil {
+			return mapUniqueViolation(err)
+		}
+		_, err = tx.Exec(ctx, auditImported, actor(ctx), model.RequestIDFrom(ctx), source.ids)
+		return err
+	})
+	if err != nil {
+		return 0, err
+	}
+	return imported, nil
+}
+
+// ExportUsers streams all users to consume without buffering them, ordered by id.
+func (repository *UserRepository) ExportUsers(ctx context.Context, consume func(*model.User) error) error {
+	timeoutCtx, done := withTimeout(ctx, repository.config.BulkTimeout, "ExportUsers")
+	defer done()
+	rows, err := repository.reader(timeoutCtx).Query(timeoutCtx, exportUsers)
 	if err != nil {
 		return err
 	}
-	return nil
+	defer rows.Close()
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return err
+		}
+		if err := consume(user); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// userSource adapts next func to pgx.CopyFromSource, ids are generated the same way Save does
+type userSource struct {
+	next   func() (*model.PostUser, error)
+	values []any
+	err    error
+	//ids of copied users, audited after copy
+	ids []string
+}
+
+func (source *userSource) Next() bool {
+	user, err := source.next()
+	if err != nil {
+		if !errors.Is(err, io.EOF) {
+			source.err = err
+		}
+		return false
+	}
+	id := uuid.New().String()
+	source.ids = append(source.ids, id)
+	source.values = []any{id, user.Email}
+	return true
+}
+
+func (source *userSource) Values() ([]any, error) {
+	return source.values, nil
+}
+
+func (source *userSource) Err() error {
+	return source.err
+}
+
+func scanUser(row pgx.Row) (*model.User, error) {
+	user := new(model.User)
+	err := row.Scan(&user.ID, &user.Email, &user.Version, &user.CreatedAt, &user.UpdatedAt, &user.DeletedAt)
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+func mapUniqueViolation(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == uniqueViolation {
+		return ErrUserAlreadyExists
+	}
+	return err
+}
+
+func patchUser(id string, patch *model.UserPatch, version int) (string, []any) {
+	var assignments []string
+	var args []any
+	if patch.Email != nil {
+		args = append(args, *patch.Email)
+		assignments = append(assignments, fmt.Sprintf("email = $%d", len(args)))
+	}
+	args = append(args, id, version)
+	return fmt.Sprintf("UPDATE public.user SET %s, version = version + 1, updated_at = now() WHERE id = $%d AND deleted_at IS NULL AND ($%d = 0 OR version = $%d) RETURNING %s",
+		strings.Join(assignments, ", "), len(args)-1, len(args), len(args), userColumns), args
+}
+
+func pageLimit(query *model.UserQuery) int {
+	if query.Limit <= 0 || query.Limit > model.MaxPageSize {
+		return model.DefaultPageSize
+	}
+	return query.Limit
+}
+
+func sortColumn(sort string) string {
+	column := strings.TrimPrefix(sort, "-")
+	if column != "email" {
+		return "id"
+	}
+	return column
+}
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// selectUsersPage builds keyset pagination query, id is always used as a tiebreaker so ordering is total
+func selectUsersPage(query *model.UserQuery) (string, []any, error) {
+	conditions := []string{"deleted_at IS NULL"}
+	var args []any
+	arg := func(value any) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", len(args))
+	}
+	if query.EmailPrefix != "" {
+		conditions = append(conditions, fmt.Sprintf(`email LIKE %s ESCAPE '\'`, arg(likeEscaper.Replace(query.EmailPrefix)+"%")))
+	}
+	if query.Domain != "" {
+		conditions = append(conditions, fmt.Sprintf(`email ILIKE %s ESCAPE '\'`, arg("%@"+likeEscaper.Replace(query.Domain))))
+	}
+	column := sortColumn(query.Sort)
+	direction, comparison := "ASC", ">"
+	if strings.HasPrefix(query.Sort, "-") {
+		direction, comparison = "DESC", "<"
+	}
+	if query.Cursor != "" {
+		after, err := decodeCursor(query.Sort, query.Cursor)
+		if err != nil {
+			return "", nil, err
+		}
+		if column == "email" {
+			conditions = append(conditions, fmt.Sprintf("(email, id) %s (%s, %s)", comparison, arg(after.Email), arg(after.ID)))
+		} else {
+			conditions = append(conditions, fmt.Sprintf("id %s %s", comparison, arg(after.ID)))
+		}
+	}
+	sql := selectUsers + " WHERE " + strings.Join(conditions, " AND ")
+	if column == "email" {
+		sql += fmt.Sprintf(" ORDER BY email %s, id %s", direction, direction)
+	} else {
+		sql += fmt.Sprintf(" ORDER BY id %s", direction)
+	}
+	sql += fmt.Sprintf(" LIMIT %s", arg(pageLimit(query)+1))
+	return sql, args, nil
 }
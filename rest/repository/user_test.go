@@ -4,6 +4,8 @@ import (
 	"context"
 	"fmt"
 	"github.com/Shopify/toxiproxy/client"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
 	"github.com/stretchr/testify/require"
 	"github.com/stretchr/testify/suite"
 	"github.com/testcontainers/testcontainers-go"
@@ -12,7 +14,9 @@ import (
 	"github.com/testcontainers/testcontainers-go/wait"
 	"go-examples/rest/config"
 	"go-examples/rest/database"
+	"go-examples/rest/migration"
 	"go-examples/rest/model"
+	"io"
 	"testing"
 	"time"
 )
@@ -27,6 +31,8 @@ type UserSuite struct {
 	userRepository     *UserRepository
 	closeDb            func()
 	database           database.Database
+	migrator           *migration.Migrator
+	dbConfig           config.DBConfig
 	postgresContainer  *postgres.PostgresContainer
 	toxiproxyContainer testcontainers.Container
 	postgresProxy      *toxiproxy.Proxy
@@ -60,6 +66,16 @@ func (suite *UserSuite) SetupSuite() {
 	if err != nil {
 		suite.T().Fatal(err)
 	}
+	//schema is created the same way application does it
+	migrator, err := migration.New(db)
+	if err != nil {
+		suite.T().Fatal(err)
+	}
+	if _, err := migrator.Up(context.Background()); err != nil {
+		suite.T().Fatal(err)
+	}
+	suite.migrator = migrator
+	suite.dbConfig = conf
 	suite.database = db
 	suite.closeDb = cancel
 	suite.userRepository = NewUserRepository(db, &conf)
@@ -71,7 +87,6 @@ func SetupPostgresContainer(net *testcontainers.DockerNetwork) (*postgres.Postgr
 		postgres.WithUsername("postgres"),
 		postgres.WithPassword("postgres"),
 		postgres.WithDatabase("postgres"),
-		postgres.WithInitScripts("../docker/schema.sql"),
 		postgres.BasicWaitStrategies(),
 		network.WithNetwork([]string{"postgres"}, net),
 	)
@@ -118,14 +133,15 @@ func CreateDBProperties(toxiCt testcontainers.Container) (config.DBConfig, error
 		return config.DBConfig{}, err
 	}
 	return config.DBConfig{
-		User:     "postgres",
-		Password: "postgres",
-		Host:     host,
-		Port:     port.Int(),
-		Database: "postgres",
-		Timeout:  timeout,
-		PoolMin:  1,
-		PoolMax:  1,
+		User:        "postgres",
+		Password:    "postgres",
+		Host:        host,
+		Port:        port.Int(),
+		Database:    "postgres",
+		Timeout:     timeout,
+		BulkTimeout: time.Minute,
+		PoolMin:     1,
+		PoolMax:     1,
 	}, nil
 }
 
@@ -136,7 +152,7 @@ func (suite *UserSuite) TearDownSuite() {
 }
 
 func (suite *UserSuite) TearDownTest() {
-	_, err := suite.userRepository.database.Exec(context.Background(), "TRUNCATE public.user")
+	_, err := suite.userRepository.database.Exec(context.Background(), "TRUNCATE public.user, public.api_key, public.user_audit")
 	if err != nil {
 		suite.T().Fatal(err)
 	}
@@ -158,21 +174,115 @@ func (suite *UserSuite) TestSaveUser() {
 	require.Equal(suite.T(), saved.Email, get.Email)
 }
 
+func (suite *UserSuite) TestSaveUserAlreadyExists() {
+	//given
+	_, _ = suite.userRepository.Save(context.Background(), &testUser)
+
+	//when
+	_, err := suite.userRepository.Save(context.Background(), &testUser)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrUserAlreadyExists)
+}
+
 func (suite *UserSuite) TestGetAllUsers() {
 	//given
 	saved1, _ := suite.userRepository.Save(context.Background(), &testUser)
-	saved2, _ := suite.userRepository.Save(context.Background(), &testUser)
+	saved2, _ := suite.userRepository.Save(context.Background(), &model.PostUser{Email: "other@example.org"})
 
 	//when
-	users, err := suite.userRepository.GetAllUsers(context.Background())
+	users, next, err := suite.getAllUsers(&model.UserQuery{})
 
 	//then
 	require.NoError(suite.T(), err)
+	require.Empty(suite.T(), next)
 	require.Len(suite.T(), users, 2)
 	require.Contains(suite.T(), users, saved1)
 	require.Contains(suite.T(), users, saved2)
 }
 
+func (suite *UserSuite) TestGetAllUsersPagination() {
+	//given
+	for _, email := range []string{"c@example.org", "a@example.org", "b@example.org"} {
+		_, err := suite.userRepository.Save(context.Background(), &model.PostUser{Email: email})
+		require.NoError(suite.T(), err)
+	}
+
+	cases := []struct {
+		sort     string
+		expected []string
+	}{
+		{"email", []string{"a@example.org", "b@example.org", "c@example.org"}},
+		{"-email", []string{"c@example.org", "b@example.org", "a@example.org"}},
+	}
+	for _, c := range cases {
+		//when
+		var emails []string
+		query := &model.UserQuery{Limit: 2, Sort: c.sort}
+		for {
+			users, next, err := suite.getAllUsers(query)
+			require.NoError(suite.T(), err)
+			for _, user := range users {
+				emails = append(emails, user.Email)
+			}
+			if next == "" {
+				break
+			}
+			query.Cursor = next
+		}
+
+		//then
+		require.Equal(suite.T(), c.expected, emails, c.sort)
+	}
+}
+
+func (suite *UserSuite) TestGetAllUsersFiltering() {
+	//given
+	for _, email := range []string{"john@example.org", "jane@example.org", "john@gmail.com", "j_hn@gmail.com"} {
+		_, err := suite.userRepository.Save(context.Background(), &model.PostUser{Email: email})
+		require.NoError(suite.T(), err)
+	}
+
+	cases := []struct {
+		query    *model.UserQuery
+		expected []string
+	}{
+		{&model.UserQuery{EmailPrefix: "john", Sort: "email"}, []string{"john@example.org", "john@gmail.com"}},
+		{&model.UserQuery{Domain: "GMAIL.com", Sort: "email"}, []string{"j_hn@gmail.com", "john@gmail.com"}},
+		{&model.UserQuery{EmailPrefix: "jo", Domain: "example.org"}, []string{"john@example.org"}},
+		{&model.UserQuery{EmailPrefix: "j_"}, []string{"j_hn@gmail.com"}},
+	}
+	for _, c := range cases {
+		//when
+		users, _, err := suite.getAllUsers(c.query)
+
+		//then
+		require.NoError(suite.T(), err)
+		var emails []string
+		for _, user := range users {
+			emails = append(emails, user.Email)
+		}
+		require.Equal(suite.T(), c.expected, emails)
+	}
+}
+
+func (suite *UserSuite) TestGetAllUsersInvalidCursor() {
+	//when
+	_, _, err := suite.getAllUsers(&model.UserQuery{Cursor: encodeCursor("email", &model.User{ID: "1"})})
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrInvalidCursor)
+}
+
+func (suite *UserSuite) getAllUsers(query *model.UserQuery) ([]*model.User, string, error) {
+	var users []*model.User
+	next, err := suite.userRepository.GetAllUsers(context.Background(), query, func(user *model.User) error {
+		users = append(users, user)
+		return nil
+	})
+	return users, next, err
+}
+
 func (suite *UserSuite) TestGetUserById() {
 	//given
 	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
@@ -186,6 +296,42 @@ func (suite *UserSuite) TestGetUserById() {
 	require.Equal(suite.T(), saved.Email, get.Email)
 }
 
+func (suite *UserSuite) TestGetLatestUserByIdWithLaggingReplica() {
+	//given replica serving snapshot taken before the update
+	ctx := context.Background()
+	saved, _ := suite.userRepository.Save(ctx, &testUser)
+	snapshot, err := suite.database.BeginTx(ctx, pgx.TxOptions{IsoLevel: pgx.RepeatableRead, AccessMode: pgx.ReadOnly})
+	require.NoError(suite.T(), err)
+	defer snapshot.Rollback(ctx)
+	_, err = snapshot.Exec(ctx, "SELECT 1")
+	require.NoError(suite.T(), err)
+	updated, err := suite.userRepository.Update(ctx, saved.ID, &model.PostUser{Email: "new@gmail.com"}, saved.Version)
+	require.NoError(suite.T(), err)
+	noConnections := func() int32 { return 0 }
+	cluster := database.NewCluster(
+		database.NewNode("primary", suite.database, noConnections),
+		[]*database.Node{database.NewNode("replica", &laggingReplica{Tx: snapshot}, noConnections)},
+		database.RoundRobin)
+	cluster.Check(ctx)
+	userRepository := NewUserRepository(cluster, &suite.dbConfig)
+
+	//when
+	stale, staleErr := userRepository.GetUserById(ctx, saved.ID)
+	latest, err := userRepository.GetLatestUserById(ctx, saved.ID)
+
+	//then
+	require.NoError(suite.T(), staleErr)
+	require.Equal(suite.T(), saved.Version, stale.Version)
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), updated.Version, latest.Version)
+	require.Equal(suite.T(), updated.Email, latest.Email)
+
+	//and version read on the primary is accepted by update
+	email := "latest@gmail.com"
+	_, err = userRepository.Patch(ctx, saved.ID, &model.UserPatch{Email: &email}, latest.Version)
+	require.NoError(suite.T(), err)
+}
+
 func (suite *UserSuite) TestExists() {
 	//given
 	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
@@ -203,7 +349,7 @@ func (suite *UserSuite) TestDelete() {
 	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
 
 	//when
-	err := suite.userRepository.Delete(context.Background(), saved.ID)
+	err := suite.userRepository.Delete(context.Background(), saved.ID, AnyVersion)
 
 	//then
 	require.NoError(suite.T(), err)
@@ -214,6 +360,22 @@ func (suite *UserSuite) TestDelete() {
 	require.False(suite.T(), exists)
 }
 
+func (suite *UserSuite) TestDeleteNotFound() {
+	//when
+	err := suite.userRepository.Delete(context.Background(), uuid.New().String(), AnyVersion)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrUserNotFound)
+}
+
+func (suite *UserSuite) TestUpdateNotFound() {
+	//when
+	_, err := suite.userRepository.Update(context.Background(), uuid.New().String(), &testUser, AnyVersion)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrUserNotFound)
+}
+
 func (suite *UserSuite) TestUpdate() {
 	//given
 	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
@@ -222,12 +384,249 @@ func (suite *UserSuite) TestUpdate() {
 	}
 
 	//when
-	updated, err := suite.userRepository.Update(context.Background(), saved.ID, &updateRq)
+	updated, err := suite.userRepository.Update(context.Background(), saved.ID, &updateRq, saved.Version)
 
 	//then
 	require.NoError(suite.T(), err)
 	require.Equal(suite.T(), saved.ID, updated.ID)
 	require.Equal(suite.T(), updateRq.Email, updated.Email)
+	require.Equal(suite.T(), saved.Version+1, updated.Version)
+}
+
+func (suite *UserSuite) TestPatch() {
+	//given
+	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
+	email := "new@gmail.com"
+
+	//when
+	patched, err := suite.userRepository.Patch(context.Background(), saved.ID, &model.UserPatch{Email: &email}, saved.Version)
+
+	//then
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), email, patched.Email)
+	require.Equal(suite.T(), saved.Version+1, patched.Version)
+}
+
+func (suite *UserSuite) TestPatchVersionMismatch() {
+	//given
+	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
+	email := "new@gmail.com"
+
+	//when
+	_, err := suite.userRepository.Patch(context.Background(), saved.ID, &model.UserPatch{Email: &email}, saved.Version+1)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrVersionMismatch)
+}
+
+func (suite *UserSuite) TestUpdateVersionMismatch() {
+	//given
+	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
+	_, _ = suite.userRepository.Update(context.Background(), saved.ID, &model.PostUser{Email: "new@gmail.com"}, saved.Version)
+
+	//when
+	_, err := suite.userRepository.Update(context.Background(), saved.ID, &model.PostUser{Email: "other@gmail.com"}, saved.Version)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrVersionMismatch)
+}
+
+func (suite *UserSuite) TestDeleteVersionMismatch() {
+	//given
+	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
+
+	//when
+	err := suite.userRepository.Delete(context.Background(), saved.ID, saved.Version+1)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrVersionMismatch)
+}
+
+func (suite *UserSuite) TestDeleteIsSoft() {
+	//given
+	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
+
+	//when
+	err := suite.userRepository.Delete(context.Background(), saved.ID, saved.Version)
+
+	//then
+	require.NoError(suite.T(), err)
+	users, _, err := suite.getAllUsers(&model.UserQuery{})
+	require.NoError(suite.T(), err)
+	require.Empty(suite.T(), users)
+	exported, err := suite.exportUsers()
+	require.NoError(suite.T(), err)
+	require.Empty(suite.T(), exported)
+	_, err = suite.userRepository.Update(context.Background(), saved.ID, &testUser, AnyVersion)
+	require.ErrorIs(suite.T(), err, ErrUserNotFound)
+	err = suite.userRepository.Delete(context.Background(), saved.ID, AnyVersion)
+	require.ErrorIs(suite.T(), err, ErrUserNotFound)
+
+	//and email can be taken again
+	_, err = suite.userRepository.Save(context.Background(), &testUser)
+	require.NoError(suite.T(), err)
+}
+
+func (suite *UserSuite) TestRestore() {
+	//given
+	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
+	_ = suite.userRepository.Delete(context.Background(), saved.ID, AnyVersion)
+
+	//when
+	restored, err := suite.userRepository.Restore(context.Background(), saved.ID)
+
+	//then
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), saved.ID, restored.ID)
+	require.Nil(suite.T(), restored.DeletedAt)
+	require.Equal(suite.T(), saved.Version+2, restored.Version)
+	require.Equal(suite.T(), saved.CreatedAt, restored.CreatedAt)
+	require.True(suite.T(), restored.UpdatedAt.After(saved.UpdatedAt))
+	get, err := suite.userRepository.GetUserById(context.Background(), saved.ID)
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), restored, get)
+}
+
+func (suite *UserSuite) TestRestoreNotDeleted() {
+	//given
+	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
+
+	//when
+	_, err := suite.userRepository.Restore(context.Background(), saved.ID)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrUserNotFound)
+}
+
+func (suite *UserSuite) TestRestoreEmailTaken() {
+	//given
+	saved, _ := suite.userRepository.Save(context.Background(), &testUser)
+	_ = suite.userRepository.Delete(context.Background(), saved.ID, AnyVersion)
+	_, _ = suite.userRepository.Save(context.Background(), &testUser)
+
+	//when
+	_, err := suite.userRepository.Restore(context.Background(), saved.ID)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrUserAlreadyExists)
+}
+
+func (suite *UserSuite) TestPurge() {
+	//given
+	old, _ := suite.userRepository.Save(context.Background(), &testUser)
+	recent, _ := suite.userRepository.Save(context.Background(), &model.PostUser{Email: "other@example.org"})
+	active, _ := suite.userRepository.Save(context.Background(), &model.PostUser{Email: "active@example.org"})
+	_ = suite.userRepository.Delete(context.Background(), old.ID, AnyVersion)
+	_ = suite.userRepository.Delete(context.Background(), recent.ID, AnyVersion)
+	_, err := suite.database.Exec(context.Background(), "UPDATE public.user SET deleted_at = now() - interval '2 hours' WHERE id = $1", old.ID)
+	require.NoError(suite.T(), err)
+
+	//when
+	purged, err := suite.userRepository.Purge(context.Background(), time.Hour)
+
+	//then
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), int64(1), purged)
+	_, err = suite.userRepository.Restore(context.Background(), old.ID)
+	require.ErrorIs(suite.T(), err, ErrUserNotFound)
+	_, err = suite.userRepository.Restore(context.Background(), recent.ID)
+	require.NoError(suite.T(), err)
+	exists, _ := suite.userRepository.Exists(context.Background(), active.ID)
+	require.True(suite.T(), exists)
+}
+
+func (suite *UserSuite) TestUpdateUserAlreadyExists() {
+	//given
+	_, _ = suite.userRepository.Save(context.Background(), &testUser)
+	other, _ := suite.userRepository.Save(context.Background(), &model.PostUser{Email: "other@example.org"})
+
+	//when
+	_, err := suite.userRepository.Update(context.Background(), other.ID, &testUser, AnyVersion)
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrUserAlreadyExists)
+}
+
+func (suite *UserSuite) TestImport() {
+	//given
+	users := []*model.PostUser{{Email: "a@example.org"}, {Email: "b@example.org"}}
+
+	//when
+	imported, err := suite.userRepository.Import(context.Background(), sliceSource(users, nil))
+
+	//then
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), int64(2), imported)
+	exported, err := suite.exportUsers()
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), exported, 2)
+}
+
+func (suite *UserSuite) TestImportAlreadyExists() {
+	//given
+	_, _ = suite.userRepository.Save(context.Background(), &testUser)
+	users := []*model.PostUser{{Email: "a@example.org"}, &testUser}
+
+	//when
+	_, err := suite.userRepository.Import(context.Background(), sliceSource(users, nil))
+
+	//then
+	require.ErrorIs(suite.T(), err, ErrUserAlreadyExists)
+	exported, _ := suite.exportUsers()
+	require.Len(suite.T(), exported, 1)
+}
+
+func (suite *UserSuite) TestImportSourceError() {
+	//given
+	users := []*model.PostUser{{Email: "a@example.org"}}
+	sourceErr := fmt.Errorf("invalid row")
+
+	//when
+	_, err := suite.userRepository.Import(context.Background(), sliceSource(users, sourceErr))
+
+	//then
+	require.ErrorIs(suite.T(), err, sourceErr)
+	exported, _ := suite.exportUsers()
+	require.Empty(suite.T(), exported)
+}
+
+func (suite *UserSuite) TestExportUsers() {
+	//given
+	saved1, _ := suite.userRepository.Save(context.Background(), &testUser)
+	saved2, _ := suite.userRepository.Save(context.Background(), &model.PostUser{Email: "other@example.org"})
+
+	//when
+	exported, err := suite.exportUsers()
+
+	//then
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), exported, 2)
+	require.Contains(suite.T(), exported, saved1)
+	require.Contains(suite.T(), exported, saved2)
+}
+
+func (suite *UserSuite) exportUsers() ([]*model.User, error) {
+	var users []*model.User
+	err := suite.userRepository.ExportUsers(context.Background(), func(user *model.User) error {
+		users = append(users, user)
+		return nil
+	})
+	return users, err
+}
+
+// sliceSource returns users one by one and then err, io.EOF when err is nil
+func sliceSource(users []*model.PostUser, err error) func() (*model.PostUser, error) {
+	return func() (*model.PostUser, error) {
+		if len(users) == 0 {
+			if err != nil {
+				return nil, err
+			}
+			return nil, io.EOF
+		}
+		user := users[0]
+		users = users[1:]
+		return user, nil
+	}
 }
 
 func (suite *UserSuite) TestTimeout() {
@@ -253,7 +652,7 @@ func (suite *UserSuite) TestTimeout() {
 		{
 			operationName: "GetAllUsers",
 			operationF: func() error {
-				_, err := suite.userRepository.GetAllUsers(context.Background())
+				_, _, err := suite.getAllUsers(&model.UserQuery{})
 				return err
 			},
 		},
@@ -264,10 +663,24 @@ func (suite *UserSuite) TestTimeout() {
 				return err
 			},
 		},
+		{
+			operationName: "GetLatestUserById",
+			operationF: func() error {
+				_, err := suite.userRepository.GetLatestUserById(context.Background(), "1")
+				return err
+			},
+		},
 		{
 			operationName: "Delete",
 			operationF: func() error {
-				return suite.userRepository.Delete(context.Background(), "1")
+				return suite.userRepository.Delete(context.Background(), "1", AnyVersion)
+			},
+		},
+		{
+			operationName: "Restore",
+			operationF: func() error {
+				_, err := suite.userRepository.Restore(context.Background(), "1")
+				return err
 			},
 		},
 		{
@@ -280,7 +693,7 @@ func (suite *UserSuite) TestTimeout() {
 		{
 			operationName: "Update",
 			operationF: func() error {
-				_, err := suite.userRepository.Update(context.Background(), "1", &testUser)
+				_, err := suite.userRepository.Update(context.Background(), "1", &testUser, AnyVersion)
 				return err
 			},
 		},
@@ -296,3 +709,16 @@ func (suite *UserSuite) TestTimeout() {
 	}
 	_ = suite.postgresProxy.RemoveToxic("postgres")
 }
+
+// laggingReplica serves reads from transaction snapshot, so it doesn't see changes committed after the snapshot was taken
+type laggingReplica struct {
+	pgx.Tx
+}
+
+func (replica *laggingReplica) Ping(context.Context) error {
+	return nil
+}
+
+func (replica *laggingReplica) BeginTx(ctx context.Context, _ pgx.TxOptions) (pgx.Tx, error) {
+	return replica.Begin(ctx)
+}
@@ -0,0 +1,32 @@
+package server
+
+import (
+	"cmp"
+	"fmt"
+	"go-examples/rest/config"
+	"net/http"
+	"time"
+)
+
+const (
+	defaultReadHeaderTimeout = 5 * time.Second
+	defaultShutdownTimeout   = 5 * time.Second
+)
+
+// New applies timeouts and header limit of config to server of handler, body limits are applied by middleware.BodyLimit
+func New(config *config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", config.Host, config.Port),
+		Handler:           handler,
+		ReadHeaderTimeout: cmp.Or(config.ReadHeaderTimeout, defaultReadHeaderTimeout),
+		ReadTimeout:       config.ReadTimeout,
+		WriteTimeout:      config.WriteTimeout,
+		IdleTimeout:       config.IdleTimeout,
+		MaxHeaderBytes:    config.MaxHeaderBytes,
+	}
+}
+
+// ShutdownTimeout how long in-flight requests are waited for on shutdown
+func ShutdownTimeout(config *config.ServerConfig) time.Duration {
+	return cmp.Or(config.ShutdownTimeout, defaultShutdownTimeout)
+}
@@ -0,0 +1,163 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"go-examples/rest/config"
+	"log/slog"
+	"os"
+	"slices"
+	"sync/atomic"
+	"time"
+)
+
+const defaultReloadInterval = time.Minute
+
+var tlsVersions = map[string]uint16{
+	"":    tls.VersionTLS12,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var clientAuthTypes = map[string]tls.ClientAuthType{
+	"":         tls.NoClientCert,
+	"none":     tls.NoClientCert,
+	"optional": tls.VerifyClientCertIfGiven,
+	"require":  tls.RequireAndVerifyClientCert,
+}
+
+// Certificates serves certificate and client CA bundle read from files. Files are checked for changes periodically,
+// new connections get rotated certificate without restart while established ones keep theirs.
+type Certificates struct {
+	config  *config.TLSConfig
+	current atomic.Pointer[tls.Config]
+	//modification times of files current config was loaded from
+	loaded []time.Time
+}
+
+// NewCertificates returns nil when TLS isn't configured, error when files can't be loaded
+func NewCertificates(config *config.TLSConfig) (*Certificates, error) {
+	if config.CertFile == "" {
+		return nil, nil
+	}
+	certificates := &Certificates{config: config}
+	if err := certificates.reload(); err != nil {
+		return nil, err
+	}
+	return certificates, nil
+}
+
+// TLSConfig for http.Server, handshake of each connection uses certificates current at that time
+func (certificates *Certificates) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tlsVersions[certificates.config.MinVersion],
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			return certificates.current.Load(), nil
+		},
+	}
+}
+
+// Run checks files every interval until ctx is done, certificate that fails to load is logged and the previous one kept
+func (certificates *Certificates) Run(ctx context.Context) {
+	ticker := time.NewTicker(cmp.Or(certificates.config.ReloadInterval, defaultReloadInterval))
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := certificates.reload(); err != nil {
+				slog.Error("tls certificates not reloaded", "error", err)
+			}
+		}
+	}
+}
+
+// reload loads files when any of them changed since the last load
+func (certificates *Certificates) reload() error {
+	modified, err := certificates.modified()
+	if err != nil {
+		return err
+	}
+	if slices.Equal(modified, certificates.loaded) {
+		return nil
+	}
+	tlsConfig, err := certificates.load()
+	if err != nil {
+		return err
+	}
+	certificates.current.Store(tlsConfig)
+	if certificates.loaded != nil {
+		slog.Info("tls certificates reloaded")
+	}
+	certificates.loaded = modified
+	return nil
+}
+
+func (certificates *Certificates) modified() ([]time.Time, error) {
+	files := []string{certificates.config.CertFile, certificates.config.KeyFile}
+	if certificates.config.ClientCAFile != "" {
+		files = append(files, certificates.config.ClientCAFile)
+	}
+	modified := make([]time.Time, len(files))
+	for i, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		modified[i] = info.ModTime()
+	}
+	return modified, nil
+}
+
+func (certificates *Certificates) load() (*tls.Config, error) {
+	cert, err := tls.LoadX509KeyPair(certificates.config.CertFile, certificates.config.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("error loading certificate: %w", err)
+	}
+	suites, err := cipherSuites(certificates.config.CipherSuites)
+	if err != nil {
+		return nil, err
+	}
+	tlsConfig := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+		MinVersion:   tlsVersions[certificates.config.MinVersion],
+		CipherSuites: suites,
+		ClientAuth:   clientAuthTypes[certificates.config.ClientAuth],
+		//config returned for client replaces server's one, including protocols it would negotiate
+		NextProtos: []string{"h2", "http/1.1"},
+	}
+	if certificates.config.ClientCAFile != "" {
+		bundle, err := os.ReadFile(certificates.config.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client CA bundle: %w", err)
+		}
+		tlsConfig.ClientCAs = x509.NewCertPool()
+		if !tlsConfig.ClientCAs.AppendCertsFromPEM(bundle) {
+			return nil, errors.New("no certificates in client CA bundle")
+		}
+	}
+	return tlsConfig, nil
+}
+
+// cipherSuites maps names to ids, go defaults are used when there are none
+func cipherSuites(names []string) ([]uint16, error) {
+	if len(names) == 0 {
+		return nil, nil
+	}
+	ids := make([]uint16, len(names))
+	for i, name := range names {
+		index := slices.IndexFunc(tls.CipherSuites(), func(suite *tls.CipherSuite) bool {
+			return suite.Name == name
+		})
+		if index < 0 {
+			return nil, fmt.Errorf("unknown or insecure cipher suite %s", name)
+		}
+		ids[i] = tls.CipherSuites()[index].ID
+	}
+	return ids, nil
+}
@@ -0,0 +1,179 @@
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"github.com/stretchr/testify/require"
+	"go-examples/rest/config"
+	"go-examples/rest/test"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCertificatesDisabled(t *testing.T) {
+	//when
+	certificates, err := NewCertificates(&config.TLSConfig{})
+
+	//then
+	require.NoError(t, err)
+	require.Nil(t, certificates)
+}
+
+func TestCertificatesRotated(t *testing.T) {
+	//given
+	ca := newCA(t)
+	tlsConfig := writeCertificate(t, ca, &config.TLSConfig{})
+	certificates, err := NewCertificates(tlsConfig)
+	require.NoError(t, err)
+	url := serve(t, certificates)
+	first := serverCertificate(t, url, ca)
+
+	//when certificate files are replaced
+	writeCertificate(t, ca, tlsConfig)
+	require.NoError(t, certificates.reload())
+
+	//then new connections get the new certificate
+	second := serverCertificate(t, url, ca)
+	require.NotEqual(t, first.SerialNumber, second.SerialNumber)
+}
+
+func TestCertificatesKeptWhenRotatedOnesInvalid(t *testing.T) {
+	//given
+	ca := newCA(t)
+	tlsConfig := writeCertificate(t, ca, &config.TLSConfig{})
+	certificates, err := NewCertificates(tlsConfig)
+	require.NoError(t, err)
+	url := serve(t, certificates)
+	first := serverCertificate(t, url, ca)
+
+	//when certificate file is replaced but key isn't yet
+	require.NoError(t, os.WriteFile(tlsConfig.CertFile, issue(t, ca, "other").PEM, 0600))
+	touch(t, tlsConfig.CertFile)
+	err = certificates.reload()
+
+	//then
+	require.Error(t, err)
+	require.Equal(t, first.SerialNumber, serverCertificate(t, url, ca).SerialNumber)
+}
+
+func TestClientCertificateRequired(t *testing.T) {
+	//given
+	ca := newCA(t)
+	tlsConfig := writeCertificate(t, ca, &config.TLSConfig{ClientAuth: "require", ClientCAFile: filepath.Join(t.TempDir(), "ca.pem")})
+	require.NoError(t, os.WriteFile(tlsConfig.ClientCAFile, ca.PEM, 0600))
+	certificates, err := NewCertificates(tlsConfig)
+	require.NoError(t, err)
+	url := serve(t, certificates)
+	client := issue(t, ca, "reporting")
+	untrusted := issue(t, newCA(t), "reporting")
+
+	//when
+	_, withoutCert := get(url, ca, nil)
+	_, withUntrustedCert := get(url, ca, untrusted)
+	subject, err := get(url, ca, client)
+
+	//then
+	require.Error(t, withoutCert)
+	require.Error(t, withUntrustedCert)
+	require.NoError(t, err)
+	require.Equal(t, "CN=reporting", subject)
+}
+
+func TestCertificatesRejectUnknownCipherSuite(t *testing.T) {
+	//given
+	tlsConfig := writeCertificate(t, newCA(t), &config.TLSConfig{CipherSuites: []string{"TLS_RSA_WITH_RC4_128_SHA"}})
+
+	//when
+	_, err := NewCertificates(tlsConfig)
+
+	//then
+	require.ErrorContains(t, err, "TLS_RSA_WITH_RC4_128_SHA")
+}
+
+// serve responds with subject of verified client certificate
+func serve(t *testing.T, certificates *Certificates) string {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	server := &http.Server{
+		TLSConfig: certificates.TLSConfig(),
+		Handler: http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+			if len(request.TLS.VerifiedChains) > 0 {
+				_, _ = io.WriteString(writer, request.TLS.VerifiedChains[0][0].Subject.String())
+			}
+		}),
+	}
+	go func() {
+		_ = server.ServeTLS(listener, "", "")
+	}()
+	t.Cleanup(func() {
+		_ = server.Close()
+	})
+	return "https://" + listener.Addr().String()
+}
+
+func serverCertificate(t *testing.T, url string, ca *test.CA) *x509.Certificate {
+	response, err := newClient(ca, nil).Get(url)
+	require.NoError(t, err)
+	defer response.Body.Close()
+	return response.TLS.PeerCertificates[0]
+}
+
+func get(url string, ca *test.CA, client *test.Certificate) (string, error) {
+	response, err := newClient(ca, client).Get(url)
+	if err != nil {
+		return "", err
+	}
+	defer response.Body.Close()
+	body, err := io.ReadAll(response.Body)
+	return string(body), err
+}
+
+// newClient doesn't reuse connections so each request makes new handshake
+func newClient(ca *test.CA, client *test.Certificate) *http.Client {
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.Cert)
+	tlsConfig := &tls.Config{RootCAs: roots}
+	if client != nil {
+		cert, _ := tls.X509KeyPair(client.PEM, client.KeyPEM)
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return &http.Client{Transport: &http.Transport{TLSClientConfig: tlsConfig, DisableKeepAlives: true}}
+}
+
+// writeCertificate issues server certificate into files of tlsConfig, new files are created when it has none
+func writeCertificate(t *testing.T, ca *test.CA, tlsConfig *config.TLSConfig) *config.TLSConfig {
+	if tlsConfig.CertFile == "" {
+		dir := t.TempDir()
+		tlsConfig.CertFile, tlsConfig.KeyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	}
+	cert := issue(t, ca, "localhost")
+	require.NoError(t, os.WriteFile(tlsConfig.CertFile, cert.PEM, 0600))
+	require.NoError(t, os.WriteFile(tlsConfig.KeyFile, cert.KeyPEM, 0600))
+	touch(t, tlsConfig.CertFile)
+	return tlsConfig
+}
+
+// touch moves modification time forward, file rewritten right after previous check could keep the same one
+func touch(t *testing.T, file string) {
+	info, err := os.Stat(file)
+	require.NoError(t, err)
+	require.NoError(t, os.Chtimes(file, time.Now(), info.ModTime().Add(time.Second)))
+}
+
+func newCA(t *testing.T) *test.CA {
+	ca, err := test.NewCA("test CA")
+	require.NoError(t, err)
+	return ca
+}
+
+func issue(t *testing.T, ca *test.CA, name string) *test.Certificate {
+	cert, err := ca.Issue(pkix.Name{CommonName: name})
+	require.NoError(t, err)
+	return cert
+}
@@ -0,0 +1,31 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"go-examples/rest/model"
+)
+
+type APIKeyRepositoryMock struct {
+	mock.Mock
+}
+
+func (a *APIKeyRepositoryMock) FindByKey(ctx context.Context, key string) (*model.APIKey, error) {
+	args := a.Called(key)
+	return args.Get(0).(*model.APIKey), args.Error(1)
+}
+
+func (a *APIKeyRepositoryMock) Create(ctx context.Context, postKey *model.PostAPIKey) (*model.CreatedAPIKey, error) {
+	args := a.Called(postKey)
+	return args.Get(0).(*model.CreatedAPIKey), args.Error(1)
+}
+
+func (a *APIKeyRepositoryMock) Rotate(ctx context.Context, id string) (*model.CreatedAPIKey, error) {
+	args := a.Called(id)
+	return args.Get(0).(*model.CreatedAPIKey), args.Error(1)
+}
+
+func (a *APIKeyRepositoryMock) Revoke(ctx context.Context, id string) error {
+	args := a.Called(id)
+	return args.Error(0)
+}
@@ -0,0 +1,15 @@
+package test
+
+import (
+	"github.com/stretchr/testify/mock"
+	"go-examples/rest/config"
+)
+
+type ConfigSourceMock struct {
+	mock.Mock
+}
+
+func (m *ConfigSourceMock) Get() *config.AppConfig {
+	args := m.Called()
+	return args.Get(0).(*config.AppConfig)
+}
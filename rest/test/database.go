@@ -30,3 +30,45 @@ func (m *DatabaseMock) Ping(c context.Context) error {
 	args := m.Called(c)
 	return args.Error(0)
 }
+
+func (m *DatabaseMock) Begin(c context.Context) (pgx.Tx, error) {
+	args := m.Called(c)
+	return args.Get(0).(pgx.Tx), args.Error(1)
+}
+
+func (m *DatabaseMock) BeginTx(c context.Context, opts pgx.TxOptions) (pgx.Tx, error) {
+	args := m.Called(c, opts)
+	return args.Get(0).(pgx.Tx), args.Error(1)
+}
+
+// TxMock mocks statements and commit of transaction, remaining pgx.Tx methods panic
+type TxMock struct {
+	mock.Mock
+	pgx.Tx
+}
+
+func (m *TxMock) Query(c context.Context, s string, a ...any) (pgx.Rows, error) {
+	args := m.Called(c, s, a)
+	return args.Get(0).(pgx.Rows), args.Error(1)
+}
+
+func (m *TxMock) Exec(c context.Context, s string, a ...any) (pgconn.CommandTag, error) {
+	args := m.Called(c, s, a)
+	return args.Get(0).(pgconn.CommandTag), args.Error(1)
+}
+
+func (m *TxMock) QueryRow(c context.Context, s string, a ...any) pgx.Row {
+	args := m.Called(c, s, a)
+	return args.Get(0).(pgx.Row)
+}
+
+func (m *TxMock) Commit(c context.Context) error {
+	args := m.Called(c)
+	return args.Error(0)
+}
+
+// Rollback is expected after commit as well, pgx makes it no-op then
+func (m *TxMock) Rollback(c context.Context) error {
+	args := m.Called(c)
+	return args.Error(0)
+}
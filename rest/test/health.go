@@ -0,0 +1,16 @@
+package test
+
+import (
+	"context"
+	"github.com/stretchr/testify/mock"
+	"go-examples/rest/model"
+)
+
+type ReadinessCheckerMock struct {
+	mock.Mock
+}
+
+func (m *ReadinessCheckerMock) Ready(c context.Context) model.Probe {
+	args := m.Called(c)
+	return args.Get(0).(model.Probe)
+}
@@ -2,17 +2,25 @@ package test
 
 import (
 	"context"
+	"errors"
 	"github.com/stretchr/testify/mock"
 	"go-examples/rest/model"
+	"io"
+	"time"
 )
 
 type UserRepositoryMock struct {
 	mock.Mock
 }
 
-func (u *UserRepositoryMock) GetAllUsers(ctx context.Context) ([]*model.User, error) {
-	args := u.Called()
-	return args.Get(0).([]*model.User), args.Error(1)
+func (u *UserRepositoryMock) GetAllUsers(ctx context.Context, query *model.UserQuery, consume func(*model.User) error) (string, error) {
+	args := u.Called(query)
+	for _, user := range args.Get(0).([]*model.User) {
+		if err := consume(user); err != nil {
+			return "", err
+		}
+	}
+	return args.String(1), args.Error(2)
 }
 
 func (u *UserRepositoryMock) GetUserById(ctx context.Context, id string) (*model.User, error) {
@@ -20,13 +28,23 @@ func (u *UserRepositoryMock) GetUserById(ctx context.Context, id string) (*model
 	return args.Get(0).(*model.User), args.Error(1)
 }
 
+func (u *UserRepositoryMock) GetLatestUserById(ctx context.Context, id string) (*model.User, error) {
+	args := u.Called(id)
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
 func (u *UserRepositoryMock) Save(ctx context.Context, user *model.PostUser) (*model.User, error) {
 	args := u.Called(user)
 	return args.Get(0).(*model.User), args.Error(1)
 }
 
-func (u *UserRepositoryMock) Update(ctx context.Context, id string, user *model.PostUser) (*model.User, error) {
-	args := u.Called(id, user)
+func (u *UserRepositoryMock) Update(ctx context.Context, id string, user *model.PostUser, version int) (*model.User, error) {
+	args := u.Called(id, user, version)
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+func (u *UserRepositoryMock) Patch(ctx context.Context, id string, patch *model.UserPatch, version int) (*model.User, error) {
+	args := u.Called(id, patch, version)
 	return args.Get(0).(*model.User), args.Error(1)
 }
 
@@ -35,7 +53,49 @@ func (u *UserRepositoryMock) Exists(ctx context.Context, id string) (bool, error
 	return args.Bool(0), args.Error(1)
 }
 
-func (u *UserRepositoryMock) Delete(ctx context.Context, id string) error {
-	args := u.Called(id)
+func (u *UserRepositoryMock) Delete(ctx context.Context, id string, version int) error {
+	args := u.Called(id, version)
 	return args.Error(0)
 }
+
+func (u *UserRepositoryMock) Restore(ctx context.Context, id string) (*model.User, error) {
+	args := u.Called(id)
+	return args.Get(0).(*model.User), args.Error(1)
+}
+
+func (u *UserRepositoryMock) Purge(ctx context.Context, retention time.Duration) (int64, error) {
+	args := u.Called(retention)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (u *UserRepositoryMock) GetUserAudit(ctx context.Context, id string, query *model.AuditQuery) (*model.AuditPage, error) {
+	args := u.Called(id, query)
+	return args.Get(0).(*model.AuditPage), args.Error(1)
+}
+
+// Import drains next same way repository does, mock is called with all rows read
+func (u *UserRepositoryMock) Import(ctx context.Context, next func() (*model.PostUser, error)) (int64, error) {
+	var users []*model.PostUser
+	for {
+		user, err := next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+		users = append(users, user)
+	}
+	args := u.Called(users)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func (u *UserRepositoryMock) ExportUsers(ctx context.Context, consume func(*model.User) error) error {
+	args := u.Called()
+	for _, user := range args.Get(0).([]*model.User) {
+		if err := consume(user); err != nil {
+			return err
+		}
+	}
+	return args.Error(1)
+}